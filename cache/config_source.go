@@ -0,0 +1,55 @@
+package cache
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/knadh/koanf/maps"
+	"github.com/redis/go-redis/v9"
+	"strings"
+)
+
+// HashSource is a config.RemoteSource that reads the fields of a redis hash as koanf keys, e.g. the field
+// "webSites" holding a json array of the site definitions. A value starting with '{' or '[' is decoded
+// as json, the other values are kept as strings.
+type HashSource struct {
+	client  *redis.Client
+	hashKey string
+	prefix  string
+}
+
+// NewHashSource creates a source of the hash, the prefix is prepended to every field if it's not empty
+func NewHashSource(client *redis.Client, hashKey string, prefix string) *HashSource {
+	return &HashSource{client: client, hashKey: hashKey, prefix: prefix}
+}
+
+func (h *HashSource) Name() string {
+	return "redis:" + h.hashKey
+}
+
+func (h *HashSource) Read(ctx context.Context) (map[string]any, error) {
+	fields, err := h.client.HGetAll(ctx, h.hashKey).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	values := make(map[string]any, len(fields))
+	for field, raw := range fields {
+		key := field
+		if h.prefix != "" {
+			key = h.prefix + "." + field
+		}
+
+		trimmed := strings.TrimSpace(raw)
+		if strings.HasPrefix(trimmed, "{") || strings.HasPrefix(trimmed, "[") {
+			var decoded any
+			if err = json.Unmarshal([]byte(trimmed), &decoded); err != nil {
+				return nil, fmt.Errorf("invalid json in field %s of hash %s: %w", field, h.hashKey, err)
+			}
+			values[key] = decoded
+			continue
+		}
+		values[key] = raw
+	}
+	return maps.Unflatten(values, "."), nil
+}
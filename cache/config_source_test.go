@@ -0,0 +1,43 @@
+package cache
+
+import (
+	"context"
+	"reflect"
+	"testing"
+)
+
+func TestHashSourceRead(t *testing.T) {
+	rd, server := newTestRedis(t)
+	server.HSet("crawler:config", "webSites", `[{"name": "site-a", "attributes": {"lang": "zh"}}]`)
+	server.HSet("crawler:config", "taskPool.capacity", "20")
+
+	src := NewHashSource(rd.Client, "crawler:config", "")
+	if src.Name() != "redis:crawler:config" {
+		t.Fatalf("unexpected name: %s", src.Name())
+	}
+	values, err := src.Read(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]any{
+		"webSites": []any{map[string]any{"name": "site-a", "attributes": map[string]any{"lang": "zh"}}},
+		"taskPool": map[string]any{"capacity": "20"},
+	}
+	if !reflect.DeepEqual(values, expected) {
+		t.Fatalf("unexpected values: %v", values)
+	}
+
+	// the fields are nested under the prefix
+	values, err = NewHashSource(rd.Client, "crawler:config", "crawlerSettings").Read(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := values["crawlerSettings"].(map[string]any)["webSites"]; !ok {
+		t.Fatalf("unexpected values: %v", values)
+	}
+
+	server.HSet("crawler:config", "broken", `{"name": `)
+	if _, err = src.Read(context.Background()); err == nil {
+		t.Fatal("the invalid json should be reported")
+	}
+}
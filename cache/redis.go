@@ -7,17 +7,43 @@ import (
 	"github.com/duke-git/lancet/v2/convertor"
 	"github.com/google/uuid"
 	"github.com/jeven2016/mylibs/config"
+	"github.com/jeven2016/mylibs/log"
+	"github.com/jeven2016/mylibs/metrics"
+	"github.com/jeven2016/mylibs/tracing"
 	"github.com/redis/go-redis/v9"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 	"go.uber.org/zap"
 	"reflect"
+	"sort"
+	"strings"
+	"sync"
 	"time"
 )
 
 const RedisStreamDataVar = "data"
 
+// maxConsumeBlock bounds how long a read of the stream blocks, go-redis doesn't interrupt a blocking read
+// once the context is canceled, so the consumer only notices the cancellation between the reads
+const maxConsumeBlock = 2 * time.Second
+
 type Redis struct {
 	Client *redis.Client
 	config *config.RedisConfig
+
+	// the consumer groups used by this client, their stats are reported by StreamStats
+	groupLock sync.Mutex
+	groups    map[string]map[string]struct{}
+}
+
+// StreamStat is the backlog of a consumer group
+type StreamStat struct {
+	Stream  string `json:"stream"`
+	Group   string `json:"group"`
+	Len     int64  `json:"len"`
+	Pending int64  `json:"pending"`
+	Lag     int64  `json:"lag"`
 }
 
 func NewRedis(ctx context.Context, redisCfg *config.RedisConfig) (*Redis, error) {
@@ -37,26 +63,20 @@ func NewRedis(ctx context.Context, redisCfg *config.RedisConfig) (*Redis, error)
 	rd := &Redis{
 		Client: client,
 		config: redisCfg,
+		groups: map[string]map[string]struct{}{},
 	}
 	return rd, nil
 }
 
+// EnsureConsumeGroupCreated creates the consumer group reading the stream from the beginning, the stream
+// is created if it doesn't exist. It's fine if the group exists already.
 func (rd *Redis) EnsureConsumeGroupCreated(ctx context.Context, streamName string, group string) error {
-	if groups, err := rd.Client.XInfoGroups(context.Background(), streamName).Result(); err == nil {
-		return nil
-	} else {
-		//当无法获取到group信息时，创建一个消费group
-		for _, g := range groups {
-			if g.Name == group {
-				return nil
-			}
-		}
-
-		//You can use the XGROUP CREATE command with MKSTREAM option, to create an empty stream
-		//XGroupCreate 方法要求先有stream的存在才能创建group
-		if err = rd.Client.XGroupCreateMkStream(ctx, streamName, group, "0").Err(); err != nil {
-			return err
-		}
+	rd.trackGroup(streamName, group)
+	//You can use the XGROUP CREATE command with MKSTREAM option, to create an empty stream
+	//XGroupCreate 方法要求先有stream的存在才能创建group
+	err := rd.Client.XGroupCreateMkStream(ctx, streamName, group, "0").Err()
+	if err != nil && !strings.HasPrefix(err.Error(), "BUSYGROUP") {
+		return err
 	}
 	return nil
 }
@@ -79,8 +99,16 @@ func (rd *Redis) PublishMessage(ctx context.Context, data interface{}, streamNam
 		return errors.New(fmt.Sprintf("unable convert data into json, stream: %s", streamName))
 	}
 
+	ctx, span := tracing.Tracer().Start(ctx, "publish "+streamName, trace.WithSpanKind(trace.SpanKindProducer),
+		trace.WithAttributes(attribute.String("messaging.destination.name", streamName)))
+	defer span.End()
+
 	//just send the json data into stream since it's too complicated to map a struct to map, there would be
-	//different kind of exceptions that need to handle
+	//different kind of exceptions that need to handle, the trace context is carried by the other fields
+	values := map[string]string{
+		RedisStreamDataVar: json,
+	}
+	tracing.InjectFields(ctx, values)
 	err = rd.Client.XAdd(ctx, &redis.XAddArgs{
 		Stream:     streamName,
 		NoMkStream: false, // * 默认false,当为false时,key不存在，会新建
@@ -89,25 +117,142 @@ func (rd *Redis) PublishMessage(ctx context.Context, data interface{}, streamNam
 		ID:         "*",   // 消息 id，我们使用 * 表示由 redis 生成
 		// MinID: "id",            // * 超过阈值，丢弃设置的小于MinID消息id【基本不用】
 		// Limit: 1000,            // * 限制长度【基本不用】
-		Values: map[string]string{
-			RedisStreamDataVar: json,
-		}}).Err()
+		Values: values,
+	}).Err()
+
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return err
+	}
+	metrics.StreamPublished(streamName)
+	return nil
+}
 
-	return err
+// StreamMessage is a message read from a stream, the Context carries the span of the consumer which is
+// the child of the publisher's span
+type StreamMessage struct {
+	ID      string
+	Data    string
+	Context context.Context
+
+	// acks the message if it's consumed by WithManualAck
+	ack func(ctx context.Context) error
+}
+
+// Ack acknowledges a message consumed by WithManualAck, the message is acked on delivery otherwise and
+// it does nothing
+func (m *StreamMessage) Ack(ctx context.Context) error {
+	if m.ack == nil {
+		return nil
+	}
+	return m.ack(ctx)
 }
 
+// ConsumeOption changes how ConsumeMessages reads a stream
+type ConsumeOption func(o *consumeOptions)
+
+type consumeOptions struct {
+	manualAck bool
+	claimIdle time.Duration
+}
+
+// WithManualAck leaves a message pending until StreamMessage.Ack is called, e.g. once it's handled, so it
+// isn't lost if the consumer crashes meanwhile. It should be used with WithClaimIdle to deliver the
+// pending messages of the crashed consumers again.
+func WithManualAck() ConsumeOption {
+	return func(o *consumeOptions) {
+		o.manualAck = true
+	}
+}
+
+// WithClaimIdle claims the messages of the group pending for d at least, i.e. delivered to a consumer
+// which didn't ack them, and delivers them again before the new ones. d should be longer than handling
+// a message takes, the stream is polled every d at least.
+func WithClaimIdle(d time.Duration) ConsumeOption {
+	return func(o *consumeOptions) {
+		o.claimIdle = d
+	}
+}
+
+// Consume reads the messages of the group and sends the data field into the channel, the channel is
+// closed once the context is canceled or an error occurs
 func (rd *Redis) Consume(ctx context.Context, streamName string,
 	consumerGroup string, msgChan chan<- interface{}) error {
+	defer close(msgChan)
+	return rd.consume(ctx, streamName, consumerGroup, &consumeOptions{},
+		func(msgCtx context.Context, message redis.XMessage, ack func(ctx context.Context) error) {
+			msgChan <- message.Values[RedisStreamDataVar]
+		})
+}
+
+// ConsumeMessages is the same as Consume except that the messages are sent with their ids and the trace
+// context propagated from the publisher
+func (rd *Redis) ConsumeMessages(ctx context.Context, streamName string,
+	consumerGroup string, msgChan chan<- *StreamMessage, opts ...ConsumeOption) error {
+	defer close(msgChan)
+	options := &consumeOptions{}
+	for _, opt := range opts {
+		opt(options)
+	}
+	return rd.consume(ctx, streamName, consumerGroup, options,
+		func(msgCtx context.Context, message redis.XMessage, ack func(ctx context.Context) error) {
+			data, _ := message.Values[RedisStreamDataVar].(string)
+			msgChan <- &StreamMessage{ID: message.ID, Data: data, Context: msgCtx, ack: ack}
+		})
+}
+
+func (rd *Redis) consume(ctx context.Context, streamName string, consumerGroup string, options *consumeOptions,
+	deliver func(msgCtx context.Context, message redis.XMessage, ack func(ctx context.Context) error)) error {
 	defer func() {
 		zap.S().Info("closing redis stream")
-		close(msgChan)
-		if err := recover(); err != nil {
-			zap.S().Errorf("an unexpected error occurs during fetching data form stream, %v", err)
+		if r := recover(); r != nil {
+			zap.S().Errorf("an unexpected error occurs during fetching data form stream, %v", r)
 		}
 	}()
 
+	rd.trackGroup(streamName, consumerGroup)
 	prefix := uuid.New().String()[:8]
 	consumerId := streamName + ":consumer:" + prefix
+
+	process := func(message redis.XMessage) {
+		msgCtx, span := tracing.Tracer().Start(tracing.ExtractFields(ctx, message.Values),
+			"consume "+streamName, trace.WithSpanKind(trace.SpanKindConsumer),
+			trace.WithAttributes(
+				attribute.String("messaging.destination.name", streamName),
+				attribute.String("messaging.consumer.group.name", consumerGroup),
+				attribute.String("messaging.message.id", message.ID),
+			))
+		defer span.End()
+
+		ack := func(ctx context.Context) error {
+			if err := rd.Client.XAck(ctx, streamName, consumerGroup, message.ID).Err(); err != nil {
+				return err
+			}
+			metrics.StreamAcked(streamName, consumerGroup)
+			return nil
+		}
+		if options.manualAck {
+			deliver(msgCtx, message, ack)
+		} else {
+			deliver(msgCtx, message, nil)
+		}
+		metrics.StreamConsumed(streamName, consumerGroup)
+		log.WithContext(msgCtx).Info("retrieve a message into channel")
+
+		//如果系统退出，此处chan中的已经Ack过的消息将无法保证正确处理，会出现丢失，WithManualAck避免这种情况
+		if !options.manualAck {
+			_ = ack(ctx)
+		}
+	}
+
+	// a read is bounded by maxConsumeBlock so that the cancellation is noticed in time, the pending
+	// messages are claimed every claimIdle if it's set
+	block := maxConsumeBlock
+	if options.claimIdle > 0 && options.claimIdle < block {
+		block = options.claimIdle
+	}
+	var lastClaim time.Time
 loop:
 	for {
 		select {
@@ -115,27 +260,50 @@ loop:
 			zap.S().Info("stop fetching while context canceled ")
 			break loop
 		default:
+			if options.claimIdle > 0 && time.Since(lastClaim) >= options.claimIdle {
+				lastClaim = time.Now()
+				claimed, _, err := rd.Client.XAutoClaim(ctx, &redis.XAutoClaimArgs{
+					Stream:   streamName,
+					Group:    consumerGroup,
+					Consumer: consumerId,
+					MinIdle:  options.claimIdle,
+					Start:    "0-0",
+					Count:    10,
+				}).Result()
+				if err != nil && ctx.Err() == nil {
+					zap.S().Errorf("failed to handle XAutoClaim, %v", err)
+					return err
+				}
+				for _, message := range claimed {
+					process(message)
+				}
+				continue
+			}
+
 			entries, err := rd.Client.XReadGroup(ctx, &redis.XReadGroupArgs{
 				Group:    consumerGroup,
 				Consumer: consumerId,
 
 				Streams: []string{streamName, ">"},
 				Count:   1,
-				Block:   0,
+				Block:   block,
 			}).Result()
+			if errors.Is(err, redis.Nil) {
+				// no message arrives within the block time
+				continue
+			}
 			if err != nil {
+				if ctx.Err() != nil {
+					break loop
+				}
 				zap.S().Errorf("failed to handle XReadGroup, %v", err)
 				return err
 			}
 
-			//如果系统退出，此处chan中的已经Ack过的消息将无法保证正确处理，会出现丢失 todo enhancement
-			for i := 0; i < len(entries[0].Messages); i++ {
-				messageID := entries[0].Messages[i].ID
-				jsonData := entries[0].Messages[i].Values[RedisStreamDataVar]
-				msgChan <- jsonData
-				zap.L().Info("retrieve a message into channel")
-
-				rd.Client.XAck(ctx, streamName, consumerGroup, messageID)
+			for _, entry := range entries {
+				for _, message := range entry.Messages {
+					process(message)
+				}
 			}
 		}
 	}
@@ -150,3 +318,60 @@ func (rd *Redis) Len(ctx context.Context, streamName string) (int64, error) {
 	}
 	return streamLen, err
 }
+
+func (rd *Redis) trackGroup(streamName string, group string) {
+	rd.groupLock.Lock()
+	defer rd.groupLock.Unlock()
+	if rd.groups == nil {
+		rd.groups = map[string]map[string]struct{}{}
+	}
+	if rd.groups[streamName] == nil {
+		rd.groups[streamName] = map[string]struct{}{}
+	}
+	rd.groups[streamName][group] = struct{}{}
+}
+
+// StreamStats returns the length of the streams consumed by this client and the pending and lag of
+// their groups, the lag is only reported by redis 7 or above
+func (rd *Redis) StreamStats(ctx context.Context) ([]StreamStat, error) {
+	rd.groupLock.Lock()
+	tracked := make(map[string]map[string]struct{}, len(rd.groups))
+	for stream, groups := range rd.groups {
+		tracked[stream] = make(map[string]struct{}, len(groups))
+		for group := range groups {
+			tracked[stream][group] = struct{}{}
+		}
+	}
+	rd.groupLock.Unlock()
+
+	// an empty list rather than null is returned if no group is consumed
+	stats := make([]StreamStat, 0)
+	for stream, groups := range tracked {
+		streamLen, err := rd.Len(ctx, stream)
+		if err != nil {
+			return nil, err
+		}
+		infos, err := rd.Client.XInfoGroups(ctx, stream).Result()
+		if err != nil {
+			return nil, err
+		}
+		for _, info := range infos {
+			if _, ok := groups[info.Name]; ok {
+				stats = append(stats, StreamStat{
+					Stream:  stream,
+					Group:   info.Name,
+					Len:     streamLen,
+					Pending: info.Pending,
+					Lag:     info.Lag,
+				})
+			}
+		}
+	}
+	sort.Slice(stats, func(i, j int) bool {
+		if stats[i].Stream != stats[j].Stream {
+			return stats[i].Stream < stats[j].Stream
+		}
+		return stats[i].Group < stats[j].Group
+	})
+	return stats, nil
+}
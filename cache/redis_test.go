@@ -0,0 +1,122 @@
+package cache
+
+import (
+	"context"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/jeven2016/mylibs/config"
+	"github.com/redis/go-redis/v9"
+	"testing"
+	"time"
+)
+
+func newTestRedis(t *testing.T) (*Redis, *miniredis.Miniredis) {
+	server := miniredis.RunT(t)
+	rd, err := NewRedis(context.Background(), &config.RedisConfig{Address: server.Addr(), PoolSize: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = rd.Client.Close() })
+	return rd, server
+}
+
+func TestEnsureConsumeGroupCreatedOnExistingStream(t *testing.T) {
+	rd, _ := newTestRedis(t)
+	ctx := context.Background()
+
+	// the messages are published before the consumer starts, so the stream exists without the group
+	if err := rd.PublishMessage(ctx, "chapter-1", "chapters"); err != nil {
+		t.Fatal(err)
+	}
+	if err := rd.EnsureConsumeGroupCreated(ctx, "chapters", "crawler"); err != nil {
+		t.Fatal(err)
+	}
+	// it's fine to ensure the group again
+	if err := rd.EnsureConsumeGroupCreated(ctx, "chapters", "crawler"); err != nil {
+		t.Fatal(err)
+	}
+
+	entries, err := rd.Client.XReadGroup(ctx, &redis.XReadGroupArgs{
+		Group:    "crawler",
+		Consumer: "test",
+		Streams:  []string{"chapters", ">"},
+		Count:    1,
+		Block:    -1,
+	}).Result()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || len(entries[0].Messages) != 1 ||
+		entries[0].Messages[0].Values[RedisStreamDataVar] != "chapter-1" {
+		t.Fatalf("the message published before the group is created should be read: %v", entries)
+	}
+
+	// a new stream is created with the group
+	if err = rd.EnsureConsumeGroupCreated(ctx, "novels", "crawler"); err != nil {
+		t.Fatal(err)
+	}
+	if groups, err := rd.Client.XInfoGroups(ctx, "novels").Result(); err != nil || len(groups) != 1 {
+		t.Fatalf("the group of the new stream should be created: %v, %v", groups, err)
+	}
+}
+
+func TestStreamStatsWithoutGroups(t *testing.T) {
+	rd, _ := newTestRedis(t)
+	stats, err := rd.StreamStats(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	// it's served as [] rather than null
+	if stats == nil || len(stats) != 0 {
+		t.Fatalf("unexpected stats: %v", stats)
+	}
+}
+
+func TestConsumeMessagesReturnsOnceCanceled(t *testing.T) {
+	rd, _ := newTestRedis(t)
+	if err := rd.EnsureConsumeGroupCreated(context.Background(), "chapters", "crawler"); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		// the claims are far apart, the idle stream mustn't keep the consumer blocked as long
+		done <- rd.ConsumeMessages(ctx, "chapters", "crawler", make(chan *StreamMessage),
+			WithManualAck(), WithClaimIdle(5*time.Minute))
+	}()
+
+	time.Sleep(100 * time.Millisecond)
+	cancel()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(maxConsumeBlock + time.Second):
+		t.Fatal("the consumer blocked on the empty stream should return once it's canceled")
+	}
+}
+
+func TestConsumeReturnsOnceCanceled(t *testing.T) {
+	rd, _ := newTestRedis(t)
+	if err := rd.EnsureConsumeGroupCreated(context.Background(), "chapters", "crawler"); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		done <- rd.Consume(ctx, "chapters", "crawler", make(chan interface{}))
+	}()
+
+	time.Sleep(100 * time.Millisecond)
+	cancel()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(maxConsumeBlock + time.Second):
+		t.Fatal("the consumer blocked on the empty stream should return once it's canceled")
+	}
+}
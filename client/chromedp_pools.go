@@ -2,65 +2,113 @@ package client
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"github.com/chromedp/chromedp"
+	"github.com/jeven2016/mylibs/metrics"
 	"go.uber.org/zap"
 	"sync"
+	"sync/atomic"
 )
 
 type ChromePool struct {
-	pool       *sync.Pool
+	pool    *sync.Pool
+	closed  atomic.Bool
+	created atomic.Int64
+	inUse   atomic.Int64
+
+	lock       sync.Mutex
 	deferFuncs []func()
+	// the instances created by the pool, they're probed by HealthCheck without being checked out
+	instances []context.Context
+}
+
+// ChromePoolStat is the state of a chrome pool
+type ChromePoolStat struct {
+	Closed  bool  `json:"closed"`
+	Created int64 `json:"created"`
+	InUse   int64 `json:"inUse"`
 }
 
 func NewChromePool() *ChromePool {
-	var cleanFunc []func()
-	pool := &ChromePool{
-		pool: &sync.Pool{
-			New: func() any {
-				// 创建一个自定义的Chrome选项
-				opts := append(chromedp.DefaultExecAllocatorOptions[:],
-					chromedp.Flag("headless", true), // 取消headless模式
-				)
-
-				// 创建一个自定义的Chrome执行器
-				allocCtx, cancelAlloc := chromedp.NewExecAllocator(context.Background(), opts...)
-
-				// 使用自定义的执行器创建新的上下文
-				ctx, chdCancel := chromedp.NewContext(allocCtx)
-
-				cleanFunc = append(cleanFunc, func() {
-					chdCancel()
-					cancelAlloc()
-				})
-				return ctx
-			},
+	pool := &ChromePool{}
+	pool.pool = &sync.Pool{
+		New: func() any {
+			pool.created.Add(1)
+			// 创建一个自定义的Chrome选项
+			opts := append(chromedp.DefaultExecAllocatorOptions[:],
+				chromedp.Flag("headless", true), // 取消headless模式
+			)
+
+			// 创建一个自定义的Chrome执行器
+			allocCtx, cancelAlloc := chromedp.NewExecAllocator(context.Background(), opts...)
+
+			// 使用自定义的执行器创建新的上下文
+			ctx, chdCancel := chromedp.NewContext(allocCtx)
+
+			pool.lock.Lock()
+			defer pool.lock.Unlock()
+			pool.instances = append(pool.instances, ctx)
+			pool.deferFuncs = append(pool.deferFuncs, func() {
+				chdCancel()
+				cancelAlloc()
+			})
+			return ctx
 		},
 	}
-	pool.deferFuncs = cleanFunc
 	return pool
 }
 
 func (c *ChromePool) Close() {
+	c.closed.Store(true)
 	zap.L().Info("Closing Chrome processes")
+	c.lock.Lock()
+	defer c.lock.Unlock()
 	for _, f := range c.deferFuncs {
 		f()
 	}
 }
 
 func (c *ChromePool) GetInstance() context.Context {
+	metrics.ChromeCheckedOut()
+	c.inUse.Add(1)
 	return c.pool.Get().(context.Context)
 }
 func (c *ChromePool) PutInstance(instance context.Context) {
+	metrics.ChromeReturned()
+	c.inUse.Add(-1)
 	c.pool.Put(instance)
 }
 
+// HealthCheck fails if the pool is closed or the browser of an instance is gone, it can be registered
+// as a readiness check by StartupParams.HealthChecks. The instances are probed without being checked out,
+// so no browser is started by the check.
+func (c *ChromePool) HealthCheck(ctx context.Context) error {
+	if c.closed.Load() {
+		return errors.New("chrome pool is closed")
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	for _, instance := range c.instances {
+		if err := instance.Err(); err != nil {
+			return fmt.Errorf("the browser of a chrome instance is gone: %w", err)
+		}
+	}
+	return ctx.Err()
+}
+
+// Stats returns the number of the browser instances created and checked out
+func (c *ChromePool) Stats() ChromePoolStat {
+	return ChromePoolStat{Closed: c.closed.Load(), Created: c.created.Load(), InUse: c.inUse.Load()}
+}
+
 func OpenChrome(cnt context.Context) (ctx context.Context, cleanFunc func()) {
 	var customOpts = []chromedp.ExecAllocatorOption{
 		chromedp.Flag("headless", true),
 	}
 
 	// 创建一个自定义的Chrome选项
-	opts := append(chromedp.DefaultExecAllocatorOptions[:])
+	opts := chromedp.DefaultExecAllocatorOptions[:]
 	customOpts = append(customOpts, chromedp.Flag("proxy-server", "http://localhot:10809")) //todo
 	//set http proxy
 	//if proxy := GetConfig().Http.Proxy; proxy != "" {
@@ -271,3 +271,28 @@ func TestNotReady(t *testing.T) {
 	time.Sleep(5 * time.Second)
 
 }
+
+func TestChromePoolHealthCheck(t *testing.T) {
+	pool := NewChromePool()
+	if err := pool.HealthCheck(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	// the check neither starts a browser nor checks out an instance
+	if stat := pool.Stats(); stat.Created != 0 || stat.InUse != 0 {
+		t.Fatalf("unexpected stat: %+v", stat)
+	}
+
+	// the browser is only started by the first run of the instance
+	pool.PutInstance(pool.GetInstance())
+	if err := pool.HealthCheck(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if stat := pool.Stats(); stat.Created != 1 || stat.InUse != 0 {
+		t.Fatalf("unexpected stat: %+v", stat)
+	}
+
+	pool.Close()
+	if err := pool.HealthCheck(context.Background()); err == nil {
+		t.Fatal("the closed pool should be unhealthy")
+	}
+}
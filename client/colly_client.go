@@ -4,6 +4,8 @@ import (
 	"fmt"
 	"github.com/gocolly/colly/v2"
 	"github.com/gocolly/colly/v2/extensions"
+	"github.com/jeven2016/mylibs/metrics"
+	"github.com/jeven2016/mylibs/tracing"
 	"go.uber.org/zap"
 	"net"
 	"net/http"
@@ -111,6 +113,9 @@ func NewCollector(httpProxy string, maxRetries int) (c *colly.Collector, err err
 		fmt.Println("[Visiting]", r.URL.String())
 	})
 
+	metrics.InstrumentColly(c)
+	tracing.InstrumentColly(c)
+
 	// 随机设置
 	extensions.RandomUserAgent(c)
 	extensions.Referer(c)
@@ -0,0 +1,90 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"github.com/go-resty/resty/v2"
+	"github.com/jeven2016/mylibs/discovery"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync/atomic"
+)
+
+// ServiceScheme is the scheme of the urls resolved by the discovery, e.g. service://novel-api/novels/1
+const ServiceScheme = "service"
+
+type pickKey struct{}
+
+// pick is the instance selected for an attempt of a request
+type pick struct {
+	// the url before being resolved, it's resolved again on retries
+	serviceUrl string
+	instance   string
+	done       atomic.Bool
+}
+
+// UseDiscovery resolves the urls like service://novel-api/path to a live instance of the service, the
+// instances are picked by the balancer and the failed ones (transport errors or 5xx) are reported so
+// that they are ejected for a while. The resolved url uses http unless targetScheme is set.
+func UseDiscovery(c *resty.Client, d discovery.Discovery, balancer *discovery.Balancer, targetScheme string) {
+	if targetScheme == "" {
+		targetScheme = "http"
+	}
+
+	c.OnBeforeRequest(func(c *resty.Client, r *resty.Request) error {
+		serviceUrl := r.URL
+		if p, ok := r.Context().Value(pickKey{}).(*pick); ok {
+			// a retry of the request, the previous attempt is reported if it isn't yet
+			serviceUrl = p.serviceUrl
+			done(balancer, p, fmt.Errorf("retried"))
+		}
+		if !strings.HasPrefix(serviceUrl, ServiceScheme+"://") {
+			return nil
+		}
+
+		u, err := url.Parse(serviceUrl)
+		if err != nil {
+			return err
+		}
+		instances, err := d.Instances(r.Context(), u.Host)
+		if err != nil {
+			return err
+		}
+		instance, err := balancer.Pick(u.Host, instances)
+		if err != nil {
+			return err
+		}
+
+		p := &pick{serviceUrl: serviceUrl, instance: instance}
+		r.SetContext(context.WithValue(r.Context(), pickKey{}, p))
+		u.Scheme = targetScheme
+		u.Host = instance
+		r.URL = u.String()
+		return nil
+	})
+
+	c.OnAfterResponse(func(c *resty.Client, resp *resty.Response) error {
+		if p, ok := resp.Request.Context().Value(pickKey{}).(*pick); ok {
+			var err error
+			if resp.StatusCode() >= http.StatusInternalServerError {
+				err = fmt.Errorf("unexpected status %d", resp.StatusCode())
+			}
+			done(balancer, p, err)
+		}
+		return nil
+	})
+
+	c.OnError(func(r *resty.Request, err error) {
+		if p, ok := r.Context().Value(pickKey{}).(*pick); ok {
+			done(balancer, p, err)
+		}
+	})
+}
+
+// done reports the result of an attempt exactly once
+func done(balancer *discovery.Balancer, p *pick, err error) {
+	if p.done.CompareAndSwap(false, true) {
+		balancer.Done(p.instance, err)
+	}
+}
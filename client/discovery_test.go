@@ -0,0 +1,90 @@
+package client
+
+import (
+	"github.com/go-resty/resty/v2"
+	"github.com/jeven2016/mylibs/discovery"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestUseDiscoveryResolvesServiceUrl(t *testing.T) {
+	healthy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(r.URL.Path + "?" + r.URL.RawQuery))
+	}))
+	defer healthy.Close()
+	broken := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer broken.Close()
+
+	instances := discovery.Static{"novel-api": {
+		strings.TrimPrefix(broken.URL, "http://"),
+		strings.TrimPrefix(healthy.URL, "http://"),
+	}}
+	balancer := discovery.NewBalancer(discovery.RoundRobin, discovery.WithEjection(1, time.Minute))
+
+	c := resty.New()
+	UseDiscovery(c, instances, balancer, "")
+
+	// the first request goes to the broken instance and ejects it
+	resp, err := c.R().Get("service://novel-api/novels/1?page=2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode() != http.StatusBadGateway {
+		t.Fatalf("unexpected status: %d", resp.StatusCode())
+	}
+	if !balancer.Ejected(strings.TrimPrefix(broken.URL, "http://")) {
+		t.Fatal("the broken instance should be ejected")
+	}
+
+	for i := 0; i < 3; i++ {
+		resp, err = c.R().Get("service://novel-api/novels/1?page=2")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.String() != "/novels/1?page=2" {
+			t.Fatalf("unexpected response: %d %s", resp.StatusCode(), resp.String())
+		}
+	}
+
+	if _, err = c.R().Get("service://unknown/path"); err == nil {
+		t.Fatal("an error is expected for an unknown service")
+	}
+}
+
+func TestGetRestyClientOfServiceUrl(t *testing.T) {
+	if _, err := GetRestyClient("service://catalog/novels", false); err == nil {
+		t.Fatal("the service url should be rejected without the discovery")
+	}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(r.URL.Path))
+	}))
+	defer server.Close()
+	SetRestyDiscovery(discovery.Static{"catalog": {strings.TrimPrefix(server.URL, "http://")}},
+		discovery.NewBalancer(discovery.RoundRobin), "")
+	defer func() {
+		restyLock.Lock()
+		restyDiscovery = nil
+		restyLock.Unlock()
+	}()
+
+	c, err := GetRestyClient("service://catalog/novels", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if same, _ := GetRestyClient("service://catalog/chapters", false); same != c {
+		t.Fatal("the client should be shared by the service")
+	}
+	resp, err := c.R().Get("service://catalog/novels/1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.String() != "/novels/1" {
+		t.Fatalf("unexpected response: %d %s", resp.StatusCode(), resp.String())
+	}
+}
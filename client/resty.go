@@ -5,8 +5,13 @@ import (
 	"errors"
 	"fmt"
 	"github.com/go-resty/resty/v2"
-	"github.com/jeven2016/mylibs/utils"
+	"github.com/jeven2016/mylibs/discovery"
+	"github.com/jeven2016/mylibs/metrics"
+	"github.com/jeven2016/mylibs/tracing"
+	"github.com/jeven2016/mylibs/urlutil"
 	"net/http"
+	neturl "net/url"
+	"strings"
 	"sync"
 	"time"
 )
@@ -16,22 +21,39 @@ const DefaultRetries = 3
 var restyInstanceMap = make(map[string]*resty.Client)
 var restyLock sync.Mutex
 
-// GetRestyClient 一个域名对应一个resty.Client
+// restyDiscoverySetting is the discovery used by the clients of the service:// urls
+type restyDiscoverySetting struct {
+	discovery    discovery.Discovery
+	balancer     *discovery.Balancer
+	targetScheme string
+}
+
+var restyDiscovery *restyDiscoverySetting
+
+// SetRestyDiscovery makes GetRestyClient accept the urls like service://novel-api/path, the clients of
+// these urls resolve them with UseDiscovery. The clients created before keep the previous discovery.
+func SetRestyDiscovery(d discovery.Discovery, balancer *discovery.Balancer, targetScheme string) {
+	restyLock.Lock()
+	defer restyLock.Unlock()
+	restyDiscovery = &restyDiscoverySetting{discovery: d, balancer: balancer, targetScheme: targetScheme}
+}
+
+// GetRestyClient 一个域名对应一个resty.Client, the clients are keyed by the canonical scheme and host
+// so that e.g. HTTPS://Example.com:443 and https://example.com share one. A service:// url is accepted
+// once SetRestyDiscovery is called, the client is shared by the service.
 // https://github.com/go-resty/resty/issues/612
 func GetRestyClient(url string, retry bool) (*resty.Client, error) {
-	base := utils.ParseBaseUri(url)
-	if base == "" {
+	base, isService, err := restyBaseUrl(url)
+	if err != nil {
 		return nil, fmt.Errorf("invalid Url: %s", url)
 	}
 
+	restyLock.Lock()
+	defer restyLock.Unlock()
+	if isService && restyDiscovery == nil {
+		return nil, fmt.Errorf("the discovery isn't set to resolve the url: %s", url)
+	}
 	if client, ok := restyInstanceMap[base]; !ok {
-		restyLock.Lock()
-		defer restyLock.Unlock()
-
-		if client, ok = restyInstanceMap[base]; ok {
-			return client, nil
-		}
-
 		newClient := resty.New()
 		// Allow GET request with Payload. This is disabled by default.
 		newClient.SetAllowGetMethodPayload(true)
@@ -66,6 +88,12 @@ func GetRestyClient(url string, retry bool) (*resty.Client, error) {
 			)
 		}
 
+		// the url is resolved before the request is instrumented
+		if isService {
+			UseDiscovery(newClient, restyDiscovery.discovery, restyDiscovery.balancer, restyDiscovery.targetScheme)
+		}
+		metrics.InstrumentResty(newClient)
+		tracing.InstrumentResty(newClient)
 		restyInstanceMap[base] = newClient
 		return newClient, nil
 	} else {
@@ -73,3 +101,19 @@ func GetRestyClient(url string, retry bool) (*resty.Client, error) {
 	}
 
 }
+
+// restyBaseUrl returns the key of the client of an url, it's service://<name> for a service url
+func restyBaseUrl(rawUrl string) (string, bool, error) {
+	if strings.HasPrefix(rawUrl, ServiceScheme+"://") {
+		u, err := neturl.Parse(rawUrl)
+		if err != nil {
+			return "", false, err
+		}
+		if u.Host == "" {
+			return "", false, errors.New("the service name is required")
+		}
+		return ServiceScheme + "://" + strings.ToLower(u.Host), true, nil
+	}
+	base, err := urlutil.BaseUrl(rawUrl)
+	return base, false, err
+}
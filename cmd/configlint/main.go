@@ -0,0 +1,93 @@
+// Command configlint validates the ServerConfig files offline against the json schema of ServerConfig, or
+// prints the schema.
+//
+//	configlint config.yaml site.toml
+//	configlint -schema > server-config.schema.json
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/jeven2016/mylibs/config"
+	"github.com/knadh/koanf/providers/file"
+	"github.com/knadh/koanf/v2"
+	"os"
+)
+
+func main() {
+	printSchema := flag.Bool("schema", false, "print the json schema of the server config")
+	merged := flag.Bool("merge", false, "validate the files merged in order instead of one by one")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s [-schema] [-merge] <config files>\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if *printSchema {
+		schema, err := config.JSONSchema(&config.ServerConfig{})
+		if err != nil {
+			exit(err)
+		}
+		fmt.Println(string(schema))
+		return
+	}
+
+	files := flag.Args()
+	if len(files) == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	if *merged {
+		if err := validate(files...); err != nil {
+			exit(err)
+		}
+		fmt.Println("OK")
+		return
+	}
+
+	failed := false
+	for _, f := range files {
+		if err := validate(f); err != nil {
+			failed = true
+			fmt.Fprintf(os.Stderr, "%s: %v\n", f, err)
+			continue
+		}
+		fmt.Printf("%s: OK\n", f)
+	}
+	if failed {
+		os.Exit(1)
+	}
+}
+
+// validate checks the raw documents merged in order against the schema of the server config, the secret
+// references are left unresolved so that the files can be checked without the secrets
+func validate(files ...string) error {
+	ko := koanf.New(".")
+	for _, f := range files {
+		parser, err := config.ParserForFile(f)
+		if err != nil {
+			return err
+		}
+		if err = ko.Load(file.Provider(f), parser); err != nil {
+			return err
+		}
+	}
+
+	err := config.ValidateSchema(&config.ServerConfig{}, ko.Raw())
+	var validationErr *config.ValidationError
+	if errors.As(err, &validationErr) {
+		msg := fmt.Sprintf("%d field(s) failed", len(validationErr.Fields))
+		for _, f := range validationErr.Fields {
+			msg += "\n  " + f.Error()
+		}
+		return errors.New(msg)
+	}
+	return err
+}
+
+func exit(err error) {
+	fmt.Fprintln(os.Stderr, err)
+	os.Exit(1)
+}
@@ -1,5 +1,12 @@
 package config
 
+import (
+	"fmt"
+	"path/filepath"
+)
+
+// Config is implemented by the pointer of a config struct, e.g. *ServerConfig, since Complete fills the
+// config in place
 type Config interface {
 	Validate() error
 	Complete() error
@@ -11,65 +18,259 @@ type Registration struct {
 }
 
 type MongoConfig struct {
-	Uri      string `koanf:"uri"`
+	Uri      string `koanf:"uri" validate:"required,mongouri"`
 	Database string `koanf:"database"`
 }
 
 type LogConfig struct {
 	Enabled       bool   `koanf:"enabled"`
-	LogLevel      string `koanf:"logLevel"`
-	LogPath       string `koanf:"logPath"`
+	LogLevel      string `koanf:"logLevel" default:"INFO" validate:"oneof=DEBUG INFO WARN ERROR DPANIC PANIC FATAL"`
+	LogPath       string `koanf:"logPath" default:"./logs"`
 	OutputConsole bool   `koanf:"outputToConsole"`
-	FileName      string `koanf:"fileName"`
-	MaxSizeInMB   int    `koanf:"maxSizeInMB"`
-	MaxAgeInDays  int    `koanf:"maxAgeInDays"`
-	MaxBackups    int    `koanf:"maxBackups"`
+	FileName      string `koanf:"fileName" validate:"required"`
+	MaxSizeInMB   int    `koanf:"maxSizeInMB" default:"100" validate:"gte=0"`
+	MaxAgeInDays  int    `koanf:"maxAgeInDays" default:"30" validate:"gte=0"`
+	MaxBackups    int    `koanf:"maxBackups" default:"10" validate:"gte=0"`
 	Compress      bool   `koanf:"compress"`
 }
 
 type EtcdConfig struct {
-	RefreshSeconds        uint     `koanf:"refreshSeconds"`
-	ConnectTimeoutSeconds uint     `koanf:"connectTimeoutSeconds"`
-	Endpoints             []string `koanf:"endpoints"`
+	RefreshSeconds        uint     `koanf:"refreshSeconds" default:"10"`
+	ConnectTimeoutSeconds uint     `koanf:"connectTimeoutSeconds" default:"5"`
+	Endpoints             []string `koanf:"endpoints" validate:"omitempty,dive,hostname_port|url"`
 }
 
 type HttpSetting struct {
-	Port    uint   `koanf:"port"`
-	Address string `koanf:"address"`
-	Proxy   string `koanf:"proxy"`
+	Port                   uint   `koanf:"port" validate:"lte=65535"`
+	Address                string `koanf:"address" validate:"omitempty,hostname|ip"`
+	Proxy                  string `koanf:"proxy" validate:"omitempty,url"`
+	ReadTimeoutSeconds     int    `koanf:"readTimeoutSeconds" default:"30" validate:"gte=0"`
+	WriteTimeoutSeconds    int    `koanf:"writeTimeoutSeconds" default:"30" validate:"gte=0"`
+	IdleTimeoutSeconds     int    `koanf:"idleTimeoutSeconds" default:"120" validate:"gte=0"`
+	ShutdownTimeoutSeconds int    `koanf:"shutdownTimeoutSeconds" default:"15" validate:"gte=0"`
+}
+
+// ShutdownSetting limits the graceful shutdown, the in-flight tasks are waited for GracePeriodSeconds after
+// the intake is stopped and the whole shutdown is aborted after TimeoutSeconds
+type ShutdownSetting struct {
+	GracePeriodSeconds int `koanf:"gracePeriodSeconds" default:"30" validate:"gte=0"`
+	TimeoutSeconds     int `koanf:"timeoutSeconds" default:"60" validate:"gte=0"`
 }
 
+// TaskPoolSetting configures System.TaskPool and the named pools, a pool with ReservedCapacity is kept for
+// the system tasks such as the etcd keepalive so that they aren't starved by the others
 type TaskPoolSetting struct {
-	Capacity int `koanf:"capacity"`
+	Capacity         int  `koanf:"capacity" default:"1000" validate:"gt=0"`
+	Nonblocking      bool `koanf:"nonblocking"`
+	ExpirySeconds    int  `koanf:"expirySeconds" validate:"gte=0"`
+	MaxBlockingTasks int  `koanf:"maxBlockingTasks" validate:"gte=0"`
+	ReservedCapacity int  `koanf:"reservedCapacity" default:"16" validate:"gt=0"`
+
+	Pools []NamedPoolSetting `koanf:"pools" validate:"unique=Name,dive"`
+}
+
+// NamedPoolSetting configures a pool used for a kind of tasks, e.g. the chapter tasks. A nonblocking pool
+// rejects the tasks once it's full, otherwise MaxBlockingTasks limits the waiting tasks (0 is unlimited).
+// The idle workers are purged after ExpirySeconds, the default of ants is used if it's 0.
+type NamedPoolSetting struct {
+	Name             string `koanf:"name" validate:"required,ne=default,ne=system"`
+	Capacity         int    `koanf:"capacity" validate:"gt=0"`
+	Nonblocking      bool   `koanf:"nonblocking"`
+	ExpirySeconds    int    `koanf:"expirySeconds" validate:"gte=0"`
+	MaxBlockingTasks int    `koanf:"maxBlockingTasks" validate:"gte=0"`
+}
+
+// MetricsSetting enables the prometheus collectors, the metrics are served on Path of the http server
+type MetricsSetting struct {
+	Enabled    bool   `koanf:"enabled"`
+	Path       string `koanf:"path" default:"/metrics" validate:"startswith=/"`
+	Redis      bool   `koanf:"redis"`
+	TaskPool   bool   `koanf:"taskPool"`
+	Mongo      bool   `koanf:"mongo"`
+	HttpClient bool   `koanf:"httpClient"`
+	Chrome     bool   `koanf:"chrome"`
+	Gin        bool   `koanf:"gin"`
+}
+
+// TracingSetting exports the spans via otlp/grpc to Endpoint, or writes them to stdout or FilePath for
+// local runs. The ratio of the sampled traces is 1 unless it's set.
+type TracingSetting struct {
+	Enabled     bool    `koanf:"enabled"`
+	Exporter    string  `koanf:"exporter" default:"stdout" validate:"oneof=otlp stdout file"`
+	Endpoint    string  `koanf:"endpoint" validate:"omitempty,hostname_port"`
+	Insecure    bool    `koanf:"insecure"`
+	FilePath    string  `koanf:"filePath" validate:"required_if=Exporter file"`
+	SampleRatio float64 `koanf:"sampleRatio" default:"1" validate:"gte=0,lte=1"`
+}
+
+// JobSchedule schedules a job registered in code by its name, either by a cron expression (seconds are
+// optional, descriptors like @hourly are supported) or by a fixed interval. A single-instance job runs on
+// one replica at a time by holding a redis lock.
+type JobSchedule struct {
+	Name            string `koanf:"name" validate:"required"`
+	Cron            string `koanf:"cron" validate:"required_without=IntervalSeconds"`
+	IntervalSeconds int    `koanf:"intervalSeconds" validate:"gte=0"`
+	SingleInstance  bool   `koanf:"singleInstance"`
+	TimeoutSeconds  int    `koanf:"timeoutSeconds" validate:"gte=0"`
+	Disabled        bool   `koanf:"disabled"`
+}
+
+// SchedulerSetting lists the schedules of the jobs, the last HistorySize runs of each job are kept
+type SchedulerSetting struct {
+	HistorySize int           `koanf:"historySize" default:"20" validate:"gt=0"`
+	Jobs        []JobSchedule `koanf:"jobs" validate:"unique=Name,dive"`
+}
+
+// AdminSetting serves the admin api on Path of the http server, the requests must carry the Token as
+// a bearer token in the Authorization header
+type AdminSetting struct {
+	Enabled bool   `koanf:"enabled"`
+	Path    string `koanf:"path" default:"/admin" validate:"startswith=/"`
+	Token   string `koanf:"token" validate:"required_if=Enabled true"`
 }
 
 type RedisConfig struct {
-	Address                  string `koanf:"address,omitempty"`
+	Address                  string `koanf:"address,omitempty" validate:"required,hostname_port"`
 	Password                 string `koanf:"password,omitempty"`
-	DefaultDb                int    `koanf:"defaultDb,omitempty"`
-	PoolSize                 int    `koanf:"poolSize,omitempty"`
-	PoolTimeout              int    `koanf:"poolTimeout"`
-	ReadTimeout              int    `koanf:"readTimeout"`
-	WriteTimeout             int    `koanf:"writeTimeout"`
+	DefaultDb                int    `koanf:"defaultDb,omitempty" validate:"gte=0,lte=15"`
+	PoolSize                 int    `koanf:"poolSize,omitempty" default:"10" validate:"gte=0"`
+	PoolTimeout              int    `koanf:"poolTimeout" default:"5" validate:"gte=0"`
+	ReadTimeout              int    `koanf:"readTimeout" default:"3" validate:"gte=0"`
+	WriteTimeout             int    `koanf:"writeTimeout" default:"3" validate:"gte=0"`
 	AutoCreateConsumerGroups bool   `koanf:"autoCreateConsumerGroups"`
 }
 
+// RegexSettings describe the pagination of a site. ParsePageRegex is matched against the html of a page,
+// the largest number captured by its first group is the total of the pages, e.g. `共(\d+)页`. The page n
+// of a paginated url is the url with PagePrefix, n and PageSuffix, e.g. "_" and ".html" for
+// /book/1/123_2.html, or "index_" and ".html" for /list/1/index_2.html.
+type RegexSettings struct {
+	ParsePageRegex string `koanf:"parsePageRegex" validate:"omitempty,regexp"`
+	PagePrefix     string `koanf:"pagePrefix" validate:"required_with=ParsePageRegex PageSuffix"`
+	PageSuffix     string `koanf:"pageSuffix"`
+}
+
+type MongoCollections struct {
+	Novel       string `koanf:"novel"`
+	CatalogPage string `koanf:"catalogPage"`
+}
+
+type CrawlerSetting struct {
+	Catalog     map[string]any `koanf:"catalog"`
+	CatalogPage map[string]any `koanf:"catalogPage"`
+	Novel       map[string]any `koanf:"novel"`
+	Chapter     map[string]any `koanf:"chapter"`
+}
+
+type SiteConfig struct {
+	Name             string            `koanf:"name" validate:"required"`
+	RegexSettings    *RegexSettings    `koanf:"regexSettings"`
+	MongoCollections *MongoCollections `koanf:"mongoCollections"`
+	Attributes       map[string]string `koanf:"attributes"`
+	CrawlerSettings  *CrawlerSetting   `koanf:"crawlerSettings"`
+
+	//whether to transfer redis message via separated redis streamuse separate space
+	UseSeparateSpace bool `koanf:"useSeparateSpace"`
+}
+
+// FrontierSetting configures the crawl frontier. The urls deeper than MaxDepth (0 is unlimited), matching
+// any of ExcludedUrlPatterns or listed in excludedNovelUrls aren't enqueued. The seen urls are kept in a
+// redis set, or in a redis bloom filter sized by BloomCapacity and BloomFalsePositiveRate for large crawls.
+// The urls are canonicalized before dedup, only the QueryParams are kept if it's set and the TrackingParams
+// are removed besides the well-known ones like utm_source.
+// A leased url is given to another worker after LeaseSeconds, and a url is failed after MaxAttempts.
+type FrontierSetting struct {
+	MaxDepth               int      `koanf:"maxDepth" validate:"gte=0"`
+	LeaseSeconds           int      `koanf:"leaseSeconds" default:"300" validate:"gt=0"`
+	MaxAttempts            int      `koanf:"maxAttempts" default:"3" validate:"gt=0"`
+	Dedup                  string   `koanf:"dedup" default:"set" validate:"oneof=set bloom"`
+	BloomCapacity          int      `koanf:"bloomCapacity" default:"10000000" validate:"gt=0"`
+	BloomFalsePositiveRate float64  `koanf:"bloomFalsePositiveRate" default:"0.001" validate:"gt=0,lt=1"`
+	ExcludedUrlPatterns    []string `koanf:"excludedUrlPatterns" validate:"dive,regexp"`
+	QueryParams            []string `koanf:"queryParams"`
+	TrackingParams         []string `koanf:"trackingParams"`
+}
+
+type CrawlerSettings struct {
+	CatalogPageTaskParallelism int      `koanf:"catalogPageTaskParallelism" default:"1" validate:"gte=0"`
+	NovelTaskParallelism       int      `koanf:"novelTaskParallelism" default:"1" validate:"gte=0"`
+	ChapterTaskParallelism     int      `koanf:"chapterTaskParallelism" default:"1" validate:"gte=0"`
+	EcludedNovelUrls           []string `koanf:"excludedNovelUrls"`
+
+	Frontier *FrontierSetting `koanf:"frontier"`
+}
+
 type ServerConfig struct {
-	ApplicationName string           `koanf:"applicationName"`
-	Http            *HttpSetting     `koanf:"http"`
-	Registration    *Registration    `koanf:"registration"`
-	Redis           *RedisConfig     `koanf:"redis"`
-	Mongo           *MongoConfig     `koanf:"mongodb"`
-	LogSetting      *LogConfig       `koanf:"logConfig"`
-	TaskPoolSetting *TaskPoolSetting `koanf:"taskPool"`
+	ApplicationName string            `koanf:"applicationName" validate:"required"`
+	Http            *HttpSetting      `koanf:"http"`
+	Registration    *Registration     `koanf:"registration"`
+	Redis           *RedisConfig      `koanf:"redis"`
+	Mongo           *MongoConfig      `koanf:"mongodb"`
+	LogSetting      *LogConfig        `koanf:"logConfig" validate:"required"`
+	CrawlerSettings *CrawlerSettings  `koanf:"crawlerSettings"`
+	TaskPoolSetting *TaskPoolSetting  `koanf:"taskPool" validate:"required"`
+	Shutdown        *ShutdownSetting  `koanf:"shutdown"`
+	Scheduler       *SchedulerSetting `koanf:"scheduler"`
+	Admin           *AdminSetting     `koanf:"admin"`
+	Metrics         *MetricsSetting   `koanf:"metrics"`
+	Tracing         *TracingSetting   `koanf:"tracing"`
+	WebSites        []SiteConfig      `koanf:"webSites" validate:"dive"`
+
+	// set if the defaults are taken from the keys absent in the sources by a Loader, the zero values are
+	// explicit then and they're kept by Complete
+	defaultsLoaded bool
 }
 
 func (s ServerConfig) GetServerConfig() *ServerConfig {
 	return &s
 }
+
+// Validate checks the config against the validate tags and reports all failing fields at once
 func (s ServerConfig) Validate() error {
-	return nil
+	return ValidateStruct(s)
 }
-func (s ServerConfig) Complete() error {
+
+func (s *ServerConfig) setDefaultsLoaded() {
+	s.defaultsLoaded = true
+}
+
+// Complete creates the settings required by the server and fills the default values, it should be called
+// before Validate. The zero values of a config built in code are replaced by the defaults, while the ones
+// of a config loaded by a Loader are kept since the loader has filled the defaults of the absent keys.
+// It has a pointer receiver unlike Validate, so only *ServerConfig implements Config.
+func (s *ServerConfig) Complete() error {
+	var created []any
+	if s.LogSetting == nil {
+		s.LogSetting = &LogConfig{}
+		created = append(created, s.LogSetting)
+	}
+	if s.TaskPoolSetting == nil {
+		s.TaskPoolSetting = &TaskPoolSetting{}
+		created = append(created, s.TaskPoolSetting)
+	}
+	if s.Shutdown == nil {
+		s.Shutdown = &ShutdownSetting{}
+		created = append(created, s.Shutdown)
+	}
+	if s.Scheduler == nil {
+		s.Scheduler = &SchedulerSetting{}
+		created = append(created, s.Scheduler)
+	}
+
+	if !s.defaultsLoaded {
+		if err := SetDefaults(s); err != nil {
+			return err
+		}
+	}
+	for _, section := range created {
+		if err := SetDefaults(section); err != nil {
+			return err
+		}
+	}
+
+	// write the log into <logPath>/<applicationName>.log rather than a temporary file
+	if s.LogSetting.FileName == "" && s.ApplicationName != "" {
+		s.LogSetting.FileName = filepath.Join(s.LogSetting.LogPath, fmt.Sprintf("%s.log", s.ApplicationName))
+	}
 	return nil
 }
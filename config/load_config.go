@@ -1,50 +1,165 @@
 package config
 
 import (
-	"github.com/jeven2016/mylibs/internal"
-	"github.com/knadh/koanf/parsers/yaml"
-	"github.com/knadh/koanf/providers/file"
-	"github.com/knadh/koanf/providers/rawbytes"
-	"github.com/knadh/koanf/v2"
-	"log"
+	"github.com/spf13/pflag"
+	"reflect"
+	"strings"
+	"time"
 )
 
-// Global koanf instance. Use "." as the key path delimiter. This can be "/" or any character.
-var k = koanf.New(".")
+// DefaultEnvPrefix is the prefix of the environment variables used to override the configuration.
+// The rest of the variable name is mapped to a koanf key by the fields of the config struct, the
+// underscores are the path separators and the names are case-insensitive, e.g.
+//
+//	APP_REDIS_ADDRESS    -> redis.address
+//	APP_MONGODB_URI      -> mongodb.uri
+//	APP_LOGCONFIG_LOGLEVEL -> logConfig.logLevel
+//
+// Comma separated values are split for the list settings, e.g. APP_REGISTRATION_ETCD_ENDPOINTS.
+const DefaultEnvPrefix = "APP_"
 
-// LoadConfig loads the configuration files
-func LoadConfig(internalCfg []byte, config Config, extraConfigFilePath *string, defaultCfgPaths []string) error {
+type loadOptions struct {
+	envEnabled bool
+	envPrefix  string
+	flags      *pflag.FlagSet
+	watcher    *Watcher
+	loader     **Loader
+	resolvers  map[string]SecretResolver
 
-	//load internal config
-	if internalCfg != nil {
-		if err := k.Load(rawbytes.Provider(internalCfg), yaml.Parser()); err != nil {
-			return err
+	remotes      []RemoteSource
+	pollInterval time.Duration
+	strict       bool
+}
+
+// LoadOption customizes how LoadConfig merges the configuration
+type LoadOption func(opts *loadOptions)
+
+// WithEnv overrides the configuration with the environment variables starting with the prefix,
+// DefaultEnvPrefix is used if the prefix is empty.
+func WithEnv(prefix string) LoadOption {
+	return func(opts *loadOptions) {
+		opts.envEnabled = true
+		opts.envPrefix = prefix
+		if opts.envPrefix == "" {
+			opts.envPrefix = DefaultEnvPrefix
 		}
 	}
+}
+
+// WithFlags overrides the configuration with the command-line flags, the flag name is the koanf key,
+// e.g. --redis.password. The default value of a flag only applies if the key isn't set by any other
+// source. The flag set should be parsed before calling LoadConfig.
+func WithFlags(flags *pflag.FlagSet) LoadOption {
+	return func(opts *loadOptions) {
+		opts.flags = flags
+	}
+}
+
+// WithStrict rejects the keys that aren't declared by the config struct, koanf ignores them silently
+// otherwise so that a typo is only found at runtime
+func WithStrict() LoadOption {
+	return func(opts *loadOptions) {
+		opts.strict = true
+	}
+}
 
-	cfgPaths := defaultCfgPaths
-	if cfgPaths == nil {
-		cfgPaths = []string{}
+// WithWatcher makes LoadConfig watch the config files after loading, the changed configuration is validated and
+// published through the watcher. Note that the config passed to LoadConfig is never modified by a
+// reload, use Watcher.Current to get the latest one.
+func WithWatcher(watcher *Watcher) LoadOption {
+	return func(opts *loadOptions) {
+		opts.watcher = watcher
+	}
+}
+
+// WithLoader stores the Loader created by LoadConfig in loader, e.g. to serve its EffectiveConfig
+func WithLoader(loader **Loader) LoadOption {
+	return func(opts *loadOptions) {
+		opts.loader = loader
+	}
+}
+
+// LoadConfig loads the configuration files with a new Loader. The precedence is: internal config <
+// config files < environment variables < command-line flags, the default values of the config struct
+// are filled at last for the keys not set by any source.
+func LoadConfig(internalCfg []byte, config Config, extraConfigFilePath *string, defaultCfgPaths []string,
+	options ...LoadOption) error {
+	loader := NewLoader(options...)
+	if loader.opts.loader != nil {
+		*loader.opts.loader = loader
+	}
+	if internalCfg != nil {
+		loader.AddBytes("internal", internalCfg)
+	}
+	for _, path := range defaultCfgPaths {
+		loader.AddFile(path)
 	}
 	if extraConfigFilePath != nil {
-		cfgPaths = append(cfgPaths, *extraConfigFilePath)
+		loader.AddFile(*extraConfigFilePath)
+	}
+	if err := loader.Load(config); err != nil {
+		return err
 	}
 
-	// load external configs
-	for _, f := range cfgPaths {
-		if exists, err := internal.IsFileExists(f); err != nil {
-			log.Printf(f + " not found and ignored")
-			continue
-		} else if exists {
-			if err = k.Load(file.Provider(f), yaml.Parser()); err != nil {
-				return err
+	if loader.opts.watcher != nil {
+		return loader.opts.watcher.Watch(loader, config)
+	}
+	return nil
+}
+
+// configKeys holds the koanf keys declared by a config struct
+type configKeys struct {
+	// normalized env name => koanf key
+	byEnvName map[string]string
+	listKeys  map[string]bool
+}
+
+func collectKeys(typ reflect.Type) *configKeys {
+	keys := &configKeys{byEnvName: map[string]string{}, listKeys: map[string]bool{}}
+	keys.walk(typ, "")
+	return keys
+}
+
+func (c *configKeys) walk(typ reflect.Type, path string) {
+	for typ.Kind() == reflect.Ptr {
+		typ = typ.Elem()
+	}
+	if typ.Kind() != reflect.Struct {
+		if path != "" {
+			c.byEnvName[strings.ToLower(strings.ReplaceAll(path, ".", "_"))] = path
+			if typ.Kind() == reflect.Slice && typ.Elem().Kind() == reflect.String {
+				c.listKeys[path] = true
 			}
 		}
+		return
 	}
 
-	if err := k.Unmarshal("", config); err != nil {
-		return err
+	for i := 0; i < typ.NumField(); i++ {
+		field := typ.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+		name := koanfName(field)
+		if name == "-" {
+			continue
+		}
+		if path != "" {
+			name = path + "." + name
+		}
+		c.walk(field.Type, name)
 	}
+}
 
-	return nil
+// envToKey maps the environment variable (without prefix) into a koanf key
+func (c *configKeys) envToKey(name string, value string) (string, any) {
+	envName := strings.ToLower(name)
+	key, ok := c.byEnvName[envName]
+	if !ok {
+		// unknown keys, e.g. the entries of a map, are mapped by replacing the underscores
+		return strings.ReplaceAll(envName, "_", "."), value
+	}
+	if c.listKeys[key] {
+		return key, strings.Split(value, ",")
+	}
+	return key, value
 }
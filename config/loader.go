@@ -0,0 +1,261 @@
+package config
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/jeven2016/mylibs/internal"
+	"github.com/knadh/koanf/parsers/yaml"
+	"github.com/knadh/koanf/providers/confmap"
+	"github.com/knadh/koanf/providers/env"
+	"github.com/knadh/koanf/providers/file"
+	"github.com/knadh/koanf/providers/posflag"
+	"github.com/knadh/koanf/providers/rawbytes"
+	"github.com/knadh/koanf/v2"
+	"log"
+	"reflect"
+	"strings"
+	"sync"
+)
+
+const (
+	// EnvSourceName is the source name of the environment variables
+	EnvSourceName = "env"
+	// FlagSourceName is the source name of the command-line flags
+	FlagSourceName = "flags"
+	// DefaultsSourceName is the source name of the default values declared by the config struct
+	DefaultsSourceName = "defaults"
+)
+
+// source is a named configuration source, the latter source overrides the former one
+type source struct {
+	name     string
+	provider koanf.Provider
+	parser   koanf.Parser
+	// path is set for a file source, a missing file is ignored
+	path string
+}
+
+// Loader merges the named sources into its own koanf instance. Every call of Load starts from an empty
+// instance so that the keys of a previous load never leak into the next one.
+type Loader struct {
+	opts    *loadOptions
+	sources []source
+
+	lock    sync.RWMutex
+	ko      *koanf.Koanf
+	origins map[string]string
+	// the keys whose value is resolved from a secret reference
+	secretKeys map[string]bool
+}
+
+// NewLoader creates a loader, the remote sources, env and flag options are merged in order after all the
+// added sources
+func NewLoader(options ...LoadOption) *Loader {
+	opts := &loadOptions{}
+	for _, o := range options {
+		o(opts)
+	}
+	return &Loader{opts: opts, ko: koanf.New("."), origins: map[string]string{}}
+}
+
+// AddBytes adds a yaml document as a source
+func (l *Loader) AddBytes(name string, data []byte) *Loader {
+	return l.AddSource(name, rawbytes.Provider(data), yaml.Parser())
+}
+
+// AddFile adds a file as a source named by its path, the parser is picked by the file extension,
+// see ParserForFile. The file is ignored if it doesn't exist.
+func (l *Loader) AddFile(path string) *Loader {
+	l.sources = append(l.sources, source{name: path, provider: file.Provider(path), path: path})
+	return l
+}
+
+// AddMap adds an in-memory map as a source, the keys could be either nested or flattened with ".",
+// e.g. {"redis.address": "localhost:6379"}
+func (l *Loader) AddMap(name string, values map[string]any) *Loader {
+	return l.AddSource(name, confmap.Provider(values, "."), nil)
+}
+
+// AddSource adds a source with a custom koanf provider, the parser could be nil if the provider
+// returns a map
+func (l *Loader) AddSource(name string, provider koanf.Provider, parser koanf.Parser) *Loader {
+	l.sources = append(l.sources, source{name: name, provider: provider, parser: parser})
+	return l
+}
+
+// Load merges all the sources and unmarshals the result into config, the defaults are filled and the
+// config is validated. The secret references are resolved before unmarshalling, see SecretResolver.
+// The state of the loader is kept unchanged if any error occurs.
+func (l *Loader) Load(config Config) error {
+	if config == nil {
+		return errors.New("the config to load is required")
+	}
+
+	ko := koanf.New(".")
+	origins := map[string]string{}
+
+	for _, src := range l.sources {
+		if src.path != "" {
+			if exists, err := internal.IsFileExists(src.path); err != nil || !exists {
+				log.Printf(src.path + " not found and ignored")
+				continue
+			}
+			parser, err := ParserForFile(src.path)
+			if err != nil {
+				return err
+			}
+			src.parser = parser
+		}
+		if err := mergeSource(ko, origins, src); err != nil {
+			return err
+		}
+	}
+
+	for _, remote := range l.opts.remotes {
+		provider := &remoteProvider{src: remote, timeout: defaultRemoteTimeout}
+		if err := mergeSource(ko, origins, source{name: remote.Name(), provider: provider}); err != nil {
+			return err
+		}
+	}
+
+	if l.opts.envEnabled {
+		keys := collectKeys(reflect.TypeOf(config))
+		prefix := l.opts.envPrefix
+		provider := env.ProviderWithValue(prefix, ".", func(key string, value string) (string, any) {
+			return keys.envToKey(strings.TrimPrefix(key, prefix), value)
+		})
+		if err := mergeSource(ko, origins, source{name: EnvSourceName, provider: provider}); err != nil {
+			return err
+		}
+	}
+
+	if l.opts.flags != nil {
+		// the default value of a flag only applies if the key isn't set by the other sources
+		provider := posflag.Provider(l.opts.flags, ".", ko)
+		if err := mergeSource(ko, origins, source{name: FlagSourceName, provider: provider}); err != nil {
+			return err
+		}
+	}
+
+	resolvers := l.opts.resolvers
+	if resolvers == nil {
+		resolvers = defaultResolvers()
+	}
+	secretKeys, err := resolveSecrets(context.Background(), ko, resolvers)
+	if err != nil {
+		return err
+	}
+
+	if l.opts.strict {
+		if unknown := unknownKeys(reflect.TypeOf(config), ko.Raw(), ""); len(unknown) > 0 {
+			return &UnknownKeysError{Keys: unknown, origins: origins}
+		}
+	}
+
+	// the defaults only apply to the absent keys, so an explicit zero value like sampleRatio: 0 is kept
+	defaults, err := defaultValues(reflect.TypeOf(config), func(key string) bool {
+		return ko.Exists(key) && ko.Get(key) != nil
+	})
+	if err != nil {
+		return err
+	}
+	if err = mergeSource(ko, origins, source{name: DefaultsSourceName,
+		provider: confmap.Provider(defaults, ".")}); err != nil {
+		return err
+	}
+
+	if err = ko.Unmarshal("", config); err != nil {
+		return err
+	}
+	if d, ok := config.(defaultsLoader); ok {
+		d.setDefaultsLoaded()
+	}
+	if err = config.Complete(); err != nil {
+		return err
+	}
+	if err = config.Validate(); err != nil {
+		return err
+	}
+
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	l.ko = ko
+	l.origins = origins
+	l.secretKeys = secretKeys
+	return nil
+}
+
+// UnknownKeysError is returned by a strict Loader if any key isn't declared by the config struct
+type UnknownKeysError struct {
+	Keys    []string
+	origins map[string]string
+}
+
+func (u *UnknownKeysError) Error() string {
+	keys := make([]string, 0, len(u.Keys))
+	for _, key := range u.Keys {
+		if origin, ok := u.origins[key]; ok {
+			keys = append(keys, fmt.Sprintf("%s (%s)", key, origin))
+		} else {
+			keys = append(keys, key)
+		}
+	}
+	return "unknown config keys: " + strings.Join(keys, ", ")
+}
+
+// mergeSource loads the source into a separate instance first to record where each key comes from
+func mergeSource(ko *koanf.Koanf, origins map[string]string, src source) error {
+	sk := koanf.New(".")
+	if err := sk.Load(src.provider, src.parser); err != nil {
+		return fmt.Errorf("failed to load config source %s: %w", src.name, err)
+	}
+	for _, key := range sk.Keys() {
+		origins[key] = src.name
+	}
+	return ko.Merge(sk)
+}
+
+// Origin returns the name of the source that the key's value comes from, it's empty if the key isn't
+// set by any source
+func (l *Loader) Origin(key string) string {
+	l.lock.RLock()
+	defer l.lock.RUnlock()
+	return l.origins[key]
+}
+
+// Origins returns the source names of all the loaded keys
+func (l *Loader) Origins() map[string]string {
+	l.lock.RLock()
+	defer l.lock.RUnlock()
+	origins := make(map[string]string, len(l.origins))
+	for key, name := range l.origins {
+		origins[key] = name
+	}
+	return origins
+}
+
+// All returns the flattened key/values merged by the last successful load
+func (l *Loader) All() map[string]any {
+	l.lock.RLock()
+	defer l.lock.RUnlock()
+	return l.ko.All()
+}
+
+// secrets returns the keys resolved from the secret references by the last successful load
+func (l *Loader) secrets() map[string]bool {
+	l.lock.RLock()
+	defer l.lock.RUnlock()
+	return l.secretKeys
+}
+
+// files returns the paths of the file sources
+func (l *Loader) files() []string {
+	var paths []string
+	for _, src := range l.sources {
+		if src.path != "" {
+			paths = append(paths, src.path)
+		}
+	}
+	return paths
+}
@@ -0,0 +1,66 @@
+package config
+
+import "testing"
+
+func TestLoaderTracksOrigins(t *testing.T) {
+	t.Parallel()
+	loader := NewLoader().
+		AddMap("defaults", map[string]any{
+			"applicationName": "crawler",
+			"redis.address":   "localhost:6379",
+			"redis.poolSize":  5,
+		}).
+		AddMap("site", map[string]any{
+			"redis": map[string]any{"poolSize": 20},
+		})
+
+	var cfg ServerConfig
+	if err := loader.Load(&cfg); err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Redis.PoolSize != 20 {
+		t.Fatalf("unexpected pool size: %d", cfg.Redis.PoolSize)
+	}
+	if loader.Origin("redis.address") != "defaults" || loader.Origin("redis.poolSize") != "site" {
+		t.Fatalf("unexpected origins: %v", loader.Origins())
+	}
+}
+
+func TestLoaderDropsStaleKeys(t *testing.T) {
+	t.Parallel()
+	first := NewLoader().AddMap("first", map[string]any{
+		"applicationName": "first",
+		"redis.address":   "localhost:6379",
+	})
+	var cfg ServerConfig
+	if err := first.Load(&cfg); err != nil {
+		t.Fatal(err)
+	}
+
+	second := NewLoader().AddMap("second", map[string]any{"applicationName": "second"})
+	var other ServerConfig
+	if err := second.Load(&other); err != nil {
+		t.Fatal(err)
+	}
+	if other.Redis != nil || second.Origin("redis.address") != "" {
+		t.Fatal("the keys of another loader should not be merged")
+	}
+}
+
+func TestLoaderKeepsStateOnError(t *testing.T) {
+	t.Parallel()
+	values := map[string]any{"applicationName": "crawler"}
+	loader := NewLoader().AddMap("memory", values)
+	var cfg ServerConfig
+	if err := loader.Load(&cfg); err != nil {
+		t.Fatal(err)
+	}
+
+	invalid := NewLoader().AddMap("memory", values).AddMap("broken", map[string]any{"taskPool.capacity": -1})
+	if err := invalid.Load(&ServerConfig{}); err == nil {
+		t.Fatal("an invalid config should be rejected")
+	}
+	if len(invalid.All()) != 0 {
+		t.Fatal("nothing should be kept by a failed load")
+	}
+}
@@ -0,0 +1,411 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+const schemaDraft = "https://json-schema.org/draft/2020-12/schema"
+
+// completedFields are the required fields filled by ServerConfig.Complete, they could be absent in a
+// config document
+var completedFields = map[reflect.Type][]string{
+	reflect.TypeOf(ServerConfig{}): {"LogSetting", "TaskPoolSetting"},
+	reflect.TypeOf(LogConfig{}):    {"FileName"},
+}
+
+// JSONSchema generates the json schema of a config struct from its koanf tags, the validate and default
+// tags are translated into the constraints and default values where possible
+func JSONSchema(cfg any) ([]byte, error) {
+	typ := reflect.TypeOf(cfg)
+	if typ == nil {
+		return nil, fmt.Errorf("the config is required to generate a schema")
+	}
+	schema := typeSchema(typ)
+	schema["$schema"] = schemaDraft
+	schema["title"] = indirectType(typ).Name()
+	return json.MarshalIndent(schema, "", "  ")
+}
+
+func indirectType(typ reflect.Type) reflect.Type {
+	for typ.Kind() == reflect.Ptr {
+		typ = typ.Elem()
+	}
+	return typ
+}
+
+func typeSchema(typ reflect.Type) map[string]any {
+	typ = indirectType(typ)
+	if typ == reflect.TypeOf(time.Duration(0)) {
+		return map[string]any{"type": "string"}
+	}
+
+	switch typ.Kind() {
+	case reflect.Struct:
+		return structSchema(typ)
+	case reflect.Slice, reflect.Array:
+		return map[string]any{"type": "array", "items": typeSchema(typ.Elem())}
+	case reflect.Map:
+		schema := map[string]any{"type": "object"}
+		if elem := typeSchema(typ.Elem()); len(elem) > 0 {
+			schema["additionalProperties"] = elem
+		}
+		return schema
+	case reflect.String:
+		return map[string]any{"type": "string"}
+	case reflect.Bool:
+		return map[string]any{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return map[string]any{"type": "integer"}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]any{"type": "integer", "minimum": 0}
+	case reflect.Float32, reflect.Float64:
+		return map[string]any{"type": "number"}
+	default:
+		// interface{} accepts anything
+		return map[string]any{}
+	}
+}
+
+func structSchema(typ reflect.Type) map[string]any {
+	properties := map[string]any{}
+	var required []string
+
+	for i := 0; i < typ.NumField(); i++ {
+		field := typ.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+		name := koanfName(field)
+		if name == "-" {
+			continue
+		}
+
+		prop := typeSchema(field.Type)
+		if def, ok := field.Tag.Lookup("default"); ok {
+			prop["default"] = defaultValue(prop, def)
+		}
+		// a field with a default value or filled by Complete isn't required in the document
+		_, hasDefault := field.Tag.Lookup("default")
+		if applyValidateTag(prop, field.Tag.Get("validate")) && !hasDefault && !isCompleted(typ, field.Name) {
+			required = append(required, name)
+		}
+		properties[name] = prop
+	}
+
+	schema := map[string]any{
+		"type":                 "object",
+		"properties":           properties,
+		"additionalProperties": false,
+	}
+	if len(required) > 0 {
+		sort.Strings(required)
+		schema["required"] = required
+	}
+	return schema
+}
+
+func isCompleted(typ reflect.Type, fieldName string) bool {
+	for _, name := range completedFields[typ] {
+		if name == fieldName {
+			return true
+		}
+	}
+	return false
+}
+
+func defaultValue(prop map[string]any, def string) any {
+	switch prop["type"] {
+	case "integer":
+		if i, err := strconv.ParseInt(def, 10, 64); err == nil {
+			return i
+		}
+	case "number":
+		if f, err := strconv.ParseFloat(def, 64); err == nil {
+			return f
+		}
+	case "boolean":
+		if b, err := strconv.ParseBool(def); err == nil {
+			return b
+		}
+	case "array":
+		return strings.Split(def, ",")
+	}
+	return def
+}
+
+// applyValidateTag translates the rules of the validate tag, it returns true if the field is required
+func applyValidateTag(prop map[string]any, tag string) bool {
+	required := false
+	for _, rule := range strings.Split(tag, ",") {
+		// the rules after dive apply to the items
+		if rule == "dive" {
+			break
+		}
+		name, param, _ := strings.Cut(rule, "=")
+		switch name {
+		case "required":
+			required = true
+		case "oneof":
+			var enum []any
+			for _, v := range strings.Fields(param) {
+				enum = append(enum, v)
+			}
+			prop["enum"] = enum
+		case "gte", "min":
+			setBound(prop, "minimum", "minLength", param)
+		case "lte", "max":
+			setBound(prop, "maximum", "maxLength", param)
+		case "gt":
+			setBound(prop, "exclusiveMinimum", "", param)
+		case "lt":
+			setBound(prop, "exclusiveMaximum", "", param)
+		case "url":
+			prop["format"] = "uri"
+		case "hostname":
+			prop["format"] = "hostname"
+		case "mongouri":
+			prop["pattern"] = "^mongodb(\\+srv)?://"
+		}
+	}
+	return required
+}
+
+func setBound(prop map[string]any, numberKey string, stringKey string, param string) {
+	n, err := strconv.ParseFloat(param, 64)
+	if err != nil {
+		return
+	}
+	switch prop["type"] {
+	case "integer", "number":
+		prop[numberKey] = n
+	case "string":
+		if stringKey != "" {
+			prop[stringKey] = int(n)
+		}
+	}
+}
+
+// unknownKeys returns the keys of values which aren't declared by the config struct, the keys of a map
+// field are not checked
+func unknownKeys(typ reflect.Type, values map[string]any, path string) []string {
+	typ = indirectType(typ)
+	if typ.Kind() != reflect.Struct {
+		return nil
+	}
+
+	fields := map[string]reflect.StructField{}
+	for i := 0; i < typ.NumField(); i++ {
+		field := typ.Field(i)
+		if field.IsExported() && koanfName(field) != "-" {
+			// koanf matches the keys case-insensitively while unmarshalling
+			fields[strings.ToLower(koanfName(field))] = field
+		}
+	}
+
+	var unknown []string
+	for key, val := range values {
+		keyPath := key
+		if path != "" {
+			keyPath = path + "." + key
+		}
+
+		field, ok := fields[strings.ToLower(key)]
+		if !ok {
+			unknown = append(unknown, keyPath)
+			continue
+		}
+
+		fieldType := indirectType(field.Type)
+		switch v := val.(type) {
+		case map[string]any:
+			unknown = append(unknown, unknownKeys(fieldType, v, keyPath)...)
+		case []any:
+			if fieldType.Kind() != reflect.Slice {
+				continue
+			}
+			for i, item := range v {
+				if m, ok := item.(map[string]any); ok {
+					unknown = append(unknown, unknownKeys(fieldType.Elem(), m, fmt.Sprintf("%s[%d]", keyPath, i))...)
+				}
+			}
+		}
+	}
+	sort.Strings(unknown)
+	return unknown
+}
+
+// ValidateSchema checks a raw config document, e.g. a parsed file, against the json schema generated
+// for cfg. The secret references aren't resolved, so a string holding one is accepted by any field.
+// All the violations are reported at once by a ValidationError, the formats are only annotations.
+func ValidateSchema(cfg any, doc map[string]any) error {
+	data, err := JSONSchema(cfg)
+	if err != nil {
+		return err
+	}
+	var schema map[string]any
+	if err = json.Unmarshal(data, &schema); err != nil {
+		return err
+	}
+
+	var fields []FieldError
+	checkSchema(schema, doc, "", &fields)
+	if len(fields) > 0 {
+		return &ValidationError{Fields: fields}
+	}
+	return nil
+}
+
+func checkSchema(schema map[string]any, val any, path string, fields *[]FieldError) {
+	if val == nil {
+		return
+	}
+	if s, ok := val.(string); ok && secretRefRegex.MatchString(s) {
+		return
+	}
+
+	fail := func(rule string, param any) {
+		*fields = append(*fields, FieldError{Path: path, Rule: rule, Param: fmt.Sprint(param), Value: val})
+	}
+
+	switch schema["type"] {
+	case "object":
+		m, ok := val.(map[string]any)
+		if !ok {
+			fail("type", "object")
+			return
+		}
+		checkObject(schema, m, path, fields)
+	case "array":
+		items, ok := val.([]any)
+		if !ok {
+			fail("type", "array")
+			return
+		}
+		itemSchema, _ := schema["items"].(map[string]any)
+		for i, item := range items {
+			checkSchema(itemSchema, item, fmt.Sprintf("%s[%d]", path, i), fields)
+		}
+	case "string":
+		s, ok := val.(string)
+		if !ok {
+			fail("type", "string")
+			return
+		}
+		if n, ok := schema["minLength"].(float64); ok && utf8.RuneCountInString(s) < int(n) {
+			fail("minLength", n)
+		}
+		if n, ok := schema["maxLength"].(float64); ok && utf8.RuneCountInString(s) > int(n) {
+			fail("maxLength", n)
+		}
+		if pattern, ok := schema["pattern"].(string); ok {
+			if matched, err := regexp.MatchString(pattern, s); err == nil && !matched {
+				fail("pattern", pattern)
+			}
+		}
+	case "integer", "number":
+		n, ok := toNumber(val)
+		if !ok || (schema["type"] == "integer" && n != math.Trunc(n)) {
+			fail("type", schema["type"])
+			return
+		}
+		if min, ok := schema["minimum"].(float64); ok && n < min {
+			fail("minimum", min)
+		}
+		if max, ok := schema["maximum"].(float64); ok && n > max {
+			fail("maximum", max)
+		}
+		if min, ok := schema["exclusiveMinimum"].(float64); ok && n <= min {
+			fail("exclusiveMinimum", min)
+		}
+		if max, ok := schema["exclusiveMaximum"].(float64); ok && n >= max {
+			fail("exclusiveMaximum", max)
+		}
+	case "boolean":
+		if _, ok := val.(bool); !ok {
+			fail("type", "boolean")
+		}
+	}
+
+	if enum, ok := schema["enum"].([]any); ok {
+		for _, v := range enum {
+			if v == val {
+				return
+			}
+		}
+		fail("enum", enum)
+	}
+}
+
+// checkObject checks the properties of an object, the keys are matched case-insensitively like koanf does
+func checkObject(schema map[string]any, m map[string]any, path string, fields *[]FieldError) {
+	keyPath := func(key string) string {
+		if path == "" {
+			return key
+		}
+		return path + "." + key
+	}
+	keys := map[string]string{}
+	for key := range m {
+		keys[strings.ToLower(key)] = key
+	}
+
+	required, _ := schema["required"].([]any)
+	for _, name := range required {
+		if key, ok := keys[strings.ToLower(name.(string))]; !ok || m[key] == nil {
+			*fields = append(*fields, FieldError{Path: keyPath(name.(string)), Rule: "required"})
+		}
+	}
+
+	props, _ := schema["properties"].(map[string]any)
+	propNames := map[string]string{}
+	for name := range props {
+		propNames[strings.ToLower(name)] = name
+	}
+
+	sorted := make([]string, 0, len(m))
+	for key := range m {
+		sorted = append(sorted, key)
+	}
+	sort.Strings(sorted)
+	for _, key := range sorted {
+		if name, ok := propNames[strings.ToLower(key)]; ok {
+			checkSchema(props[name].(map[string]any), m[key], keyPath(key), fields)
+			continue
+		}
+		switch additional := schema["additionalProperties"].(type) {
+		case map[string]any:
+			checkSchema(additional, m[key], keyPath(key), fields)
+		case bool:
+			if !additional {
+				*fields = append(*fields, FieldError{Path: keyPath(key), Rule: "additionalProperties",
+					Param: "false", Value: m[key]})
+			}
+		}
+	}
+}
+
+func toNumber(val any) (float64, bool) {
+	switch n := val.(type) {
+	case int:
+		return float64(n), true
+	case int64:
+		return float64(n), true
+	case uint64:
+		return float64(n), true
+	case float64:
+		return n, true
+	case json.Number:
+		f, err := n.Float64()
+		return f, err == nil
+	}
+	return 0, false
+}
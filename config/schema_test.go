@@ -0,0 +1,102 @@
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestJSONSchemaFromKoanfTags(t *testing.T) {
+	data, err := JSONSchema(&ServerConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var schema map[string]any
+	if err = json.Unmarshal(data, &schema); err != nil {
+		t.Fatal(err)
+	}
+
+	// logConfig, taskPool and logConfig.fileName are filled by Complete
+	if !reflect.DeepEqual(schema["required"], []any{"applicationName"}) {
+		t.Fatalf("unexpected required keys: %v", schema["required"])
+	}
+	props := schema["properties"].(map[string]any)
+	redis := props["redis"].(map[string]any)
+	if !reflect.DeepEqual(redis["required"], []any{"address"}) || redis["additionalProperties"] != false {
+		t.Fatalf("unexpected redis schema: %v", redis)
+	}
+	poolSize := redis["properties"].(map[string]any)["poolSize"].(map[string]any)
+	if poolSize["type"] != "integer" || poolSize["default"] != float64(10) || poolSize["minimum"] != float64(0) {
+		t.Fatalf("unexpected poolSize schema: %v", poolSize)
+	}
+
+	site := props["webSites"].(map[string]any)["items"].(map[string]any)
+	if _, ok := site["properties"].(map[string]any)["name"]; !ok {
+		t.Fatalf("the site name is missing: %v", site)
+	}
+	logLevel := props["logConfig"].(map[string]any)["properties"].(map[string]any)["logLevel"].(map[string]any)
+	if len(logLevel["enum"].([]any)) != 7 {
+		t.Fatalf("unexpected log level schema: %v", logLevel)
+	}
+	if required, ok := props["logConfig"].(map[string]any)["required"]; ok {
+		t.Fatalf("no key of logConfig is required: %v", required)
+	}
+}
+
+func TestValidateSchemaWithoutResolvingSecrets(t *testing.T) {
+	doc := map[string]any{
+		"applicationName": "crawler",
+		"redis":           map[string]any{"address": "${env:REDIS_ADDRESS}", "poolSize": 10},
+		"mongodb":         map[string]any{"uri": "${file:/run/secrets/mongo}"},
+		"tracing":         map[string]any{"sampleRatio": 0},
+	}
+	if err := ValidateSchema(&ServerConfig{}, doc); err != nil {
+		t.Fatalf("the document is expected to be valid: %v", err)
+	}
+
+	doc = map[string]any{
+		"redis":     map[string]any{"pasword": "typo", "poolSize": -1},
+		"logConfig": map[string]any{"logLevel": "TRACE", "maxBackups": 1.5},
+	}
+	err := ValidateSchema(&ServerConfig{}, doc)
+	var validationErr *ValidationError
+	if !errors.As(err, &validationErr) {
+		t.Fatalf("a ValidationError is expected, got %v", err)
+	}
+	var failed []string
+	for _, f := range validationErr.Fields {
+		failed = append(failed, f.Path+":"+f.Rule)
+	}
+	expected := []string{"applicationName:required", "logConfig.logLevel:enum", "logConfig.maxBackups:type",
+		"redis.address:required", "redis.pasword:additionalProperties", "redis.poolSize:minimum"}
+	if !reflect.DeepEqual(failed, expected) {
+		t.Fatalf("unexpected failed fields: %v", failed)
+	}
+}
+
+func TestStrictLoaderRejectsUnknownKeys(t *testing.T) {
+	values := map[string]any{
+		"applicationName": "crawler",
+		"redis":           map[string]any{"address": "localhost:6379", "pasword": "typo"},
+		"webSites": []any{map[string]any{
+			"name":            "site-a",
+			"attributes":      map[string]any{"anything": "goes"},
+			"crawlerSetting":  map[string]any{},
+			"crawlerSettings": map[string]any{"catalog": map[string]any{"any": "thing"}},
+		}},
+	}
+
+	if err := NewLoader().AddMap("memory", values).Load(&ServerConfig{}); err != nil {
+		t.Fatalf("unknown keys are ignored without the strict mode: %v", err)
+	}
+
+	err := NewLoader(WithStrict()).AddMap("memory", values).Load(&ServerConfig{})
+	var unknownErr *UnknownKeysError
+	if !errors.As(err, &unknownErr) {
+		t.Fatalf("an UnknownKeysError is expected, got %v", err)
+	}
+	if !reflect.DeepEqual(unknownErr.Keys, []string{"redis.pasword", "webSites[0].crawlerSetting"}) {
+		t.Fatalf("unexpected unknown keys: %v", unknownErr.Keys)
+	}
+}
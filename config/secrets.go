@@ -0,0 +1,174 @@
+package config
+
+import (
+	"context"
+	"fmt"
+	"github.com/knadh/koanf/v2"
+	"go.uber.org/zap/zapcore"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// secretRefRegex matches the secret references like ${file:/run/secrets/redis} or ${env:MONGO_URI}
+var secretRefRegex = regexp.MustCompile(`\$\{([a-zA-Z][a-zA-Z0-9_-]*):([^}]+)}`)
+
+// SecretResolver resolves the secret references of a scheme, a vault backend could be plugged in by
+// implementing a resolver for references like ${vault:secret/data/redis#password}
+type SecretResolver interface {
+	// Scheme is the prefix of the reference, e.g. file
+	Scheme() string
+	// Resolve returns the secret of the reference, the scheme isn't included in ref
+	Resolve(ctx context.Context, ref string) (string, error)
+}
+
+// FileSecretResolver reads the secret from a file, the trailing line breaks are trimmed
+type FileSecretResolver struct{}
+
+func (FileSecretResolver) Scheme() string {
+	return "file"
+}
+
+func (FileSecretResolver) Resolve(_ context.Context, ref string) (string, error) {
+	data, err := os.ReadFile(ref)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(string(data), "\r\n"), nil
+}
+
+// EnvSecretResolver reads the secret from an environment variable
+type EnvSecretResolver struct{}
+
+func (EnvSecretResolver) Scheme() string {
+	return "env"
+}
+
+func (EnvSecretResolver) Resolve(_ context.Context, ref string) (string, error) {
+	val, ok := os.LookupEnv(ref)
+	if !ok {
+		return "", fmt.Errorf("environment variable %s isn't set", ref)
+	}
+	return val, nil
+}
+
+// WithSecretResolver registers a resolver for the secret references, the built-in file and env resolvers
+// can be replaced with the same scheme
+func WithSecretResolver(resolver SecretResolver) LoadOption {
+	return func(opts *loadOptions) {
+		if opts.resolvers == nil {
+			opts.resolvers = defaultResolvers()
+		}
+		opts.resolvers[resolver.Scheme()] = resolver
+	}
+}
+
+func defaultResolvers() map[string]SecretResolver {
+	return map[string]SecretResolver{
+		FileSecretResolver{}.Scheme(): FileSecretResolver{},
+		EnvSecretResolver{}.Scheme():  EnvSecretResolver{},
+	}
+}
+
+// resolveSecrets replaces the secret references in all the string values, the keys holding a resolved
+// secret are returned
+func resolveSecrets(ctx context.Context, ko *koanf.Koanf, resolvers map[string]SecretResolver) (map[string]bool, error) {
+	secretKeys := map[string]bool{}
+	for key, val := range ko.All() {
+		var resolved any
+		var found bool
+		var err error
+
+		switch v := val.(type) {
+		case string:
+			resolved, found, err = resolveRefs(ctx, v, resolvers)
+		case []any:
+			items := make([]any, len(v))
+			for i, item := range v {
+				str, ok := item.(string)
+				if !ok {
+					items[i] = item
+					continue
+				}
+				var itemFound bool
+				if items[i], itemFound, err = resolveRefs(ctx, str, resolvers); err != nil {
+					break
+				}
+				found = found || itemFound
+			}
+			resolved = items
+		}
+
+		if err != nil {
+			return nil, fmt.Errorf("unable to resolve the secret of %s: %w", key, err)
+		}
+		if !found {
+			continue
+		}
+		if err = ko.Set(key, resolved); err != nil {
+			return nil, err
+		}
+		secretKeys[key] = true
+	}
+	return secretKeys, nil
+}
+
+func resolveRefs(ctx context.Context, val string, resolvers map[string]SecretResolver) (string, bool, error) {
+	if !strings.Contains(val, "${") {
+		return val, false, nil
+	}
+
+	var resolveErr error
+	found := false
+	result := secretRefRegex.ReplaceAllStringFunc(val, func(ref string) string {
+		if resolveErr != nil {
+			return ref
+		}
+		groups := secretRefRegex.FindStringSubmatch(ref)
+		resolver, ok := resolvers[groups[1]]
+		if !ok {
+			resolveErr = fmt.Errorf("no resolver found for the scheme '%s'", groups[1])
+			return ref
+		}
+		secret, err := resolver.Resolve(ctx, groups[2])
+		if err != nil {
+			resolveErr = err
+			return ref
+		}
+		found = true
+		return secret
+	})
+	return result, found, resolveErr
+}
+
+// String masks the password so that it's safe to print the config
+func (r RedisConfig) String() string {
+	pwd := ""
+	if r.Password != "" {
+		pwd = maskedValue
+	}
+	return fmt.Sprintf("{Address:%s Password:%s DefaultDb:%d PoolSize:%d}", r.Address, pwd, r.DefaultDb, r.PoolSize)
+}
+
+// MarshalLogObject masks the password while logging the config with zap
+func (r RedisConfig) MarshalLogObject(enc zapcore.ObjectEncoder) error {
+	enc.AddString("address", r.Address)
+	if r.Password != "" {
+		enc.AddString("password", maskedValue)
+	}
+	enc.AddInt("defaultDb", r.DefaultDb)
+	enc.AddInt("poolSize", r.PoolSize)
+	return nil
+}
+
+// String masks the credentials of the uri so that it's safe to print the config
+func (m MongoConfig) String() string {
+	return fmt.Sprintf("{Uri:%s Database:%s}", MaskURI(m.Uri), m.Database)
+}
+
+// MarshalLogObject masks the credentials of the uri while logging the config with zap
+func (m MongoConfig) MarshalLogObject(enc zapcore.ObjectEncoder) error {
+	enc.AddString("uri", MaskURI(m.Uri))
+	enc.AddString("database", m.Database)
+	return nil
+}
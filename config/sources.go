@@ -0,0 +1,70 @@
+package config
+
+import (
+	"context"
+	"errors"
+	"github.com/knadh/koanf/parsers/json"
+	"github.com/knadh/koanf/parsers/toml"
+	"github.com/knadh/koanf/parsers/yaml"
+	"github.com/knadh/koanf/v2"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	defaultRemoteTimeout = 10 * time.Second
+	defaultPollInterval  = time.Minute
+)
+
+// RemoteSource provides the configuration kept outside the config files, e.g. the site definitions
+// stored in mongo or redis. The returned map is merged into the same koanf tree as the files, note that
+// a list such as webSites replaces the one from the former sources.
+type RemoteSource interface {
+	Name() string
+	Read(ctx context.Context) (map[string]any, error)
+}
+
+// WithRemoteSource merges the remote source after the config files and before the environment variables,
+// the remote sources are polled by the Watcher if one is set
+func WithRemoteSource(src RemoteSource) LoadOption {
+	return func(opts *loadOptions) {
+		opts.remotes = append(opts.remotes, src)
+	}
+}
+
+// WithPollInterval sets how often the Watcher reads the remote sources, it's one minute by default
+func WithPollInterval(interval time.Duration) LoadOption {
+	return func(opts *loadOptions) {
+		opts.pollInterval = interval
+	}
+}
+
+// ParserForFile picks the parser by the file extension, json and toml are picked by .json and .toml while
+// yaml is the fallback for the other or missing extensions, e.g. a config mounted as /etc/crawler/config
+func ParserForFile(path string) (koanf.Parser, error) {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json":
+		return json.Parser(), nil
+	case ".toml":
+		return toml.Parser(), nil
+	default:
+		return yaml.Parser(), nil
+	}
+}
+
+// remoteProvider adapts a RemoteSource to a koanf provider
+type remoteProvider struct {
+	src     RemoteSource
+	timeout time.Duration
+}
+
+func (r *remoteProvider) ReadBytes() ([]byte, error) {
+	return nil, errors.New("remote provider does not support this method")
+}
+
+func (r *remoteProvider) Read() (map[string]any, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), r.timeout)
+	defer cancel()
+	return r.src.Read(ctx)
+}
@@ -0,0 +1,94 @@
+package config
+
+import (
+	"context"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+type memorySource struct {
+	lock   sync.Mutex
+	values map[string]any
+}
+
+func (m *memorySource) Name() string {
+	return "memory"
+}
+
+func (m *memorySource) Read(_ context.Context) (map[string]any, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	return m.values, nil
+}
+
+func (m *memorySource) set(values map[string]any) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.values = values
+}
+
+func TestLoaderPicksParserByExtension(t *testing.T) {
+	dir := t.TempDir()
+	jsonFile := filepath.Join(dir, "config.json")
+	tomlFile := filepath.Join(dir, "site.toml")
+	writeFile(t, jsonFile, `{"applicationName": "crawler", "taskPool": {"capacity": 5}}`)
+	writeFile(t, tomlFile, "[[webSites]]\nname = \"site-a\"\nuseSeparateSpace = true\n")
+
+	var cfg ServerConfig
+	if err := NewLoader().AddFile(jsonFile).AddFile(tomlFile).Load(&cfg); err != nil {
+		t.Fatal(err)
+	}
+	if cfg.TaskPoolSetting.Capacity != 5 || len(cfg.WebSites) != 1 || !cfg.WebSites[0].UseSeparateSpace {
+		t.Fatalf("unexpected config: %+v", cfg)
+	}
+
+	// the other or missing extensions are parsed as yaml
+	for _, name := range []string{"config", "config.conf"} {
+		file := filepath.Join(dir, name)
+		writeFile(t, file, "applicationName: crawler\ntaskPool:\n  capacity: 7\n")
+		cfg = ServerConfig{}
+		if err := NewLoader().AddFile(file).Load(&cfg); err != nil {
+			t.Fatal(err)
+		}
+		if cfg.TaskPoolSetting.Capacity != 7 {
+			t.Fatalf("unexpected config of %s: %+v", name, cfg.TaskPoolSetting)
+		}
+	}
+}
+
+func TestWatcherPollsRemoteSources(t *testing.T) {
+	remote := &memorySource{values: map[string]any{
+		"webSites": []any{map[string]any{"name": "site-a"}},
+	}}
+
+	watcher := NewWatcher()
+	defer watcher.Close()
+	events := make(chan *ChangeEvent, 1)
+	watcher.Subscribe(func(event *ChangeEvent) {
+		events <- event
+	})
+
+	var cfg ServerConfig
+	err := LoadConfig([]byte("applicationName: crawler"), &cfg, nil, nil,
+		WithRemoteSource(remote), WithPollInterval(50*time.Millisecond), WithWatcher(watcher))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cfg.WebSites) != 1 {
+		t.Fatalf("unexpected sites: %+v", cfg.WebSites)
+	}
+
+	remote.set(map[string]any{
+		"webSites": []any{map[string]any{"name": "site-a"}, map[string]any{"name": "site-b"}},
+	})
+	select {
+	case event := <-events:
+		if !event.Changed("webSites") || len(event.New.(*ServerConfig).WebSites) != 2 {
+			t.Fatalf("unexpected event: %+v", event.Changes)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("the remote source is not polled")
+	}
+}
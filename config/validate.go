@@ -0,0 +1,265 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"github.com/go-playground/validator/v10"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var validate *validator.Validate
+var validateOnce sync.Once
+
+// FieldError describes a single field that failed the validation
+type FieldError struct {
+	// Path is the koanf path of the field, e.g. redis.address
+	Path  string
+	Rule  string
+	Param string
+	Value any
+}
+
+func (f FieldError) Error() string {
+	if f.Param != "" {
+		return fmt.Sprintf("%s: failed on rule '%s=%s' (value: %v)", f.Path, f.Rule, f.Param, f.Value)
+	}
+	return fmt.Sprintf("%s: failed on rule '%s' (value: %v)", f.Path, f.Rule, f.Value)
+}
+
+// ValidationError aggregates all the failed fields of a config
+type ValidationError struct {
+	Fields []FieldError
+}
+
+func (v *ValidationError) Error() string {
+	msgs := make([]string, 0, len(v.Fields))
+	for _, f := range v.Fields {
+		msgs = append(msgs, f.Error())
+	}
+	return fmt.Sprintf("invalid config, %d field(s) failed: %s", len(v.Fields), strings.Join(msgs, "; "))
+}
+
+func getValidator() *validator.Validate {
+	validateOnce.Do(func() {
+		validate = validator.New()
+
+		// report the koanf key instead of the go field name
+		validate.RegisterTagNameFunc(func(field reflect.StructField) string {
+			name := koanfName(field)
+			if name == "-" {
+				return ""
+			}
+			return name
+		})
+
+		_ = validate.RegisterValidation("mongouri", func(fl validator.FieldLevel) bool {
+			uri := fl.Field().String()
+			return strings.HasPrefix(uri, "mongodb://") || strings.HasPrefix(uri, "mongodb+srv://")
+		})
+		_ = validate.RegisterValidation("regexp", func(fl validator.FieldLevel) bool {
+			_, err := regexp.Compile(fl.Field().String())
+			return err == nil
+		})
+	})
+	return validate
+}
+
+// koanfName returns the key name declared in the koanf tag, the go field name is used if the tag is missing
+func koanfName(field reflect.StructField) string {
+	tag := field.Tag.Get("koanf")
+	if tag == "" {
+		return field.Name
+	}
+	return strings.Split(tag, ",")[0]
+}
+
+// ValidateStruct validates a config struct with the `validate` tags, all failed fields are returned
+// in a ValidationError
+func ValidateStruct(cfg any) error {
+	err := getValidator().Struct(cfg)
+	if err == nil {
+		return nil
+	}
+
+	var errs validator.ValidationErrors
+	if !errors.As(err, &errs) {
+		return err
+	}
+
+	result := &ValidationError{}
+	for _, fe := range errs {
+		path := fe.Namespace()
+		// drop the name of the root struct
+		if idx := strings.Index(path, "."); idx >= 0 {
+			path = path[idx+1:]
+		}
+		result.Fields = append(result.Fields, FieldError{
+			Path:  path,
+			Rule:  fe.Tag(),
+			Param: fe.Param(),
+			Value: fe.Value(),
+		})
+	}
+	return result
+}
+
+// SetDefaults walks through the struct and sets the value declared in the `default` tag into every
+// zero valued field. Nil pointers are left untouched so that optional sections stay optional.
+// It's used for the configs built in code, a Loader takes the defaults from the absent keys instead so
+// that an explicit zero value isn't replaced.
+func SetDefaults(cfg any) error {
+	val := reflect.ValueOf(cfg)
+	if val.Kind() != reflect.Ptr || val.IsNil() {
+		return errors.New("a non-nil pointer is required to set default values")
+	}
+	return setDefaults(val.Elem(), "")
+}
+
+func setDefaults(val reflect.Value, path string) error {
+	switch val.Kind() {
+	case reflect.Ptr:
+		if !val.IsNil() {
+			return setDefaults(val.Elem(), path)
+		}
+	case reflect.Slice:
+		for i := 0; i < val.Len(); i++ {
+			if err := setDefaults(val.Index(i), fmt.Sprintf("%s[%d]", path, i)); err != nil {
+				return err
+			}
+		}
+	case reflect.Struct:
+		typ := val.Type()
+		for i := 0; i < typ.NumField(); i++ {
+			field := typ.Field(i)
+			if !field.IsExported() {
+				continue
+			}
+			fieldPath := koanfName(field)
+			if path != "" {
+				fieldPath = path + "." + fieldPath
+			}
+
+			fieldVal := val.Field(i)
+			if def, ok := field.Tag.Lookup("default"); ok && fieldVal.IsZero() {
+				if err := setValue(fieldVal, def); err != nil {
+					return fmt.Errorf("invalid default value for %s: %w", fieldPath, err)
+				}
+				continue
+			}
+			if err := setDefaults(fieldVal, fieldPath); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func setValue(field reflect.Value, value string) error {
+	if field.Type() == reflect.TypeOf(time.Duration(0)) {
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			return err
+		}
+		field.SetInt(int64(d))
+		return nil
+	}
+
+	switch field.Kind() {
+	case reflect.String:
+		field.SetString(value)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return err
+		}
+		field.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		i, err := strconv.ParseInt(value, 10, field.Type().Bits())
+		if err != nil {
+			return err
+		}
+		field.SetInt(i)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		u, err := strconv.ParseUint(value, 10, field.Type().Bits())
+		if err != nil {
+			return err
+		}
+		field.SetUint(u)
+	case reflect.Float32, reflect.Float64:
+		f, err := strconv.ParseFloat(value, field.Type().Bits())
+		if err != nil {
+			return err
+		}
+		field.SetFloat(f)
+	case reflect.Slice:
+		if field.Type().Elem().Kind() != reflect.String {
+			return fmt.Errorf("unsupported type %v", field.Type())
+		}
+		field.Set(reflect.ValueOf(strings.Split(value, ",")))
+	default:
+		return fmt.Errorf("unsupported type %v", field.Type())
+	}
+	return nil
+}
+
+// defaultsLoader is implemented by the configs which keep the zero values on Complete once the defaults
+// are loaded, e.g. the ones embedding ServerConfig
+type defaultsLoader interface {
+	setDefaultsLoaded()
+}
+
+// defaultValues returns the values of the `default` tags of the keys which aren't set, the keys of the
+// optional sections which aren't set are skipped so that they stay nil. The defaults of the list items
+// aren't supported since they can't be addressed by a key.
+func defaultValues(typ reflect.Type, isSet func(key string) bool) (map[string]any, error) {
+	values := map[string]any{}
+	if err := collectDefaults(typ, "", isSet, values); err != nil {
+		return nil, err
+	}
+	return values, nil
+}
+
+func collectDefaults(typ reflect.Type, path string, isSet func(key string) bool, values map[string]any) error {
+	for typ.Kind() == reflect.Ptr {
+		typ = typ.Elem()
+	}
+	if typ.Kind() != reflect.Struct {
+		return nil
+	}
+
+	for i := 0; i < typ.NumField(); i++ {
+		field := typ.Field(i)
+		name := koanfName(field)
+		if !field.IsExported() || name == "-" {
+			continue
+		}
+		key := name
+		if path != "" {
+			key = path + "." + name
+		}
+
+		if def, ok := field.Tag.Lookup("default"); ok {
+			if isSet(key) {
+				continue
+			}
+			value := reflect.New(field.Type).Elem()
+			if err := setValue(value, def); err != nil {
+				return fmt.Errorf("invalid default value for %s: %w", key, err)
+			}
+			values[key] = value.Interface()
+			continue
+		}
+		if field.Type.Kind() == reflect.Ptr && !isSet(key) {
+			continue
+		}
+		if err := collectDefaults(field.Type, key, isSet, values); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,308 @@
+package config
+
+import (
+	"errors"
+	"github.com/fsnotify/fsnotify"
+	"log"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const defaultReloadDelay = 300 * time.Millisecond
+
+// Change describes a key changed by a reload, Old is nil for a new key and New is nil for a removed key.
+// The values of the secrets are masked, see IsSecretKey and SecretResolver.
+type Change struct {
+	Key string
+	Old any
+	New any
+}
+
+// ChangeEvent is published to the subscribers once the reloaded config is validated and swapped
+type ChangeEvent struct {
+	Old     Config
+	New     Config
+	Changes []Change
+}
+
+// Changed reports whether the key or any key under it is changed
+func (e *ChangeEvent) Changed(key string) bool {
+	for _, c := range e.Changes {
+		if c.Key == key || (len(c.Key) > len(key) && c.Key[:len(key)+1] == key+".") {
+			return true
+		}
+	}
+	return false
+}
+
+type snapshot struct {
+	config Config
+	values map[string]any
+	// the keys whose value is resolved from a secret reference
+	secretKeys map[string]bool
+}
+
+// Watcher reloads the config files on changes and polls the remote sources, see WithWatcher
+type Watcher struct {
+	current     atomic.Value
+	subscribers []*subscriber
+	subLock     sync.RWMutex
+	reloadLock  sync.Mutex
+	reloadDelay time.Duration
+
+	fsWatcher *fsnotify.Watcher
+	loader    *Loader
+	cfgPaths  []string
+	cfgType   reflect.Type
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// NewWatcher creates a watcher that is started by LoadConfig(..., WithWatcher(watcher)) or Watch
+func NewWatcher() *Watcher {
+	return &Watcher{reloadDelay: defaultReloadDelay, done: make(chan struct{})}
+}
+
+// Current returns the latest valid config, it is nil before LoadConfig is called
+func (w *Watcher) Current() Config {
+	if s, ok := w.current.Load().(*snapshot); ok {
+		return s.config
+	}
+	return nil
+}
+
+type subscriber struct {
+	fn func(event *ChangeEvent)
+}
+
+// Subscribe registers a function called after every successful reload, the returned function unsubscribes it
+func (w *Watcher) Subscribe(fn func(event *ChangeEvent)) (unsubscribe func()) {
+	sub := &subscriber{fn: fn}
+	w.subLock.Lock()
+	defer w.subLock.Unlock()
+	w.subscribers = append(w.subscribers, sub)
+
+	return func() {
+		w.subLock.Lock()
+		defer w.subLock.Unlock()
+		// a new slice is made since the reload may be iterating the current one
+		subscribers := make([]*subscriber, 0, len(w.subscribers))
+		for _, s := range w.subscribers {
+			if s != sub {
+				subscribers = append(subscribers, s)
+			}
+		}
+		w.subscribers = subscribers
+	}
+}
+
+// Close stops watching the config files
+func (w *Watcher) Close() error {
+	var err error
+	w.closeOnce.Do(func() {
+		close(w.done)
+		if w.fsWatcher != nil {
+			err = w.fsWatcher.Close()
+		}
+	})
+	return err
+}
+
+// Watch watches the file sources of the loader, config is the one loaded by the loader
+func (w *Watcher) Watch(loader *Loader, config Config) error {
+	if w.fsWatcher != nil {
+		return errors.New("the watcher is already started")
+	}
+
+	cfgType := reflect.TypeOf(config)
+	if cfgType.Kind() != reflect.Ptr {
+		return errors.New("a pointer of config is required to watch the config files")
+	}
+
+	fsWatcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+
+	// watch the directories rather than the files since most editors replace the file while saving
+	cfgPaths := loader.files()
+	dirs := map[string]bool{}
+	for i, p := range cfgPaths {
+		if abs, err := filepath.Abs(p); err == nil {
+			cfgPaths[i] = abs
+		}
+		dir := filepath.Dir(cfgPaths[i])
+		if dirs[dir] {
+			continue
+		}
+		if err = fsWatcher.Add(dir); err != nil {
+			log.Printf("unable to watch %s: %v", dir, err)
+			continue
+		}
+		dirs[dir] = true
+	}
+
+	w.fsWatcher = fsWatcher
+	w.loader = loader
+	w.cfgPaths = cfgPaths
+	w.cfgType = cfgType.Elem()
+	w.current.Store(&snapshot{config: config, values: loader.All(), secretKeys: loader.secrets()})
+
+	go w.watch()
+	if len(loader.opts.remotes) > 0 {
+		interval := loader.opts.pollInterval
+		if interval <= 0 {
+			interval = defaultPollInterval
+		}
+		go w.poll(interval)
+	}
+	return nil
+}
+
+// poll reloads the config periodically since the remote sources can't be watched
+func (w *Watcher) poll(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-w.done:
+			return
+		case <-ticker.C:
+			if err := w.Reload(); err != nil {
+				log.Printf("failed to reload the config, the previous one is kept: %v", err)
+			}
+		}
+	}
+}
+
+func (w *Watcher) watch() {
+	var timer *time.Timer
+	for {
+		select {
+		case <-w.done:
+			if timer != nil {
+				timer.Stop()
+			}
+			return
+		case event, ok := <-w.fsWatcher.Events:
+			if !ok {
+				return
+			}
+			if !w.isConfigFile(event.Name) || event.Op == fsnotify.Chmod {
+				continue
+			}
+			// an editor may write a file several times while saving, so the reload is delayed
+			if timer != nil {
+				timer.Stop()
+			}
+			timer = time.AfterFunc(w.reloadDelay, func() {
+				if err := w.Reload(); err != nil {
+					log.Printf("failed to reload the config, the previous one is kept: %v", err)
+				}
+			})
+		case err, ok := <-w.fsWatcher.Errors:
+			if !ok {
+				return
+			}
+			log.Printf("an error occurs while watching the config files: %v", err)
+		}
+	}
+}
+
+func (w *Watcher) isConfigFile(name string) bool {
+	for _, p := range w.cfgPaths {
+		if filepath.Clean(name) == p {
+			return true
+		}
+	}
+	return false
+}
+
+// Reload loads the config again, the new config is swapped in only if it's valid
+func (w *Watcher) Reload() error {
+	w.reloadLock.Lock()
+	defer w.reloadLock.Unlock()
+
+	if w.cfgType == nil {
+		return errors.New("the watcher isn't started")
+	}
+
+	cfg, ok := reflect.New(w.cfgType).Interface().(Config)
+	if !ok {
+		return errors.New("unable to create a new config instance")
+	}
+	if err := w.loader.Load(cfg); err != nil {
+		return err
+	}
+
+	old := w.current.Load().(*snapshot)
+	current := &snapshot{config: cfg, values: w.loader.All(), secretKeys: w.loader.secrets()}
+	changes := diff(old.values, current.values)
+	if len(changes) == 0 {
+		return nil
+	}
+	w.current.Store(current)
+	maskChanges(changes, old.secretKeys, current.secretKeys)
+
+	event := &ChangeEvent{Old: old.config, New: cfg, Changes: changes}
+	w.subLock.RLock()
+	subscribers := w.subscribers
+	w.subLock.RUnlock()
+	for _, sub := range subscribers {
+		notify(sub.fn, event)
+	}
+	return nil
+}
+
+func notify(fn func(event *ChangeEvent), event *ChangeEvent) {
+	defer func() {
+		if err := recover(); err != nil {
+			log.Printf("a config subscriber panics: %v", err)
+		}
+	}()
+	fn(event)
+}
+
+// maskChanges masks the values of the secrets, a key is a secret if it's resolved from a secret reference
+// before or after the reload
+func maskChanges(changes []Change, oldSecretKeys map[string]bool, newSecretKeys map[string]bool) {
+	mask := func(key string, val any) any {
+		if val == nil {
+			return nil
+		}
+		if oldSecretKeys[key] || newSecretKeys[key] {
+			return maskedValue
+		}
+		return maskValue(key, val)
+	}
+	for i, c := range changes {
+		changes[i].Old = mask(c.Key, c.Old)
+		changes[i].New = mask(c.Key, c.New)
+	}
+}
+
+// diff compares two flattened config maps
+func diff(old, new map[string]any) []Change {
+	var changes []Change
+	for key, oldVal := range old {
+		newVal, ok := new[key]
+		if !ok {
+			changes = append(changes, Change{Key: key, Old: oldVal})
+		} else if !reflect.DeepEqual(oldVal, newVal) {
+			changes = append(changes, Change{Key: key, Old: oldVal, New: newVal})
+		}
+	}
+	for key, newVal := range new {
+		if _, ok := old[key]; !ok {
+			changes = append(changes, Change{Key: key, New: newVal})
+		}
+	}
+	sort.Slice(changes, func(i, j int) bool {
+		return changes[i].Key < changes[j].Key
+	})
+	return changes
+}
@@ -0,0 +1,87 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// CollectionSource is a config.RemoteSource that reads every document of a collection as an item of
+// the list under key, e.g. the site definitions stored in collection "sites" for key "webSites"
+type CollectionSource struct {
+	collection *mongo.Collection
+	key        string
+	filter     any
+}
+
+// NewCollectionSource creates a source of the collection, all documents are read if filter is nil
+func NewCollectionSource(collection *mongo.Collection, key string, filter any) *CollectionSource {
+	if filter == nil {
+		filter = bson.M{}
+	}
+	return &CollectionSource{collection: collection, key: key, filter: filter}
+}
+
+func (c *CollectionSource) Name() string {
+	return fmt.Sprintf("mongo:%s/%s", c.collection.Database().Name(), c.collection.Name())
+}
+
+func (c *CollectionSource) Read(ctx context.Context) (map[string]any, error) {
+	cursor, err := c.collection.Find(ctx, c.filter)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var docs []bson.M
+	if err = cursor.All(ctx, &docs); err != nil {
+		return nil, err
+	}
+
+	items := make([]any, 0, len(docs))
+	for _, doc := range docs {
+		delete(doc, "_id")
+		items = append(items, toPlainValue(doc))
+	}
+	return map[string]any{c.key: items}, nil
+}
+
+// toPlainValue converts the bson maps and arrays into the plain types that koanf is able to merge
+func toPlainValue(val any) any {
+	switch v := val.(type) {
+	case bson.M:
+		return toPlainMap(v)
+	case map[string]any:
+		return toPlainMap(v)
+	case bson.D:
+		m := make(map[string]any, len(v))
+		for _, e := range v {
+			m[e.Key] = e.Value
+		}
+		return toPlainMap(m)
+	case primitive.A:
+		return toPlainSlice(v)
+	case []any:
+		return toPlainSlice(v)
+	default:
+		return v
+	}
+}
+
+func toPlainMap(m map[string]any) map[string]any {
+	result := make(map[string]any, len(m))
+	for key, item := range m {
+		result[key] = toPlainValue(item)
+	}
+	return result
+}
+
+func toPlainSlice(items []any) []any {
+	result := make([]any, len(items))
+	for i, item := range items {
+		result[i] = toPlainValue(item)
+	}
+	return result
+}
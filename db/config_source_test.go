@@ -0,0 +1,51 @@
+package db
+
+import (
+	"context"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+	"reflect"
+	"testing"
+)
+
+func TestCollectionSourceRead(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	defer mt.Close()
+
+	mt.Run("read the documents as a list", func(mt *mtest.T) {
+		mt.AddMockResponses(mtest.CreateCursorResponse(0, "crawler.sites", mtest.FirstBatch,
+			bson.D{
+				{Key: "_id", Value: primitive.NewObjectID()},
+				{Key: "name", Value: "site-a"},
+				{Key: "attributes", Value: bson.D{{Key: "lang", Value: "zh"}}},
+				{Key: "catalogUrls", Value: bson.A{"https://example.com/1"}},
+			},
+			bson.D{{Key: "_id", Value: primitive.NewObjectID()}, {Key: "name", Value: "site-b"}},
+		))
+
+		src := NewCollectionSource(mt.Coll, "webSites", nil)
+		values, err := src.Read(context.Background())
+		if err != nil {
+			mt.Fatal(err)
+		}
+		expected := map[string]any{"webSites": []any{
+			map[string]any{
+				"name":        "site-a",
+				"attributes":  map[string]any{"lang": "zh"},
+				"catalogUrls": []any{"https://example.com/1"},
+			},
+			map[string]any{"name": "site-b"},
+		}}
+		if !reflect.DeepEqual(values, expected) {
+			mt.Fatalf("unexpected values: %v", values)
+		}
+	})
+
+	mt.Run("report the failure of the query", func(mt *mtest.T) {
+		mt.AddMockResponses(mtest.CreateCommandErrorResponse(mtest.CommandError{Code: 13, Message: "unauthorized"}))
+		if _, err := NewCollectionSource(mt.Coll, "webSites", nil).Read(context.Background()); err == nil {
+			mt.Fatal("the failure should be reported")
+		}
+	})
+}
@@ -3,6 +3,7 @@ package db
 import (
 	"context"
 	"github.com/jeven2016/mylibs/config"
+	"go.mongodb.org/mongo-driver/event"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
 	"go.mongodb.org/mongo-driver/mongo/readpref"
@@ -15,13 +16,14 @@ type Mongo struct {
 	Config *config.MongoConfig
 }
 
-func NewMongo(ctx context.Context, config *config.MongoConfig) (*Mongo, error) {
+// NewMongo connects to mongodb, the extra options are applied after the uri, e.g. a command monitor
+func NewMongo(ctx context.Context, config *config.MongoConfig, opts ...*options.ClientOptions) (*Mongo, error) {
 	var mg Mongo
 	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
 	defer cancel()
 
 	// 连接MongoDB
-	client, err := mongo.Connect(ctx, options.Client().ApplyURI(config.Uri))
+	client, err := mongo.Connect(ctx, append([]*options.ClientOptions{options.Client().ApplyURI(config.Uri)}, opts...)...)
 	if err != nil {
 		return nil, err
 	}
@@ -40,3 +42,30 @@ func NewMongo(ctx context.Context, config *config.MongoConfig) (*Mongo, error) {
 
 	return &mg, nil
 }
+
+// CombineMonitors calls the monitors in order, since the client accepts only one command monitor
+func CombineMonitors(monitors ...*event.CommandMonitor) *event.CommandMonitor {
+	return &event.CommandMonitor{
+		Started: func(ctx context.Context, evt *event.CommandStartedEvent) {
+			for _, m := range monitors {
+				if m.Started != nil {
+					m.Started(ctx, evt)
+				}
+			}
+		},
+		Succeeded: func(ctx context.Context, evt *event.CommandSucceededEvent) {
+			for _, m := range monitors {
+				if m.Succeeded != nil {
+					m.Succeeded(ctx, evt)
+				}
+			}
+		},
+		Failed: func(ctx context.Context, evt *event.CommandFailedEvent) {
+			for _, m := range monitors {
+				if m.Failed != nil {
+					m.Failed(ctx, evt)
+				}
+			}
+		},
+	}
+}
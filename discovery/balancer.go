@@ -0,0 +1,194 @@
+package discovery
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+const (
+	defaultMaxFailures   = 3
+	defaultEjectDuration = 30 * time.Second
+)
+
+// Strategy selects an instance among the available ones
+type Strategy int
+
+const (
+	// RoundRobin picks the instances in turn
+	RoundRobin Strategy = iota
+	// LeastLoaded picks the instance with the fewest in-flight requests
+	LeastLoaded
+)
+
+// Balancer picks an instance for each request and ejects the instances failing continuously for a while
+type Balancer struct {
+	strategy      Strategy
+	maxFailures   int
+	ejectDuration time.Duration
+
+	lock      sync.Mutex
+	counters  map[string]uint64
+	instances map[string]*instanceState
+	// the instances of each service in the latest list passed to Pick
+	services map[string]map[string]struct{}
+	// the instances gone from the lists while requests are in flight, they're forgotten once idle
+	stale map[string]struct{}
+}
+
+type instanceState struct {
+	inFlight     int
+	failures     int
+	ejectedUntil time.Time
+}
+
+// BalancerOption customizes a Balancer
+type BalancerOption func(b *Balancer)
+
+// WithEjection ejects an instance for the duration once it fails maxFailures times in a row
+func WithEjection(maxFailures int, duration time.Duration) BalancerOption {
+	return func(b *Balancer) {
+		b.maxFailures = maxFailures
+		b.ejectDuration = duration
+	}
+}
+
+// NewBalancer creates a balancer, an instance is ejected for 30 seconds after 3 failures by default
+func NewBalancer(strategy Strategy, options ...BalancerOption) *Balancer {
+	b := &Balancer{
+		strategy:      strategy,
+		maxFailures:   defaultMaxFailures,
+		ejectDuration: defaultEjectDuration,
+		counters:      map[string]uint64{},
+		instances:     map[string]*instanceState{},
+		services:      map[string]map[string]struct{}{},
+		stale:         map[string]struct{}{},
+	}
+	for _, o := range options {
+		o(b)
+	}
+	return b
+}
+
+// Pick selects an instance of the service, Done must be called once the request completes. The ejected
+// instances are skipped unless all of them are ejected.
+func (b *Balancer) Pick(service string, instances []string) (string, error) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	b.prune(service, instances)
+	if len(instances) == 0 {
+		return "", fmt.Errorf("%w for service %s", ErrNoInstance, service)
+	}
+
+	now := time.Now()
+	candidates := make([]string, 0, len(instances))
+	for _, instance := range instances {
+		if b.state(instance).ejectedUntil.Before(now) {
+			candidates = append(candidates, instance)
+		}
+	}
+	if len(candidates) == 0 {
+		// better to try an ejected instance than fail without any request
+		candidates = instances
+	}
+
+	var picked string
+	switch b.strategy {
+	case LeastLoaded:
+		picked = candidates[0]
+		for _, instance := range candidates[1:] {
+			if b.state(instance).inFlight < b.state(picked).inFlight {
+				picked = instance
+			}
+		}
+	default:
+		picked = candidates[b.counters[service]%uint64(len(candidates))]
+		b.counters[service]++
+	}
+
+	b.state(picked).inFlight++
+	return picked, nil
+}
+
+// Done reports the result of a request sent to the instance
+func (b *Balancer) Done(instance string, err error) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	state := b.state(instance)
+	if state.inFlight > 0 {
+		state.inFlight--
+	}
+	if _, ok := b.stale[instance]; ok && state.inFlight == 0 {
+		delete(b.stale, instance)
+		delete(b.instances, instance)
+		return
+	}
+	if err == nil {
+		state.failures = 0
+		return
+	}
+
+	state.failures++
+	if b.maxFailures > 0 && state.failures >= b.maxFailures {
+		state.ejectedUntil = time.Now().Add(b.ejectDuration)
+		state.failures = 0
+	}
+}
+
+// Ejected reports whether the instance is ejected now
+func (b *Balancer) Ejected(instance string) bool {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	state, ok := b.instances[instance]
+	return ok && state.ejectedUntil.After(time.Now())
+}
+
+// prune forgets the instances which are gone from the latest list of the service and aren't listed by
+// another service, so that the states don't pile up while the instances come and go
+func (b *Balancer) prune(service string, instances []string) {
+	latest := make(map[string]struct{}, len(instances))
+	for _, instance := range instances {
+		latest[instance] = struct{}{}
+	}
+	previous := b.services[service]
+	if len(latest) == 0 {
+		delete(b.services, service)
+	} else {
+		b.services[service] = latest
+	}
+
+	for instance := range previous {
+		if _, ok := latest[instance]; ok || b.listed(instance) {
+			continue
+		}
+		if state, ok := b.instances[instance]; ok && state.inFlight > 0 {
+			b.stale[instance] = struct{}{}
+			continue
+		}
+		delete(b.instances, instance)
+	}
+	// an instance listed again is no longer stale
+	for instance := range latest {
+		delete(b.stale, instance)
+	}
+}
+
+func (b *Balancer) listed(instance string) bool {
+	for _, instances := range b.services {
+		if _, ok := instances[instance]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+func (b *Balancer) state(instance string) *instanceState {
+	state, ok := b.instances[instance]
+	if !ok {
+		state = &instanceState{}
+		b.instances[instance] = state
+	}
+	return state
+}
@@ -0,0 +1,113 @@
+package discovery
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestRoundRobin(t *testing.T) {
+	b := NewBalancer(RoundRobin)
+	instances := []string{"a:80", "b:80", "c:80"}
+	var picked []string
+	for i := 0; i < 4; i++ {
+		instance, err := b.Pick("svc", instances)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b.Done(instance, nil)
+		picked = append(picked, instance)
+	}
+	if picked[0] != "a:80" || picked[1] != "b:80" || picked[2] != "c:80" || picked[3] != "a:80" {
+		t.Fatalf("unexpected order: %v", picked)
+	}
+}
+
+func TestLeastLoaded(t *testing.T) {
+	b := NewBalancer(LeastLoaded)
+	instances := []string{"a:80", "b:80"}
+	first, _ := b.Pick("svc", instances)
+	second, _ := b.Pick("svc", instances)
+	if first == second {
+		t.Fatalf("the busy instance is picked again: %s", first)
+	}
+	b.Done(first, nil)
+	third, _ := b.Pick("svc", instances)
+	if third != first {
+		t.Fatalf("the idle instance %s is expected, got %s", first, third)
+	}
+}
+
+func TestEjection(t *testing.T) {
+	b := NewBalancer(RoundRobin, WithEjection(2, 100*time.Millisecond))
+	for i := 0; i < 2; i++ {
+		b.Done("a:80", errors.New("connection refused"))
+	}
+	if !b.Ejected("a:80") {
+		t.Fatal("the instance should be ejected")
+	}
+	for i := 0; i < 3; i++ {
+		if instance, _ := b.Pick("svc", []string{"a:80", "b:80"}); instance != "b:80" {
+			t.Fatalf("the ejected instance is picked")
+		}
+		b.Done("b:80", nil)
+	}
+
+	// all instances ejected, the request is still sent
+	if instance, err := b.Pick("svc", []string{"a:80"}); err != nil || instance != "a:80" {
+		t.Fatalf("unexpected pick: %s, %v", instance, err)
+	}
+
+	time.Sleep(150 * time.Millisecond)
+	if b.Ejected("a:80") {
+		t.Fatal("the instance should be back after the ejection")
+	}
+}
+
+func TestStatic(t *testing.T) {
+	d := Static{"svc": {"a:80"}}
+	if _, err := d.Instances(context.Background(), "other"); !errors.Is(err, ErrNoInstance) {
+		t.Fatalf("ErrNoInstance is expected, got %v", err)
+	}
+}
+
+func TestPruneGoneInstances(t *testing.T) {
+	b := NewBalancer(LeastLoaded)
+	if _, err := b.Pick("svc", []string{"a:80", "b:80"}); err != nil {
+		t.Fatal(err)
+	}
+	b.Done("a:80", nil)
+	if _, err := b.Pick("other", []string{"b:80"}); err != nil {
+		t.Fatal(err)
+	}
+
+	// b:80 is in flight and still listed by the other service
+	inFlight, _ := b.Pick("svc", []string{"c:80"})
+	if _, ok := b.instances["a:80"]; ok || len(b.instances) != 2 {
+		t.Fatalf("a:80 should be pruned: %v", b.instances)
+	}
+
+	// the in-flight request of the instance gone is still reported
+	if _, err := b.Pick("svc", []string{"d:80"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := b.instances[inFlight]; !ok {
+		t.Fatal("the in-flight instance should be kept")
+	}
+	b.Done(inFlight, nil)
+	if _, ok := b.instances[inFlight]; ok {
+		t.Fatal("the idle instance gone should be pruned")
+	}
+
+	if _, err := b.Pick("svc", nil); !errors.Is(err, ErrNoInstance) {
+		t.Fatalf("ErrNoInstance is expected, got %v", err)
+	}
+	if _, err := b.Pick("other", nil); !errors.Is(err, ErrNoInstance) {
+		t.Fatalf("ErrNoInstance is expected, got %v", err)
+	}
+	b.Done("b:80", nil)
+	if len(b.instances) != 1 || len(b.services) != 0 {
+		t.Fatalf("only the in-flight d:80 should be left: %v, %v", b.instances, b.services)
+	}
+}
@@ -0,0 +1,63 @@
+package discovery
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+)
+
+// ErrNoInstance is returned if no instance of a service is found or all of them are ejected
+var ErrNoInstance = errors.New("no available instance")
+
+// Discovery looks up the addresses (host:port) of a service
+type Discovery interface {
+	Instances(ctx context.Context, service string) ([]string, error)
+}
+
+// Func adapts a lookup function to Discovery, e.g. discovery.Func(sys.GetServiceAddresses) for the
+// services registered in etcd
+type Func func(ctx context.Context, service string) ([]string, error)
+
+func (f Func) Instances(ctx context.Context, service string) ([]string, error) {
+	return f(ctx, service)
+}
+
+// Static is a fixed list of addresses per service
+type Static map[string][]string
+
+func (s Static) Instances(_ context.Context, service string) ([]string, error) {
+	instances, ok := s[service]
+	if !ok || len(instances) == 0 {
+		return nil, fmt.Errorf("%w for service %s", ErrNoInstance, service)
+	}
+	return instances, nil
+}
+
+// DNSSRV looks up the instances with the SRV records _<service>._<proto>.<domain>
+type DNSSRV struct {
+	Resolver *net.Resolver
+	Proto    string
+	Domain   string
+}
+
+// NewDNSSRV creates a DNS SRV discovery with the default resolver and tcp proto
+func NewDNSSRV(domain string) *DNSSRV {
+	return &DNSSRV{Resolver: net.DefaultResolver, Proto: "tcp", Domain: domain}
+}
+
+func (d *DNSSRV) Instances(ctx context.Context, service string) ([]string, error) {
+	_, records, err := d.Resolver.LookupSRV(ctx, service, d.Proto, d.Domain)
+	if err != nil {
+		return nil, err
+	}
+	instances := make([]string, 0, len(records))
+	for _, r := range records {
+		instances = append(instances, net.JoinHostPort(strings.TrimSuffix(r.Target, "."), fmt.Sprint(r.Port)))
+	}
+	if len(instances) == 0 {
+		return nil, fmt.Errorf("%w for service %s", ErrNoInstance, service)
+	}
+	return instances, nil
+}
@@ -0,0 +1,250 @@
+package extract
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"github.com/antchfx/htmlquery"
+	"github.com/chromedp/chromedp"
+	"github.com/gocolly/colly/v2"
+	"github.com/jeven2016/mylibs/urlutil"
+	"golang.org/x/net/html"
+	"strings"
+)
+
+type Link struct {
+	Title string `json:"title"`
+	URL   string `json:"url"`
+}
+
+type Catalog struct {
+	Name     string `json:"name"`
+	Links    []Link `json:"links"`
+	NextPage string `json:"nextPage,omitempty"`
+}
+
+type Novel struct {
+	Name        string `json:"name"`
+	Author      string `json:"author"`
+	Description string `json:"description"`
+	CoverUrl    string `json:"coverUrl"`
+	Chapters    []Link `json:"chapters"`
+	NextPage    string `json:"nextPage,omitempty"`
+}
+
+type Chapter struct {
+	Title    string `json:"title"`
+	Content  string `json:"content"`
+	NextPage string `json:"nextPage,omitempty"`
+}
+
+// Page is a parsed html page, the links found in it are resolved against its url
+type Page struct {
+	URL    string
+	root   *html.Node
+	source string
+}
+
+// NewPage parses the html of a page
+func NewPage(pageUrl string, body []byte) (*Page, error) {
+	root, err := html.Parse(bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	return &Page{URL: pageUrl, root: root, source: string(body)}, nil
+}
+
+// FromColly parses the page of a colly response
+func FromColly(resp *colly.Response) (*Page, error) {
+	return NewPage(resp.Request.URL.String(), resp.Body)
+}
+
+// FromChrome parses the current page of a chromedp context, e.g. an instance of the ChromePool after
+// navigating to a page which is rendered by scripts
+func FromChrome(ctx context.Context) (*Page, error) {
+	var location, outerHtml string
+	if err := chromedp.Run(ctx, chromedp.Location(&location), chromedp.OuterHTML("html", &outerHtml)); err != nil {
+		return nil, err
+	}
+	return NewPage(location, []byte(outerHtml))
+}
+
+// Extract extracts a catalog or a catalog page, an error is returned if no link is found
+func (r *CatalogRules) Extract(page *Page) (*Catalog, error) {
+	catalog := &Catalog{
+		Name:     r.Name.text(page.root),
+		Links:    r.Links.extract(page, page.root),
+		NextPage: r.NextPage.url(page, page.root),
+	}
+	if len(catalog.Links) == 0 {
+		return nil, fmt.Errorf("%s: no value is extracted from %s", r.Links.Key, page.URL)
+	}
+	return catalog, nil
+}
+
+// Extract extracts a novel, an error is returned if the name isn't found
+func (r *NovelRules) Extract(page *Page) (*Novel, error) {
+	novel := &Novel{
+		Name:        r.Name.text(page.root),
+		Author:      r.Author.text(page.root),
+		Description: r.Description.text(page.root),
+		CoverUrl:    r.CoverUrl.url(page, page.root),
+		Chapters:    r.Chapters.extract(page, page.root),
+		NextPage:    r.NextPage.url(page, page.root),
+	}
+	if novel.Name == "" {
+		return nil, fmt.Errorf("%s: no value is extracted from %s", r.Name.Key, page.URL)
+	}
+	return novel, nil
+}
+
+// Extract extracts a chapter, an error is returned if the content isn't found
+func (r *ChapterRules) Extract(page *Page) (*Chapter, error) {
+	chapter := &Chapter{
+		Title:    r.Title.text(page.root),
+		Content:  r.Content.text(page.root),
+		NextPage: r.NextPage.url(page, page.root),
+	}
+	if chapter.Content == "" {
+		return nil, fmt.Errorf("%s: no value is extracted from %s", r.Content.Key, page.URL)
+	}
+	return chapter, nil
+}
+
+// extract returns the links of the items, the text of an item is the title if the title rule doesn't
+// match and the items without url are skipped
+func (r *ListRule) extract(page *Page, root *html.Node) []Link {
+	if r == nil {
+		return nil
+	}
+	var links []Link
+	for _, item := range r.Items.match(root) {
+		link := Link{Title: r.Title.text(item)}
+		if link.Title == "" {
+			link.Title = r.Items.value(item)
+		}
+		if r.Url != nil {
+			link.URL = r.Url.url(page, item)
+		} else {
+			link.URL = page.resolve(htmlquery.SelectAttr(item, "href"))
+		}
+		if link.URL != "" {
+			links = append(links, link)
+		}
+	}
+	return links
+}
+
+// match returns the elements matched under the node
+func (r *FieldRule) match(node *html.Node) []*html.Node {
+	if r.xpath != nil {
+		return htmlquery.QuerySelectorAll(node, r.xpath)
+	}
+	var nodes []*html.Node
+	for child := node.FirstChild; child != nil; child = child.NextSibling {
+		nodes = append(nodes, r.css.MatchAll(child)...)
+	}
+	return nodes
+}
+
+// text returns the value of the first element, or the values of all the elements by lines
+func (r *FieldRule) text(node *html.Node) string {
+	if r == nil {
+		return ""
+	}
+	var values []string
+	for _, n := range r.match(node) {
+		if value := r.value(n); value != "" {
+			values = append(values, value)
+			if !r.All {
+				break
+			}
+		}
+	}
+	return strings.Join(values, "\n")
+}
+
+// value returns the attribute or the text of an element after the regex is applied
+func (r *FieldRule) value(n *html.Node) string {
+	var value string
+	if r.Attr != "" {
+		value = strings.TrimSpace(htmlquery.SelectAttr(n, r.Attr))
+	} else {
+		value = nodeText(n)
+	}
+	if r.Regex != nil {
+		matches := r.Regex.FindStringSubmatch(value)
+		switch {
+		case len(matches) == 0:
+			return ""
+		case len(matches) > 1:
+			return strings.TrimSpace(matches[1])
+		default:
+			return strings.TrimSpace(matches[0])
+		}
+	}
+	return value
+}
+
+// url returns the value resolved against the page url
+func (r *FieldRule) url(page *Page, node *html.Node) string {
+	if r == nil {
+		return ""
+	}
+	return page.resolve(r.text(node))
+}
+
+func (p *Page) resolve(link string) string {
+	link = strings.TrimSpace(link)
+	if link == "" || strings.HasPrefix(link, "javascript:") {
+		return ""
+	}
+	resolved, err := urlutil.Resolve(p.URL, link)
+	if err != nil {
+		return link
+	}
+	return resolved
+}
+
+// the elements starting a new line of the text
+var blockElements = map[string]bool{
+	"p": true, "div": true, "br": true, "li": true, "dd": true, "dt": true, "tr": true,
+	"h1": true, "h2": true, "h3": true, "h4": true, "h5": true, "h6": true,
+}
+
+// nodeText returns the text of an element, the blocks and line breaks are kept as lines and the spaces
+// of every line are collapsed
+func nodeText(n *html.Node) string {
+	var sb strings.Builder
+	var walk func(n *html.Node)
+	walk = func(n *html.Node) {
+		switch n.Type {
+		case html.TextNode:
+			sb.WriteString(n.Data)
+			return
+		case html.ElementNode:
+			if n.Data == "script" || n.Data == "style" {
+				return
+			}
+			if blockElements[n.Data] {
+				sb.WriteByte('\n')
+			}
+		}
+		for child := n.FirstChild; child != nil; child = child.NextSibling {
+			walk(child)
+		}
+		if n.Type == html.ElementNode && blockElements[n.Data] {
+			sb.WriteByte('\n')
+		}
+	}
+	walk(n)
+
+	var lines []string
+	for _, line := range strings.Split(sb.String(), "\n") {
+		// the full-width spaces are used to indent the paragraphs of the chinese novels
+		if line = strings.Join(strings.Fields(strings.ReplaceAll(line, "　", " ")), " "); line != "" {
+			lines = append(lines, line)
+		}
+	}
+	return strings.Join(lines, "\n")
+}
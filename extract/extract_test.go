@@ -0,0 +1,151 @@
+package extract
+
+import (
+	"errors"
+	"github.com/jeven2016/mylibs/config"
+	"strings"
+	"testing"
+)
+
+const novelHtml = `<html><body>
+<div id="info">
+  <h1>  Go　Novel </h1>
+  <p title="Author: Someone">作者：Someone</p>
+  <img class="cover" src="/covers/1.jpg">
+</div>
+<div id="intro"><p>First line.</p><p>Second   line.</p></div>
+<dl id="list">
+  <dd><a href="1.html"><span>Chapter 1</span> new</a></dd>
+  <dd><a href="/book/1/2.html">Chapter 2</a></dd>
+  <dd><a href="javascript:void(0)">Not a chapter</a></dd>
+</dl>
+<a class="next" href="index_2.html">Next</a>
+</body></html>`
+
+func TestExtractNovel(t *testing.T) {
+	rules, err := ParseRules(&config.CrawlerSetting{
+		Novel: map[string]any{
+			"name":        "#info h1",
+			"author":      map[string]any{"xpath": "//div[@id='info']/p", "regex": "作者[:：](.+)"},
+			"description": map[string]any{"css": "#intro p", "all": true},
+			"coverUrl":    map[string]any{"css": "img.cover", "attr": "src"},
+			"chapters":    map[string]any{"css": "#list dd a", "title": map[string]any{"css": "span"}},
+			"nextPage":    map[string]any{"css": "a.next", "attr": "href"},
+		},
+	}, "crawlerSettings")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	page, err := NewPage("https://example.com/book/1/", []byte(novelHtml))
+	if err != nil {
+		t.Fatal(err)
+	}
+	novel, err := rules.Novel.Extract(page)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if novel.Name != "Go Novel" || novel.Author != "Someone" {
+		t.Errorf("unexpected name or author: %q %q", novel.Name, novel.Author)
+	}
+	if novel.Description != "First line.\nSecond line." {
+		t.Errorf("unexpected description: %q", novel.Description)
+	}
+	if novel.CoverUrl != "https://example.com/covers/1.jpg" || novel.NextPage != "https://example.com/book/1/index_2.html" {
+		t.Errorf("unexpected urls: %q %q", novel.CoverUrl, novel.NextPage)
+	}
+	expected := []Link{
+		{Title: "Chapter 1", URL: "https://example.com/book/1/1.html"},
+		{Title: "Chapter 2", URL: "https://example.com/book/1/2.html"},
+	}
+	if len(novel.Chapters) != len(expected) {
+		t.Fatalf("unexpected chapters: %+v", novel.Chapters)
+	}
+	for i, link := range expected {
+		if novel.Chapters[i] != link {
+			t.Errorf("chapter %d = %+v, expected %+v", i, novel.Chapters[i], link)
+		}
+	}
+
+	page, _ = NewPage("https://example.com/book/2/", []byte("<html><body></body></html>"))
+	if _, err = rules.Novel.Extract(page); err == nil || !strings.Contains(err.Error(), "crawlerSettings.novel.name") {
+		t.Errorf("the missing name should be reported by its key: %v", err)
+	}
+}
+
+func TestExtractCatalogAndChapter(t *testing.T) {
+	rules, err := ParseRules(&config.CrawlerSetting{
+		CatalogPage: map[string]any{"links": "ul.books li > a", "nextPage": map[string]any{"xpath": "//a[text()='Next']/@href"}},
+		Chapter:     map[string]any{"title": "h1", "content": "#content"},
+	}, "crawlerSettings")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rules.Catalog != nil || rules.Novel != nil {
+		t.Fatal("the sections which aren't configured should be nil")
+	}
+
+	page, _ := NewPage("https://example.com/list/1.html", []byte(`<ul class="books">
+<li><a href="/book/1/">Book 1</a></li><li><a href="/book/2/"> Book 2 </a></li></ul>
+<a href="2.html">Next</a>`))
+	catalog, err := rules.CatalogPage.Extract(page)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(catalog.Links) != 2 || catalog.Links[1] != (Link{Title: "Book 2", URL: "https://example.com/book/2/"}) {
+		t.Errorf("unexpected links: %+v", catalog.Links)
+	}
+	if catalog.NextPage != "https://example.com/list/2.html" {
+		t.Errorf("unexpected next page: %q", catalog.NextPage)
+	}
+
+	page, _ = NewPage("https://example.com/book/1/1.html", []byte(`<h1>Chapter 1</h1>
+<div id="content">　　Line one.<br>　　Line two.<script>ads()</script></div>`))
+	chapter, err := rules.Chapter.Extract(page)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if chapter.Title != "Chapter 1" || chapter.Content != "Line one.\nLine two." {
+		t.Errorf("unexpected chapter: %+v", chapter)
+	}
+}
+
+func TestParseSiteRulesErrors(t *testing.T) {
+	cfg := &config.ServerConfig{WebSites: []config.SiteConfig{
+		{Name: "valid", CrawlerSettings: &config.CrawlerSetting{Chapter: map[string]any{"content": "#content"}}},
+		{Name: "invalid", CrawlerSettings: &config.CrawlerSetting{
+			Catalog: map[string]any{"name": "h1"},
+			Novel: map[string]any{
+				"name":    map[string]any{"css": "h1", "xpath": "//h1"},
+				"author":  map[string]any{"css": "p", "regex": "("},
+				"chapter": "#list a",
+			},
+			Chapter: map[string]any{"content": map[string]any{"xpath": "//div["}},
+		}},
+	}}
+
+	_, err := ParseSiteRules(cfg)
+	var validationErr *config.ValidationError
+	if !errors.As(err, &validationErr) {
+		t.Fatalf("a validation error is expected: %v", err)
+	}
+	paths := map[string]string{}
+	for _, field := range validationErr.Fields {
+		paths[field.Path] = field.Rule
+	}
+	expected := map[string]string{
+		"webSites[1].crawlerSettings.catalog.links":         "required",
+		"webSites[1].crawlerSettings.novel.name":            "oneof",
+		"webSites[1].crawlerSettings.novel.author.regex":    "regexp",
+		"webSites[1].crawlerSettings.novel.chapter":         "unknown",
+		"webSites[1].crawlerSettings.chapter.content.xpath": "xpath",
+	}
+	if len(paths) != len(expected) {
+		t.Errorf("unexpected errors: %v", err)
+	}
+	for path, rule := range expected {
+		if paths[path] != rule {
+			t.Errorf("%s should fail on %s: %v", path, rule, err)
+		}
+	}
+}
@@ -0,0 +1,201 @@
+package extract
+
+import (
+	"fmt"
+	"github.com/andybalholm/cascadia"
+	"github.com/antchfx/htmlquery"
+	"github.com/jeven2016/mylibs/config"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// maxPages bounds the generated pages in case a loose ParsePageRegex matches something like an id
+const maxPages = 5000
+
+var (
+	linkSelector = cascadia.MustCompile("a[href]")
+
+	// the texts of the next page links, they are compared in lower case
+	nextPageTexts = map[string]bool{
+		"下一页": true, "下页": true, "下一頁": true, "next": true, "next page": true, "next »": true,
+		"»": true, "›": true, ">": true, ">>": true,
+	}
+)
+
+// Pagination is the pagination of a page. Pages are the urls of all the pages in order if the total is
+// found by ParsePageRegex, and NextPage is the next page link found in the page.
+type Pagination struct {
+	// Current is the number of the page, 1 if the url isn't a numbered page like 123_2.html
+	Current  int
+	Total    int
+	Pages    []string
+	NextPage string
+}
+
+// Follow returns the urls to crawl after the page. The first page fans out to all the other pages if the
+// pages are known, otherwise every page follows its next page link.
+func (p *Pagination) Follow() []string {
+	switch {
+	case len(p.Pages) > 0 && p.Current == 1:
+		return p.Pages[1:]
+	case len(p.Pages) == 0 && p.NextPage != "":
+		return []string{p.NextPage}
+	default:
+		return nil
+	}
+}
+
+// Paginator finds the pagination of the catalog pages or the multi-page chapters by the RegexSettings of
+// a site
+type Paginator struct {
+	pageRegex *regexp.Regexp
+	prefix    string
+	suffix    string
+	numbered  *regexp.Regexp
+}
+
+// NewPaginator creates a Paginator, the next page links are still found if settings is nil. PagePrefix is
+// required by ParsePageRegex to generate the urls of the pages.
+func NewPaginator(settings *config.RegexSettings) (*Paginator, error) {
+	p := &Paginator{}
+	if settings == nil {
+		return p, nil
+	}
+	if settings.ParsePageRegex != "" && settings.PagePrefix == "" {
+		return nil, fmt.Errorf("the pagePrefix is required by the parsePageRegex %s", settings.ParsePageRegex)
+	}
+	if settings.ParsePageRegex != "" {
+		re, err := regexp.Compile(settings.ParsePageRegex)
+		if err != nil {
+			return nil, err
+		}
+		p.pageRegex = re
+	}
+	if settings.PagePrefix != "" {
+		p.prefix = settings.PagePrefix
+		p.suffix = settings.PageSuffix
+		p.numbered = regexp.MustCompile("^(.*)" + regexp.QuoteMeta(p.prefix) + `(\d+)` +
+			regexp.QuoteMeta(p.suffix) + "$")
+	}
+	return p, nil
+}
+
+// Paginate returns the pagination of a page
+func (p *Paginator) Paginate(page *Page) *Pagination {
+	pagination := &Pagination{Current: 1, Total: p.TotalPages(page)}
+	if _, n := p.split(page.URL); n > 0 {
+		pagination.Current = n
+	}
+	if pagination.Total > 0 {
+		if pagination.Current > pagination.Total {
+			pagination.Total = pagination.Current
+		}
+		pagination.Pages = p.PageUrls(page.URL, pagination.Total)
+	}
+	pagination.NextPage = p.NextPage(page)
+	return pagination
+}
+
+// TotalPages returns the largest page number captured by ParsePageRegex, 0 if it isn't set or matched
+func (p *Paginator) TotalPages(page *Page) int {
+	if p.pageRegex == nil {
+		return 0
+	}
+	total := 0
+	for _, match := range p.pageRegex.FindAllStringSubmatch(page.source, -1) {
+		value := match[0]
+		if len(match) > 1 {
+			value = match[1]
+		}
+		if n, err := strconv.Atoi(strings.TrimSpace(value)); err == nil && n > total {
+			total = n
+		}
+	}
+	if total > maxPages {
+		total = maxPages
+	}
+	return total
+}
+
+// PageUrls generates the urls of the pages 1 to total of the paginated url which is any page of them.
+// The first page is the url without the page number, the suffix is kept unless it ends with a slash:
+// /book/1/123_2.html is paginated as 123.html, 123_2.html ... and /list/1/index_2.html as /list/1/,
+// /list/1/index_2.html ...
+func (p *Paginator) PageUrls(pageUrl string, total int) []string {
+	if p.numbered == nil || total <= 0 {
+		return nil
+	}
+	stem, n := p.split(pageUrl)
+	first := pageUrl
+	if n > 0 {
+		first = stem
+		if !strings.HasSuffix(stem, "/") {
+			first += p.suffix
+		}
+	}
+
+	urls := make([]string, 0, total)
+	urls = append(urls, first)
+	for i := 2; i <= total; i++ {
+		urls = append(urls, stem+p.prefix+strconv.Itoa(i)+p.suffix)
+	}
+	return urls
+}
+
+// NextPage returns the next page link of a page by its rel or text. If PagePrefix is set, the link must be
+// a page of the same url, so that the next chapter link isn't taken for the next page of a chapter.
+func (p *Paginator) NextPage(page *Page) string {
+	stem, _ := p.split(page.URL)
+	for _, a := range linkSelector.MatchAll(page.root) {
+		rel := strings.Fields(strings.ToLower(htmlquery.SelectAttr(a, "rel")))
+		if !containsString(rel, "next") && !nextPageTexts[strings.ToLower(nodeText(a))] {
+			continue
+		}
+		link := page.resolve(htmlquery.SelectAttr(a, "href"))
+		if link == "" || trimFragment(link) == trimFragment(page.URL) {
+			continue
+		}
+		if p.numbered != nil {
+			if linkStem, n := p.split(link); n == 0 || linkStem != stem {
+				continue
+			}
+		}
+		return link
+	}
+	return ""
+}
+
+// split returns the url without the page number and the number, the number is 0 if it isn't a numbered
+// page, and the stem is the url without the suffix in this case
+func (p *Paginator) split(pageUrl string) (string, int) {
+	pageUrl = trimFragment(pageUrl)
+	if p.numbered == nil {
+		return pageUrl, 0
+	}
+	if match := p.numbered.FindStringSubmatch(pageUrl); match != nil {
+		if n, err := strconv.Atoi(match[2]); err == nil && n > 0 {
+			return match[1], n
+		}
+	}
+	if p.suffix != "" && !strings.HasSuffix(pageUrl, "/") {
+		return strings.TrimSuffix(pageUrl, p.suffix), 0
+	}
+	return pageUrl, 0
+}
+
+func trimFragment(link string) string {
+	if i := strings.IndexByte(link, '#'); i >= 0 {
+		return link[:i]
+	}
+	return link
+}
+
+func containsString(values []string, s string) bool {
+	for _, v := range values {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,109 @@
+package extract
+
+import (
+	"github.com/jeven2016/mylibs/config"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func loadPage(t *testing.T, pageUrl string, fixture string) *Page {
+	body, err := os.ReadFile(filepath.Join("testdata", fixture))
+	if err != nil {
+		t.Fatal(err)
+	}
+	page, err := NewPage(pageUrl, body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return page
+}
+
+func TestPaginateCatalogPages(t *testing.T) {
+	p, err := NewPaginator(&config.RegexSettings{
+		ParsePageRegex: `index_(\d+)\.html`, PagePrefix: "index_", PageSuffix: ".html",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pagination := p.Paginate(loadPage(t, "https://example.com/list/1/", "catalog_page.html"))
+	if pagination.Current != 1 || pagination.Total != 12 || len(pagination.Pages) != 12 {
+		t.Fatalf("unexpected pagination: %+v", pagination)
+	}
+	if pagination.Pages[0] != "https://example.com/list/1/" ||
+		pagination.Pages[11] != "https://example.com/list/1/index_12.html" {
+		t.Errorf("unexpected pages: %v", pagination.Pages)
+	}
+	if pagination.NextPage != "https://example.com/list/1/index_2.html" {
+		t.Errorf("unexpected next page: %s", pagination.NextPage)
+	}
+	if follow := pagination.Follow(); len(follow) != 11 || follow[0] != pagination.Pages[1] {
+		t.Errorf("the first page should fan out to the other pages: %v", follow)
+	}
+
+	// the same pages are generated from any page, but only the first one fans out
+	second := p.Paginate(loadPage(t, "https://example.com/list/1/index_2.html", "catalog_page.html"))
+	if second.Current != 2 || !reflect.DeepEqual(second.Pages, pagination.Pages) || second.Follow() != nil {
+		t.Errorf("unexpected pagination of the second page: %+v", second)
+	}
+}
+
+func TestPaginateChapterPages(t *testing.T) {
+	settings := &config.RegexSettings{ParsePageRegex: `（\d+/(\d+)）`, PagePrefix: "_", PageSuffix: ".html"}
+	p, err := NewPaginator(settings)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pagination := p.Paginate(loadPage(t, "https://example.com/book/1/123.html", "chapter_page.html"))
+	expected := []string{
+		"https://example.com/book/1/123.html",
+		"https://example.com/book/1/123_2.html",
+		"https://example.com/book/1/123_3.html",
+	}
+	if pagination.Total != 3 || !reflect.DeepEqual(pagination.Pages, expected) {
+		t.Errorf("unexpected pages: %+v", pagination)
+	}
+	// the next chapter link isn't the next page
+	if pagination.NextPage != expected[1] {
+		t.Errorf("unexpected next page: %s", pagination.NextPage)
+	}
+
+	last := p.Paginate(loadPage(t, "https://example.com/book/1/123_3.html", "chapter_last_page.html"))
+	if last.Current != 3 || last.NextPage != "" || last.Follow() != nil {
+		t.Errorf("unexpected pagination of the last page: %+v", last)
+	}
+
+	// the pages are followed one by one without the total
+	p, _ = NewPaginator(&config.RegexSettings{PagePrefix: "_", PageSuffix: ".html"})
+	pagination = p.Paginate(loadPage(t, "https://example.com/book/1/123.html", "chapter_page.html"))
+	if pagination.Total != 0 || !reflect.DeepEqual(pagination.Follow(), expected[1:2]) {
+		t.Errorf("unexpected pagination without the total: %+v", pagination)
+	}
+}
+
+func TestPaginateNextPageLink(t *testing.T) {
+	p, err := NewPaginator(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pagination := p.Paginate(loadPage(t, "https://example.com/latest?page=2", "next_page.html"))
+	if pagination.Total != 0 || !reflect.DeepEqual(pagination.Follow(), []string{"https://example.com/latest?page=3"}) {
+		t.Errorf("unexpected pagination: %+v", pagination)
+	}
+
+	if _, err = NewPaginator(&config.RegexSettings{ParsePageRegex: "(", PagePrefix: "_"}); err == nil {
+		t.Error("the invalid regex should be rejected")
+	}
+	if _, err = NewPaginator(&config.RegexSettings{ParsePageRegex: `(\d+)页`}); err == nil {
+		t.Error("the parsePageRegex without a pagePrefix should be rejected")
+	}
+
+	// the pages are unknown without a pagePrefix, the next page link is followed then
+	pagination = &Pagination{Current: 1, Total: 3, NextPage: "https://example.com/latest?page=2"}
+	if !reflect.DeepEqual(pagination.Follow(), []string{pagination.NextPage}) {
+		t.Errorf("unexpected links to follow: %v", pagination.Follow())
+	}
+}
@@ -0,0 +1,289 @@
+package extract
+
+import (
+	"fmt"
+	"github.com/andybalholm/cascadia"
+	"github.com/antchfx/xpath"
+	"github.com/jeven2016/mylibs/config"
+	"regexp"
+	"sort"
+)
+
+// Rules are the extraction rules of a site read from its CrawlerSettings, a section is nil if it isn't
+// configured. A field is a css selector, or a map like:
+//
+//	author:
+//	  css: div.info p          # or xpath: //div[@class='info']/p, exactly one of them is required
+//	  attr: title              # the text of the element is taken if it's empty
+//	  regex: "作者[:：](.+)"    # the first group, or the whole match if there's no group
+//	  all: true                # join the values of all the elements by lines instead of the first one
+//
+// A list, e.g. the chapters of a novel, selects the items by css or xpath and the title and url fields
+// relative to an item, the text and the href of the item are used if they are missing:
+//
+//	chapters:
+//	  css: "#list dd a"
+//	  title: {css: span}
+type Rules struct {
+	Catalog     *CatalogRules
+	CatalogPage *CatalogRules
+	Novel       *NovelRules
+	Chapter     *ChapterRules
+}
+
+// CatalogRules extract a Catalog, the links are the catalog pages of a catalog or the novels of a catalog page
+type CatalogRules struct {
+	Name     *FieldRule
+	Links    *ListRule
+	NextPage *FieldRule
+}
+
+type NovelRules struct {
+	Name        *FieldRule
+	Author      *FieldRule
+	Description *FieldRule
+	CoverUrl    *FieldRule
+	Chapters    *ListRule
+	NextPage    *FieldRule
+}
+
+type ChapterRules struct {
+	Title    *FieldRule
+	Content  *FieldRule
+	NextPage *FieldRule
+}
+
+// FieldRule extracts a value from the elements matched by a css selector or an xpath expression
+type FieldRule struct {
+	// Key is the config key of the rule, e.g. webSites[0].crawlerSettings.novel.author
+	Key   string
+	css   cascadia.Selector
+	xpath *xpath.Expr
+	Attr  string
+	Regex *regexp.Regexp
+	All   bool
+}
+
+// ListRule extracts the links of the items matched by a css selector or an xpath expression
+type ListRule struct {
+	Key   string
+	Items *FieldRule
+	Title *FieldRule
+	Url   *FieldRule
+}
+
+// ruleParser collects every invalid key of a section instead of stopping at the first one
+type ruleParser struct {
+	errs []config.FieldError
+}
+
+func (p *ruleParser) fail(key string, rule string, value any) {
+	p.errs = append(p.errs, config.FieldError{Path: key, Rule: rule, Value: value})
+}
+
+// ParseRules reads the rules of the crawler settings of a site, prefix is the config key of the settings
+// which is used by the errors. All the invalid keys are returned in a *config.ValidationError.
+func ParseRules(setting *config.CrawlerSetting, prefix string) (*Rules, error) {
+	rules := &Rules{}
+	if setting == nil {
+		return rules, nil
+	}
+
+	p := &ruleParser{}
+	rules.Catalog = p.catalog(setting.Catalog, prefix+".catalog")
+	rules.CatalogPage = p.catalog(setting.CatalogPage, prefix+".catalogPage")
+	rules.Novel = p.novel(setting.Novel, prefix+".novel")
+	rules.Chapter = p.chapter(setting.Chapter, prefix+".chapter")
+	if len(p.errs) > 0 {
+		return nil, &config.ValidationError{Fields: p.errs}
+	}
+	return rules, nil
+}
+
+// ParseSiteRules reads the rules of all the web sites of the config by site name
+func ParseSiteRules(cfg *config.ServerConfig) (map[string]*Rules, error) {
+	rules := make(map[string]*Rules, len(cfg.WebSites))
+	validationErr := &config.ValidationError{}
+	for i, site := range cfg.WebSites {
+		siteRules, err := ParseRules(site.CrawlerSettings, fmt.Sprintf("webSites[%d].crawlerSettings", i))
+		if err != nil {
+			if ve, ok := err.(*config.ValidationError); ok {
+				validationErr.Fields = append(validationErr.Fields, ve.Fields...)
+				continue
+			}
+			return nil, err
+		}
+		rules[site.Name] = siteRules
+	}
+	if len(validationErr.Fields) > 0 {
+		return nil, validationErr
+	}
+	return rules, nil
+}
+
+func (p *ruleParser) catalog(section map[string]any, key string) *CatalogRules {
+	if section == nil {
+		return nil
+	}
+	p.checkKeys(section, key, "name", "links", "nextPage")
+	rules := &CatalogRules{
+		Name:     p.field(section, key, "name", false),
+		Links:    p.list(section, key, "links", true),
+		NextPage: p.field(section, key, "nextPage", false),
+	}
+	return rules
+}
+
+func (p *ruleParser) novel(section map[string]any, key string) *NovelRules {
+	if section == nil {
+		return nil
+	}
+	p.checkKeys(section, key, "name", "author", "description", "coverUrl", "chapters", "nextPage")
+	return &NovelRules{
+		Name:        p.field(section, key, "name", true),
+		Author:      p.field(section, key, "author", false),
+		Description: p.field(section, key, "description", false),
+		CoverUrl:    p.field(section, key, "coverUrl", false),
+		Chapters:    p.list(section, key, "chapters", false),
+		NextPage:    p.field(section, key, "nextPage", false),
+	}
+}
+
+func (p *ruleParser) chapter(section map[string]any, key string) *ChapterRules {
+	if section == nil {
+		return nil
+	}
+	p.checkKeys(section, key, "title", "content", "nextPage")
+	return &ChapterRules{
+		Title:    p.field(section, key, "title", false),
+		Content:  p.field(section, key, "content", true),
+		NextPage: p.field(section, key, "nextPage", false),
+	}
+}
+
+// checkKeys reports the unknown keys, e.g. a typo of a field name
+func (p *ruleParser) checkKeys(section map[string]any, key string, known ...string) {
+	allowed := map[string]bool{}
+	for _, k := range known {
+		allowed[k] = true
+	}
+	var unknown []string
+	for k := range section {
+		if !allowed[k] {
+			unknown = append(unknown, k)
+		}
+	}
+	sort.Strings(unknown)
+	for _, k := range unknown {
+		p.fail(key+"."+k, "unknown", section[k])
+	}
+}
+
+func (p *ruleParser) field(section map[string]any, key string, name string, required bool) *FieldRule {
+	value, ok := section[name]
+	if !ok || value == nil {
+		if required {
+			p.fail(key+"."+name, "required", nil)
+		}
+		return nil
+	}
+	return p.parseField(value, key+"."+name)
+}
+
+func (p *ruleParser) parseField(value any, key string) *FieldRule {
+	switch v := value.(type) {
+	case string:
+		return p.selector(&FieldRule{Key: key}, key+".css", v, "")
+	case map[string]any:
+		p.checkKeys(v, key, "css", "xpath", "attr", "regex", "all")
+		rule := &FieldRule{Key: key}
+		css, hasCss := p.str(v, key, "css")
+		xpathExpr, hasXpath := p.str(v, key, "xpath")
+		switch {
+		case hasCss == hasXpath:
+			// exactly one of them is required
+			p.errs = append(p.errs, config.FieldError{Path: key, Rule: "oneof", Param: "css xpath", Value: v})
+			return nil
+		case hasCss:
+			p.selector(rule, key+".css", css, "")
+		default:
+			p.selector(rule, key+".xpath", "", xpathExpr)
+		}
+
+		rule.Attr, _ = p.str(v, key, "attr")
+		if pattern, ok := p.str(v, key, "regex"); ok {
+			re, err := regexp.Compile(pattern)
+			if err != nil {
+				p.fail(key+".regex", "regexp", pattern)
+			}
+			rule.Regex = re
+		}
+		if all, ok := v["all"]; ok {
+			if rule.All, ok = all.(bool); !ok {
+				p.fail(key+".all", "bool", all)
+			}
+		}
+		return rule
+	default:
+		p.fail(key, "selector", value)
+		return nil
+	}
+}
+
+func (p *ruleParser) selector(rule *FieldRule, key string, css string, xpathExpr string) *FieldRule {
+	var err error
+	if xpathExpr != "" {
+		if rule.xpath, err = xpath.Compile(xpathExpr); err != nil {
+			p.fail(key, "xpath", xpathExpr)
+		}
+		return rule
+	}
+	if rule.css, err = cascadia.Compile(css); err != nil || css == "" {
+		p.fail(key, "css", css)
+	}
+	return rule
+}
+
+// str returns a string value of a rule, it reports whether the key is set
+func (p *ruleParser) str(section map[string]any, key string, name string) (string, bool) {
+	value, ok := section[name]
+	if !ok {
+		return "", false
+	}
+	s, isString := value.(string)
+	if !isString {
+		p.fail(key+"."+name, "string", value)
+	}
+	return s, true
+}
+
+func (p *ruleParser) list(section map[string]any, key string, name string, required bool) *ListRule {
+	value, ok := section[name]
+	if !ok || value == nil {
+		if required {
+			p.fail(key+"."+name, "required", nil)
+		}
+		return nil
+	}
+
+	listKey := key + "." + name
+	rule := &ListRule{Key: listKey}
+	v, isMap := value.(map[string]any)
+	if !isMap {
+		// a selector of the items, their text and href are taken
+		rule.Items = p.parseField(value, listKey)
+		return rule
+	}
+
+	p.checkKeys(v, listKey, "css", "xpath", "title", "url")
+	items := map[string]any{}
+	for _, k := range []string{"css", "xpath"} {
+		if selector, ok := v[k]; ok {
+			items[k] = selector
+		}
+	}
+	rule.Items = p.parseField(items, listKey)
+	rule.Title = p.field(v, listKey, "title", false)
+	rule.Url = p.field(v, listKey, "url", false)
+	return rule
+}
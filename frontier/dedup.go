@@ -0,0 +1,136 @@
+package frontier
+
+import (
+	"context"
+	"github.com/redis/go-redis/v9"
+	"hash/fnv"
+	"math"
+	"strconv"
+)
+
+// Deduper remembers the urls seen by a frontier
+type Deduper interface {
+	// Add marks the urls as seen and reports for each of them whether it's seen for the first time
+	Add(ctx context.Context, urls ...string) ([]bool, error)
+}
+
+// scriptDeduper marks the urls as seen within the enqueue script of a frontier, so that a url is never taken
+// as seen without being queued
+type scriptDeduper interface {
+	Deduper
+	// marking returns the dedup mode of the script and the arguments marking the url as seen
+	marking(u string) (string, []any)
+}
+
+type setDeduper struct {
+	client *redis.Client
+	key    string
+}
+
+// NewSetDeduper keeps the seen urls in a redis set, it's exact but takes memory for every url
+func NewSetDeduper(client *redis.Client, key string) Deduper {
+	return newSetDeduper(client, key)
+}
+
+func newSetDeduper(client *redis.Client, key string) *setDeduper {
+	return &setDeduper{client: client, key: key}
+}
+
+func (d *setDeduper) marking(string) (string, []any) {
+	return "set", nil
+}
+
+func (d *setDeduper) Add(ctx context.Context, urls ...string) ([]bool, error) {
+	pipe := d.client.Pipeline()
+	cmds := make([]*redis.IntCmd, len(urls))
+	for i, u := range urls {
+		cmds[i] = pipe.SAdd(ctx, d.key, u)
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		return nil, err
+	}
+	added := make([]bool, len(urls))
+	for i, cmd := range cmds {
+		added[i] = cmd.Val() == 1
+	}
+	return added, nil
+}
+
+// bloomAddScript sets the bits of an item and returns 1 if any of them wasn't set
+var bloomAddScript = redis.NewScript(`
+local added = 0
+for i = 1, #ARGV do
+	if redis.call("setbit", KEYS[1], ARGV[i], 1) == 0 then
+		added = 1
+	end
+end
+return added`)
+
+type bloomDeduper struct {
+	client *redis.Client
+	key    string
+	bits   uint64
+	hashes int
+}
+
+// NewBloomDeduper keeps the seen urls in a bloom filter stored as a redis bitmap, so it's shared by the
+// replicas and survives the restarts. A new url is taken as seen with the false positive rate once the
+// capacity is reached, such a url is never crawled.
+func NewBloomDeduper(client *redis.Client, key string, capacity int, falsePositiveRate float64) Deduper {
+	return newBloomDeduper(client, key, capacity, falsePositiveRate)
+}
+
+func newBloomDeduper(client *redis.Client, key string, capacity int, falsePositiveRate float64) *bloomDeduper {
+	bits, hashes := bloomSize(capacity, falsePositiveRate)
+	return &bloomDeduper{client: client, key: key, bits: bits, hashes: hashes}
+}
+
+// bloomSize returns the optimal number of bits and hash functions, the bitmap of redis is limited to 2^32 bits
+func bloomSize(capacity int, falsePositiveRate float64) (uint64, int) {
+	bits := math.Ceil(-float64(capacity) * math.Log(falsePositiveRate) / (math.Ln2 * math.Ln2))
+	bits = math.Min(math.Max(bits, 64), math.MaxUint32)
+	hashes := int(math.Round(bits / float64(capacity) * math.Ln2))
+	if hashes < 1 {
+		hashes = 1
+	}
+	return uint64(bits), hashes
+}
+
+// positions returns the bits of an item by double hashing
+func (d *bloomDeduper) positions(item string) []any {
+	h := fnv.New64a()
+	_, _ = h.Write([]byte(item))
+	h1 := h.Sum64()
+	h.Reset()
+	_, _ = h.Write([]byte{0xff})
+	_, _ = h.Write([]byte(item))
+	h2 := h.Sum64() | 1
+
+	positions := make([]any, d.hashes)
+	for i := 0; i < d.hashes; i++ {
+		positions[i] = strconv.FormatUint((h1+uint64(i)*h2)%d.bits, 10)
+	}
+	return positions
+}
+
+func (d *bloomDeduper) marking(u string) (string, []any) {
+	return "bloom", d.positions(u)
+}
+
+func (d *bloomDeduper) Add(ctx context.Context, urls ...string) ([]bool, error) {
+	pipe := d.client.Pipeline()
+	cmds := make([]*redis.Cmd, len(urls))
+	for i, u := range urls {
+		// the script can't be loaded on demand within a pipeline, so it's sent as a whole
+		cmds[i] = bloomAddScript.Eval(ctx, pipe, []string{d.key}, d.positions(u)...)
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		return nil, err
+	}
+	added := make([]bool, len(urls))
+	for i, cmd := range cmds {
+		n, _ := cmd.Int()
+		added[i] = n == 1
+	}
+	return added, nil
+}
@@ -0,0 +1,404 @@
+package frontier
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/jeven2016/mylibs/config"
+	"github.com/jeven2016/mylibs/urlutil"
+	"github.com/redis/go-redis/v9"
+	"go.uber.org/zap"
+	"regexp"
+	"time"
+)
+
+// the range of the priorities, a url with a higher priority is leased first and the urls of the same
+// priority are leased in the order they're enqueued
+const (
+	MinPriority = -100
+	MaxPriority = 100
+)
+
+// ErrLeaseLost is returned if a lease has expired and the url is given to another worker
+var ErrLeaseLost = errors.New("the lease of the url is lost")
+
+// Entry is a url of the frontier
+type Entry struct {
+	URL        string    `json:"url"`
+	Site       string    `json:"site"`
+	Depth      int       `json:"depth"`
+	Priority   int       `json:"priority"`
+	Attempts   int       `json:"attempts"`
+	EnqueuedAt time.Time `json:"enqueuedAt"`
+	LastError  string    `json:"lastError,omitempty"`
+}
+
+// Lease is an entry given to a worker until the deadline, it should be completed or failed before that
+type Lease struct {
+	Entry
+	Deadline time.Time
+}
+
+// Stats is the number of the urls in each state, the queued ones are counted by site
+type Stats struct {
+	Queued  map[string]int64 `json:"queued"`
+	Leased  int64            `json:"leased"`
+	Fetched int64            `json:"fetched"`
+	Failed  int64            `json:"failed"`
+}
+
+// leaseScript moves the first urls of a queue to the leases and returns their entries
+var leaseScript = redis.NewScript(`
+local urls = redis.call("zrange", KEYS[1], 0, tonumber(ARGV[1]) - 1)
+if #urls == 0 then
+	return {}
+end
+redis.call("zrem", KEYS[1], unpack(urls))
+for _, u in ipairs(urls) do
+	redis.call("zadd", KEYS[2], ARGV[2], u)
+end
+return redis.call("hmget", KEYS[3], unpack(urls))`)
+
+// enqueueScript marks a url as seen and queues its entry at once, 1 is returned if it wasn't seen before.
+// The url is marked by sadd in the set mode, or by the bits of ARGV[6..] in the bloom mode.
+var enqueueScript = redis.NewScript(`
+local added = 0
+if ARGV[1] == "bloom" then
+	for i = 6, #ARGV do
+		if redis.call("setbit", KEYS[1], ARGV[i], 1) == 0 then
+			added = 1
+		end
+	end
+else
+	added = redis.call("sadd", KEYS[1], ARGV[2])
+end
+if added == 0 then
+	return 0
+end
+redis.call("hset", KEYS[2], ARGV[2], ARGV[3])
+redis.call("zadd", KEYS[3], ARGV[4], ARGV[2])
+redis.call("sadd", KEYS[4], ARGV[5])
+return 1`)
+
+// Frontier holds the urls to crawl in redis, so a crawl is resumed after a restart and shared by the
+// replicas. The keys of a frontier share a hash tag so that the scripts work with redis cluster:
+//
+//	frontier:{name}:queue:<site>  sorted set of the queued urls by priority
+//	frontier:{name}:entries       hash of the entries which are queued or leased
+//	frontier:{name}:leases        sorted set of the leased urls by deadline
+//	frontier:{name}:fetched       hash of the fetched urls and the time
+//	frontier:{name}:failed        hash of the failed urls and their entries
+//	frontier:{name}:sites         set of the sites
+//	frontier:{name}:seen          the seen urls, a set or a bloom filter
+type Frontier struct {
+	client   *redis.Client
+	prefix   string
+	setting  *config.FrontierSetting
+	deduper  scriptDeduper
+	excluded map[string]struct{}
+	patterns []*regexp.Regexp
+}
+
+// NewFrontier creates a frontier by the Frontier setting of the crawler settings, the urls of
+// excludedNovelUrls are excluded as well
+func NewFrontier(client *redis.Client, name string, settings *config.CrawlerSettings) (*Frontier, error) {
+	if client == nil || name == "" {
+		return nil, errors.New("the redis client and the name of the frontier are required")
+	}
+
+	setting := &config.FrontierSetting{}
+	var excludedUrls []string
+	if settings != nil {
+		if settings.Frontier != nil {
+			copied := *settings.Frontier
+			setting = &copied
+		}
+		excludedUrls = settings.EcludedNovelUrls
+	}
+	if err := config.SetDefaults(setting); err != nil {
+		return nil, err
+	}
+
+	f := &Frontier{
+		client:   client,
+		prefix:   "frontier:{" + name + "}:",
+		setting:  setting,
+		excluded: map[string]struct{}{},
+	}
+	for _, pattern := range setting.ExcludedUrlPatterns {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid excluded url pattern %s: %w", pattern, err)
+		}
+		f.patterns = append(f.patterns, re)
+	}
+	for _, u := range excludedUrls {
+		if normalized, err := f.Normalize(u); err == nil {
+			f.excluded[normalized] = struct{}{}
+		}
+	}
+
+	if setting.Dedup == "bloom" {
+		f.deduper = newBloomDeduper(client, f.key("seen"), setting.BloomCapacity, setting.BloomFalsePositiveRate)
+	} else {
+		f.deduper = newSetDeduper(client, f.key("seen"))
+	}
+	return f, nil
+}
+
+// Normalize returns the canonical url used to dedup, the query params are filtered by QueryParams and
+// TrackingParams of the setting
+func (f *Frontier) Normalize(rawUrl string) (string, error) {
+	return urlutil.Canonicalize(rawUrl, urlutil.WithQueryWhitelist(f.setting.QueryParams...),
+		urlutil.WithTrackingParams(f.setting.TrackingParams...))
+}
+
+func (f *Frontier) key(name string) string {
+	return f.prefix + name
+}
+
+func (f *Frontier) queueKey(site string) string {
+	return f.prefix + "queue:" + site
+}
+
+// score orders the queue by priority first and then by the time of enqueuing
+func score(priority int, enqueuedAt time.Time) float64 {
+	if priority < MinPriority {
+		priority = MinPriority
+	}
+	if priority > MaxPriority {
+		priority = MaxPriority
+	}
+	return float64(-priority)*1e13 + float64(enqueuedAt.UnixMilli())
+}
+
+// accept normalizes the url of an entry and reports whether it can be enqueued
+func (f *Frontier) accept(entry *Entry) bool {
+	normalized, err := f.Normalize(entry.URL)
+	if err != nil {
+		zap.L().Debug("the url is ignored by the frontier", zap.String("url", entry.URL), zap.Error(err))
+		return false
+	}
+	if f.setting.MaxDepth > 0 && entry.Depth > f.setting.MaxDepth {
+		return false
+	}
+	if _, ok := f.excluded[normalized]; ok {
+		return false
+	}
+	for _, re := range f.patterns {
+		if re.MatchString(normalized) {
+			return false
+		}
+	}
+	entry.URL = normalized
+	return true
+}
+
+// Enqueue adds the urls which aren't seen before, excluded or too deep, the enqueued entries are returned.
+// A url is marked as seen by the same script queuing it, so it's never lost if the enqueuing fails.
+func (f *Frontier) Enqueue(ctx context.Context, entries ...Entry) ([]Entry, error) {
+	var accepted []Entry
+	now := time.Now()
+	for _, entry := range entries {
+		if !f.accept(&entry) {
+			continue
+		}
+		entry.Attempts = 0
+		entry.EnqueuedAt = now
+		accepted = append(accepted, entry)
+	}
+	if len(accepted) == 0 {
+		return nil, nil
+	}
+
+	pipe := f.client.TxPipeline()
+	cmds := make([]*redis.Cmd, len(accepted))
+	for i, entry := range accepted {
+		data, err := json.Marshal(entry)
+		if err != nil {
+			return nil, err
+		}
+		mode, marks := f.deduper.marking(entry.URL)
+		args := append([]any{mode, entry.URL, data, score(entry.Priority, entry.EnqueuedAt), entry.Site}, marks...)
+		// the script can't be loaded on demand within a pipeline, so it's sent as a whole
+		cmds[i] = enqueueScript.Eval(ctx, pipe, []string{f.key("seen"), f.key("entries"),
+			f.queueKey(entry.Site), f.key("sites")}, args...)
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		return nil, err
+	}
+
+	var enqueued []Entry
+	for i, cmd := range cmds {
+		if n, _ := cmd.Int(); n == 1 {
+			enqueued = append(enqueued, accepted[i])
+		}
+	}
+	return enqueued, nil
+}
+
+// Lease gives at most count urls of the site to a worker for the lease duration, the expired leases are
+// reclaimed first. The count must be positive.
+func (f *Frontier) Lease(ctx context.Context, site string, count int) ([]*Lease, error) {
+	if count <= 0 {
+		return nil, fmt.Errorf("the count of the urls to lease must be positive: %d", count)
+	}
+	if _, err := f.Reclaim(ctx); err != nil {
+		return nil, err
+	}
+
+	deadline := time.Now().Add(time.Duration(f.setting.LeaseSeconds) * time.Second)
+	result, err := leaseScript.Run(ctx, f.client, []string{f.queueKey(site), f.key("leases"), f.key("entries")},
+		count, deadline.UnixMilli()).Slice()
+	if err != nil && !errors.Is(err, redis.Nil) {
+		return nil, err
+	}
+
+	leases := make([]*Lease, 0, len(result))
+	for _, item := range result {
+		data, ok := item.(string)
+		if !ok {
+			continue
+		}
+		lease := &Lease{Deadline: deadline}
+		if err = json.Unmarshal([]byte(data), &lease.Entry); err != nil {
+			return nil, err
+		}
+		leases = append(leases, lease)
+	}
+	return leases, nil
+}
+
+// Reclaim puts the urls whose lease has expired back to their queues, e.g. the worker crashed
+func (f *Frontier) Reclaim(ctx context.Context) (int, error) {
+	expired, err := f.client.ZRangeByScore(ctx, f.key("leases"), &redis.ZRangeBy{
+		Min: "-inf",
+		Max: fmt.Sprint(time.Now().UnixMilli()),
+	}).Result()
+	if err != nil {
+		return 0, err
+	}
+
+	reclaimed := 0
+	for _, u := range expired {
+		// the lease is reclaimed by the one removing it
+		removed, err := f.client.ZRem(ctx, f.key("leases"), u).Result()
+		if err != nil {
+			return reclaimed, err
+		}
+		if removed == 0 {
+			continue
+		}
+		entry, err := f.entry(ctx, u)
+		if err != nil {
+			return reclaimed, err
+		}
+		if entry == nil {
+			continue
+		}
+		if err = f.client.ZAdd(ctx, f.queueKey(entry.Site), redis.Z{
+			Score:  score(entry.Priority, entry.EnqueuedAt),
+			Member: entry.URL,
+		}).Err(); err != nil {
+			return reclaimed, err
+		}
+		reclaimed++
+	}
+	if reclaimed > 0 {
+		zap.L().Info("the expired leases are reclaimed", zap.String("frontier", f.prefix), zap.Int("count", reclaimed))
+	}
+	return reclaimed, nil
+}
+
+func (f *Frontier) entry(ctx context.Context, u string) (*Entry, error) {
+	data, err := f.client.HGet(ctx, f.key("entries"), u).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	entry := &Entry{}
+	if err = json.Unmarshal(data, entry); err != nil {
+		return nil, err
+	}
+	return entry, nil
+}
+
+// Complete marks the url of a lease as fetched
+func (f *Frontier) Complete(ctx context.Context, lease *Lease) error {
+	pipe := f.client.TxPipeline()
+	pipe.ZRem(ctx, f.key("leases"), lease.URL)
+	// the url may be reclaimed if the lease has expired meanwhile
+	pipe.ZRem(ctx, f.queueKey(lease.Site), lease.URL)
+	pipe.HDel(ctx, f.key("entries"), lease.URL)
+	pipe.HSet(ctx, f.key("fetched"), lease.URL, time.Now().Format(time.RFC3339))
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+// Fail puts the url of a lease back to its queue until it fails MaxAttempts times, then it's marked as
+// failed. It reports whether the url is retried.
+func (f *Frontier) Fail(ctx context.Context, lease *Lease, cause error) (bool, error) {
+	removed, err := f.client.ZRem(ctx, f.key("leases"), lease.URL).Result()
+	if err != nil {
+		return false, err
+	}
+	if removed == 0 {
+		return false, ErrLeaseLost
+	}
+
+	entry := lease.Entry
+	entry.Attempts++
+	if cause != nil {
+		entry.LastError = cause.Error()
+	}
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return false, err
+	}
+
+	pipe := f.client.TxPipeline()
+	retried := entry.Attempts < f.setting.MaxAttempts
+	if retried {
+		pipe.HSet(ctx, f.key("entries"), entry.URL, data)
+		pipe.ZAdd(ctx, f.queueKey(entry.Site), redis.Z{Score: score(entry.Priority, entry.EnqueuedAt), Member: entry.URL})
+	} else {
+		pipe.HDel(ctx, f.key("entries"), entry.URL)
+		pipe.HSet(ctx, f.key("failed"), entry.URL, data)
+	}
+	_, err = pipe.Exec(ctx)
+	return retried, err
+}
+
+// Stats returns the number of the urls in each state
+func (f *Frontier) Stats(ctx context.Context) (*Stats, error) {
+	sites, err := f.client.SMembers(ctx, f.key("sites")).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	pipe := f.client.Pipeline()
+	queued := make(map[string]*redis.IntCmd, len(sites))
+	for _, site := range sites {
+		queued[site] = pipe.ZCard(ctx, f.queueKey(site))
+	}
+	leased := pipe.ZCard(ctx, f.key("leases"))
+	fetched := pipe.HLen(ctx, f.key("fetched"))
+	failed := pipe.HLen(ctx, f.key("failed"))
+	if _, err = pipe.Exec(ctx); err != nil {
+		return nil, err
+	}
+
+	stats := &Stats{
+		Queued:  make(map[string]int64, len(sites)),
+		Leased:  leased.Val(),
+		Fetched: fetched.Val(),
+		Failed:  failed.Val(),
+	}
+	for site, cmd := range queued {
+		stats.Queued[site] = cmd.Val()
+	}
+	return stats, nil
+}
@@ -0,0 +1,303 @@
+package frontier
+
+import (
+	"context"
+	"errors"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/jeven2016/mylibs/config"
+	"github.com/redis/go-redis/v9"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestFrontierNormalize(t *testing.T) {
+	client := redis.NewClient(&redis.Options{Addr: "localhost:6379"})
+	defer client.Close()
+	f, err := NewFrontier(client, "novels", &config.CrawlerSettings{
+		Frontier: &config.FrontierSetting{QueryParams: []string{"page"}, TrackingParams: []string{"from"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := map[string]string{
+		"HTTP://Example.COM:80":                     "http://example.com/",
+		"https://example.com/a.html#chapter":        "https://example.com/a.html",
+		" https://example.com/list?sort=1&page=2 ":  "https://example.com/list?page=2",
+		"https://example.com/b/../a.html?from=home": "https://example.com/a.html",
+	}
+	for raw, expected := range cases {
+		if normalized, err := f.Normalize(raw); err != nil || normalized != expected {
+			t.Errorf("Normalize(%q) = %q, %v, expected %q", raw, normalized, err, expected)
+		}
+	}
+	for _, invalid := range []string{"/relative/path", "ftp://example.com/file", "mailto:someone@example.com"} {
+		if _, err := f.Normalize(invalid); err == nil {
+			t.Errorf("Normalize(%q) should fail", invalid)
+		}
+	}
+}
+
+func TestFrontierAccept(t *testing.T) {
+	client := redis.NewClient(&redis.Options{Addr: "localhost:6379"})
+	defer client.Close()
+
+	f, err := NewFrontier(client, "novels", &config.CrawlerSettings{
+		EcludedNovelUrls: []string{"https://example.com/novel/1.html"},
+		Frontier: &config.FrontierSetting{
+			MaxDepth:            2,
+			ExcludedUrlPatterns: []string{`/login`, `\.(zip|rar)$`},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f.setting.LeaseSeconds != 300 || f.setting.MaxAttempts != 3 {
+		t.Fatalf("the defaults aren't set: %+v", f.setting)
+	}
+
+	cases := []struct {
+		entry    Entry
+		accepted bool
+	}{
+		{Entry{URL: "https://EXAMPLE.com/novel/2.html#top", Depth: 1}, true},
+		{Entry{URL: "https://example.com/novel/3.html", Depth: 3}, false},
+		{Entry{URL: "https://example.com/novel/1.html#top"}, false},
+		{Entry{URL: "https://example.com/login?next=/"}, false},
+		{Entry{URL: "https://example.com/download/all.zip"}, false},
+		{Entry{URL: "../relative.html"}, false},
+	}
+	for _, c := range cases {
+		entry := c.entry
+		if accepted := f.accept(&entry); accepted != c.accepted {
+			t.Errorf("accept(%s) = %v, expected %v", c.entry.URL, accepted, c.accepted)
+		}
+	}
+
+	if _, err = NewFrontier(client, "novels", &config.CrawlerSettings{
+		Frontier: &config.FrontierSetting{ExcludedUrlPatterns: []string{"("}},
+	}); err == nil {
+		t.Fatal("the invalid pattern should be rejected")
+	}
+}
+
+func TestScoreOrdersByPriorityThenTime(t *testing.T) {
+	now := time.Now()
+	if score(10, now.Add(time.Hour)) >= score(0, now) {
+		t.Fatal("the url with a higher priority should be leased first")
+	}
+	if score(0, now) >= score(0, now.Add(time.Millisecond)) {
+		t.Fatal("the urls of the same priority should be leased in order")
+	}
+	if score(1000, now) != score(MaxPriority, now) {
+		t.Fatal("the priority should be clamped")
+	}
+}
+
+func TestBloomPositions(t *testing.T) {
+	bits, hashes := bloomSize(1000000, 0.001)
+	// about 14.4 bits per item and 10 hash functions
+	if bits < 14000000 || bits > 15000000 || hashes != 10 {
+		t.Fatalf("unexpected bloom size: %d bits, %d hashes", bits, hashes)
+	}
+
+	d := &bloomDeduper{bits: bits, hashes: hashes}
+	a := d.positions("https://example.com/1.html")
+	b := d.positions("https://example.com/2.html")
+	if len(a) != hashes || a[0] == b[0] {
+		t.Fatalf("unexpected positions: %v %v", a, b)
+	}
+	if again := d.positions("https://example.com/1.html"); again[3] != a[3] {
+		t.Fatal("the positions should be stable")
+	}
+}
+
+func newTestFrontier(t *testing.T, server *miniredis.Miniredis, setting *config.FrontierSetting) *Frontier {
+	client := redis.NewClient(&redis.Options{Addr: server.Addr()})
+	t.Cleanup(func() { _ = client.Close() })
+	f, err := NewFrontier(client, "novels", &config.CrawlerSettings{Frontier: setting})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return f
+}
+
+func leasedUrls(leases []*Lease) []string {
+	urls := make([]string, 0, len(leases))
+	for _, lease := range leases {
+		urls = append(urls, lease.URL)
+	}
+	return urls
+}
+
+func TestFrontierEnqueueAndLease(t *testing.T) {
+	ctx := context.Background()
+	f := newTestFrontier(t, miniredis.RunT(t), nil)
+
+	enqueued, err := f.Enqueue(ctx,
+		Entry{URL: "https://example.com/1.html", Site: "site-a"},
+		Entry{URL: "https://example.com/2.html", Site: "site-a", Priority: 10},
+		Entry{URL: "https://example.com/3.html#top", Site: "site-a"},
+		Entry{URL: "HTTPS://EXAMPLE.COM/1.html", Site: "site-a"},
+		Entry{URL: "https://example.com/b/1.html", Site: "site-b"},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the duplicate of 1.html in the same batch is dropped
+	if len(enqueued) != 4 || enqueued[2].URL != "https://example.com/3.html" {
+		t.Fatalf("unexpected enqueued entries: %+v", enqueued)
+	}
+	if enqueued, err = f.Enqueue(ctx, Entry{URL: "https://example.com/2.html", Site: "site-a"}); err != nil ||
+		len(enqueued) != 0 {
+		t.Fatalf("the seen url shouldn't be enqueued again: %+v, %v", enqueued, err)
+	}
+
+	if _, err = f.Lease(ctx, "site-a", 0); err == nil {
+		t.Fatal("a non-positive count should be rejected")
+	}
+	leases, err := f.Lease(ctx, "site-a", 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{"https://example.com/2.html", "https://example.com/1.html"}
+	if !reflect.DeepEqual(leasedUrls(leases), expected) || leases[0].Deadline.Before(time.Now()) {
+		t.Fatalf("unexpected leases: %v", leasedUrls(leases))
+	}
+
+	stats, err := f.Stats(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(stats, &Stats{Queued: map[string]int64{"site-a": 1, "site-b": 1}, Leased: 2}) {
+		t.Fatalf("unexpected stats: %+v", stats)
+	}
+}
+
+func TestFrontierCompleteAndFail(t *testing.T) {
+	ctx := context.Background()
+	f := newTestFrontier(t, miniredis.RunT(t), &config.FrontierSetting{MaxAttempts: 2})
+	if _, err := f.Enqueue(ctx, Entry{URL: "https://example.com/1.html", Site: "site-a"},
+		Entry{URL: "https://example.com/2.html", Site: "site-a"}); err != nil {
+		t.Fatal(err)
+	}
+
+	leases, err := f.Lease(ctx, "site-a", 2)
+	if err != nil || len(leases) != 2 {
+		t.Fatalf("unexpected leases: %v, %v", leases, err)
+	}
+	if err = f.Complete(ctx, leases[0]); err != nil {
+		t.Fatal(err)
+	}
+
+	// the url is retried until it fails MaxAttempts times
+	if retried, err := f.Fail(ctx, leases[1], errors.New("timeout")); err != nil || !retried {
+		t.Fatalf("the url should be retried: %v, %v", retried, err)
+	}
+	if _, err = f.Fail(ctx, leases[1], nil); !errors.Is(err, ErrLeaseLost) {
+		t.Fatalf("ErrLeaseLost is expected, got %v", err)
+	}
+	retry, err := f.Lease(ctx, "site-a", 1)
+	if err != nil || len(retry) != 1 || retry[0].Attempts != 1 || retry[0].LastError != "timeout" {
+		t.Fatalf("unexpected retry: %+v, %v", retry, err)
+	}
+	if retried, err := f.Fail(ctx, retry[0], errors.New("timeout")); err != nil || retried {
+		t.Fatalf("the url should be failed: %v, %v", retried, err)
+	}
+
+	stats, err := f.Stats(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(stats, &Stats{Queued: map[string]int64{"site-a": 0}, Fetched: 1, Failed: 1}) {
+		t.Fatalf("unexpected stats: %+v", stats)
+	}
+}
+
+func TestFrontierReclaimExpiredLeases(t *testing.T) {
+	ctx := context.Background()
+	f := newTestFrontier(t, miniredis.RunT(t), nil)
+	if _, err := f.Enqueue(ctx, Entry{URL: "https://example.com/1.html", Site: "site-a"}); err != nil {
+		t.Fatal(err)
+	}
+	leases, err := f.Lease(ctx, "site-a", 1)
+	if err != nil || len(leases) != 1 {
+		t.Fatalf("unexpected leases: %v, %v", leases, err)
+	}
+	if reclaimed, err := f.Reclaim(ctx); err != nil || reclaimed != 0 {
+		t.Fatalf("the lease isn't expired yet: %d, %v", reclaimed, err)
+	}
+
+	// the worker crashed and the deadline has passed
+	expired := time.Now().Add(-time.Second).UnixMilli()
+	f.client.ZAdd(ctx, f.key("leases"), redis.Z{Score: float64(expired), Member: leases[0].URL})
+	if reclaimed, err := f.Reclaim(ctx); err != nil || reclaimed != 1 {
+		t.Fatalf("the lease should be reclaimed: %d, %v", reclaimed, err)
+	}
+	if _, err = f.Fail(ctx, leases[0], nil); !errors.Is(err, ErrLeaseLost) {
+		t.Fatalf("ErrLeaseLost is expected, got %v", err)
+	}
+	again, err := f.Lease(ctx, "site-a", 1)
+	if err != nil || !reflect.DeepEqual(leasedUrls(again), leasedUrls(leases)) {
+		t.Fatalf("the reclaimed url should be leased again: %v, %v", leasedUrls(again), err)
+	}
+}
+
+func TestFrontierBloomDedup(t *testing.T) {
+	ctx := context.Background()
+	server := miniredis.RunT(t)
+	f := newTestFrontier(t, server, &config.FrontierSetting{Dedup: "bloom", BloomCapacity: 1000})
+
+	enqueued, err := f.Enqueue(ctx, Entry{URL: "https://example.com/1.html", Site: "site-a"},
+		Entry{URL: "https://example.com/2.html", Site: "site-a"}, Entry{URL: "https://example.com/1.html#top"})
+	if err != nil || len(enqueued) != 2 {
+		t.Fatalf("unexpected enqueued entries: %+v, %v", enqueued, err)
+	}
+	if enqueued, err = f.Enqueue(ctx, Entry{URL: "https://example.com/2.html", Site: "site-a"}); err != nil ||
+		len(enqueued) != 0 {
+		t.Fatalf("the seen url shouldn't be enqueued again: %+v, %v", enqueued, err)
+	}
+	if typ := server.Type(f.key("seen")); typ != "string" {
+		t.Fatalf("the seen urls should be a bitmap, got %s", typ)
+	}
+
+	// the deduper also works alone
+	d := NewBloomDeduper(f.client, "seen", 1000, 0.01)
+	added, err := d.Add(ctx, "a", "b", "a")
+	if err != nil || !reflect.DeepEqual(added, []bool{true, true, false}) {
+		t.Fatalf("unexpected added urls: %v, %v", added, err)
+	}
+	d = NewSetDeduper(f.client, "seen-set")
+	if added, err = d.Add(ctx, "a", "b", "a"); err != nil || !reflect.DeepEqual(added, []bool{true, true, false}) {
+		t.Fatalf("unexpected added urls: %v, %v", added, err)
+	}
+}
+
+func TestFrontierResumesAfterRestart(t *testing.T) {
+	ctx := context.Background()
+	server := miniredis.RunT(t)
+	f := newTestFrontier(t, server, nil)
+	if _, err := f.Enqueue(ctx, Entry{URL: "https://example.com/1.html", Site: "site-a"},
+		Entry{URL: "https://example.com/2.html", Site: "site-a"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.Lease(ctx, "site-a", 1); err != nil {
+		t.Fatal(err)
+	}
+
+	// a new process takes over the frontier from redis
+	restarted := newTestFrontier(t, server, nil)
+	if enqueued, err := restarted.Enqueue(ctx, Entry{URL: "https://example.com/1.html", Site: "site-a"}); err != nil ||
+		len(enqueued) != 0 {
+		t.Fatalf("the seen url shouldn't be enqueued after the restart: %+v, %v", enqueued, err)
+	}
+	leases, err := restarted.Lease(ctx, "site-a", 10)
+	if err != nil || !reflect.DeepEqual(leasedUrls(leases), []string{"https://example.com/2.html"}) {
+		t.Fatalf("unexpected leases: %v, %v", leasedUrls(leases), err)
+	}
+	stats, err := restarted.Stats(ctx)
+	if err != nil || stats.Leased != 2 {
+		t.Fatalf("unexpected stats: %+v, %v", stats, err)
+	}
+}
@@ -0,0 +1,42 @@
+package health
+
+import (
+	"context"
+	"fmt"
+	"github.com/panjf2000/ants/v2"
+	"github.com/redis/go-redis/v9"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/readpref"
+)
+
+// RedisCheck pings redis
+func RedisCheck(client *redis.Client) Check {
+	return func(ctx context.Context) error {
+		return client.Ping(ctx).Err()
+	}
+}
+
+// MongoCheck pings the primary of mongodb
+func MongoCheck(client *mongo.Client) Check {
+	return func(ctx context.Context) error {
+		return client.Ping(ctx, readpref.Primary())
+	}
+}
+
+// TaskPoolCheck fails if the pool is closed or the running workers reach the ratio of its capacity,
+// e.g. 0.95. A pool with unlimited capacity is never saturated.
+func TaskPoolCheck(pool *ants.Pool, saturation float64) Check {
+	return func(ctx context.Context) error {
+		if pool.IsClosed() {
+			return fmt.Errorf("task pool is closed")
+		}
+		capacity := pool.Cap()
+		if capacity <= 0 {
+			return nil
+		}
+		if running := pool.Running(); float64(running) >= float64(capacity)*saturation {
+			return fmt.Errorf("task pool is saturated, %d of %d workers are running", running, capacity)
+		}
+		return nil
+	}
+}
@@ -0,0 +1,28 @@
+package health
+
+import (
+	"github.com/gin-gonic/gin"
+	"net/http"
+)
+
+const (
+	LivenessPath  = "/healthz"
+	ReadinessPath = "/readyz"
+)
+
+// Mount serves the liveness and readiness probes on the router
+func (r *Registry) Mount(router gin.IRouter) {
+	router.GET(LivenessPath, r.handler(Liveness))
+	router.GET(ReadinessPath, r.handler(Readiness))
+}
+
+func (r *Registry) handler(kind Kind) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		report := r.Check(c.Request.Context(), kind)
+		status := http.StatusOK
+		if !report.Healthy() {
+			status = http.StatusServiceUnavailable
+		}
+		c.JSON(status, report)
+	}
+}
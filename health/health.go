@@ -0,0 +1,178 @@
+package health
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	StatusUp   = "UP"
+	StatusDown = "DOWN"
+
+	defaultCacheTTL     = 3 * time.Second
+	defaultCheckTimeout = 2 * time.Second
+)
+
+// ErrShuttingDown is reported by the readiness once the shutdown begins
+var ErrShuttingDown = errors.New("server is shutting down")
+
+// Kind tells which probe a check belongs to
+type Kind int
+
+const (
+	// Readiness checks decide whether the service is able to serve requests, e.g. the connections
+	Readiness Kind = iota
+	// Liveness checks decide whether the process should be restarted
+	Liveness
+)
+
+// Check returns an error if the component is unhealthy
+type Check func(ctx context.Context) error
+
+// CheckResult is the status of a single check
+type CheckResult struct {
+	Status    string  `json:"status"`
+	LatencyMs float64 `json:"latencyMs"`
+	Error     string  `json:"error,omitempty"`
+}
+
+// Report is the aggregated status of a probe
+type Report struct {
+	Status    string                 `json:"status"`
+	Checks    map[string]CheckResult `json:"checks"`
+	CheckedAt time.Time              `json:"checkedAt"`
+}
+
+// Healthy reports whether all the checks pass
+func (r *Report) Healthy() bool {
+	return r.Status == StatusUp
+}
+
+type namedCheck struct {
+	name  string
+	kind  Kind
+	check Check
+}
+
+// Registry holds the checks registered by the components, the reports are cached for a while so that
+// frequent probes don't overload the dependencies
+type Registry struct {
+	cacheTTL     time.Duration
+	checkTimeout time.Duration
+	shuttingDown atomic.Bool
+
+	lock   sync.RWMutex
+	checks []namedCheck
+
+	cacheLock sync.Mutex
+	cache     map[Kind]*Report
+}
+
+// NewRegistry creates a registry, the reports are cached for cacheTTL and each check times out after
+// checkTimeout, the defaults are used for non-positive values
+func NewRegistry(cacheTTL time.Duration, checkTimeout time.Duration) *Registry {
+	if cacheTTL <= 0 {
+		cacheTTL = defaultCacheTTL
+	}
+	if checkTimeout <= 0 {
+		checkTimeout = defaultCheckTimeout
+	}
+	return &Registry{cacheTTL: cacheTTL, checkTimeout: checkTimeout, cache: map[Kind]*Report{}}
+}
+
+// Register adds a check, the check with the same name and kind is replaced
+func (r *Registry) Register(name string, kind Kind, check Check) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	for i, c := range r.checks {
+		if c.name == name && c.kind == kind {
+			r.checks[i].check = check
+			return
+		}
+	}
+	r.checks = append(r.checks, namedCheck{name: name, kind: kind, check: check})
+}
+
+// SetShuttingDown makes the readiness fail at once without waiting for the cache to expire
+func (r *Registry) SetShuttingDown() {
+	r.shuttingDown.Store(true)
+}
+
+// ShuttingDown reports whether the shutdown begins
+func (r *Registry) ShuttingDown() bool {
+	return r.shuttingDown.Load()
+}
+
+// Check runs the checks of the kind concurrently, a cached report is returned if it's not expired
+func (r *Registry) Check(ctx context.Context, kind Kind) *Report {
+	if kind == Readiness && r.ShuttingDown() {
+		return &Report{
+			Status:    StatusDown,
+			Checks:    map[string]CheckResult{"shutdown": {Status: StatusDown, Error: ErrShuttingDown.Error()}},
+			CheckedAt: time.Now(),
+		}
+	}
+
+	r.cacheLock.Lock()
+	defer r.cacheLock.Unlock()
+	if cached, ok := r.cache[kind]; ok && time.Since(cached.CheckedAt) < r.cacheTTL {
+		return cached
+	}
+
+	report := r.run(ctx, kind)
+	r.cache[kind] = report
+	return report
+}
+
+func (r *Registry) run(ctx context.Context, kind Kind) *Report {
+	r.lock.RLock()
+	var checks []namedCheck
+	for _, c := range r.checks {
+		if c.kind == kind {
+			checks = append(checks, c)
+		}
+	}
+	r.lock.RUnlock()
+
+	report := &Report{Status: StatusUp, Checks: make(map[string]CheckResult, len(checks))}
+	var resultLock sync.Mutex
+	var wg sync.WaitGroup
+	for _, c := range checks {
+		wg.Add(1)
+		go func(c namedCheck) {
+			defer wg.Done()
+			result := r.runCheck(ctx, c.check)
+
+			resultLock.Lock()
+			defer resultLock.Unlock()
+			report.Checks[c.name] = result
+			if result.Status != StatusUp {
+				report.Status = StatusDown
+			}
+		}(c)
+	}
+	wg.Wait()
+	report.CheckedAt = time.Now()
+	return report
+}
+
+func (r *Registry) runCheck(ctx context.Context, check Check) (result CheckResult) {
+	ctx, cancel := context.WithTimeout(ctx, r.checkTimeout)
+	defer cancel()
+
+	start := time.Now()
+	defer func() {
+		if err := recover(); err != nil {
+			result = CheckResult{Status: StatusDown, Error: "check panics"}
+		}
+		result.LatencyMs = float64(time.Since(start).Microseconds()) / 1000
+	}()
+
+	if err := check(ctx); err != nil {
+		return CheckResult{Status: StatusDown, Error: err.Error()}
+	}
+	return CheckResult{Status: StatusUp}
+}
@@ -0,0 +1,87 @@
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestCheckCachesReport(t *testing.T) {
+	registry := NewRegistry(time.Minute, time.Second)
+	var calls atomic.Int32
+	registry.Register("counter", Readiness, func(ctx context.Context) error {
+		calls.Add(1)
+		return nil
+	})
+
+	for i := 0; i < 3; i++ {
+		if report := registry.Check(context.Background(), Readiness); !report.Healthy() {
+			t.Fatalf("the report should be healthy: %+v", report)
+		}
+	}
+	if calls.Load() != 1 {
+		t.Fatalf("the check should run once within the cache ttl, got %d", calls.Load())
+	}
+}
+
+func TestCheckReportsFailureAndTimeout(t *testing.T) {
+	registry := NewRegistry(time.Millisecond, 50*time.Millisecond)
+	registry.Register("ok", Readiness, func(ctx context.Context) error { return nil })
+	registry.Register("broken", Readiness, func(ctx context.Context) error { return errors.New("connection refused") })
+	registry.Register("slow", Readiness, func(ctx context.Context) error {
+		<-ctx.Done()
+		return ctx.Err()
+	})
+	registry.Register("liveness", Liveness, func(ctx context.Context) error { return nil })
+
+	report := registry.Check(context.Background(), Readiness)
+	if report.Healthy() {
+		t.Fatal("the report should be unhealthy")
+	}
+	if len(report.Checks) != 3 {
+		t.Fatalf("only the readiness checks should run: %+v", report.Checks)
+	}
+	if report.Checks["ok"].Status != StatusUp || report.Checks["broken"].Error != "connection refused" {
+		t.Fatalf("unexpected results: %+v", report.Checks)
+	}
+	if slow := report.Checks["slow"]; slow.Status != StatusDown || slow.LatencyMs < 50 {
+		t.Fatalf("the slow check should time out: %+v", slow)
+	}
+}
+
+func TestReadinessFailsOnShutdown(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	registry := NewRegistry(time.Minute, time.Second)
+	registry.Register("ok", Readiness, func(ctx context.Context) error { return nil })
+	router := gin.New()
+	registry.Mount(router)
+
+	probe := func(path string) int {
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		var report Report
+		if err := json.Unmarshal(rec.Body.Bytes(), &report); err != nil {
+			t.Fatal(err)
+		}
+		return rec.Code
+	}
+
+	if code := probe(ReadinessPath); code != http.StatusOK {
+		t.Fatalf("expected ready, got %d", code)
+	}
+
+	// the cached report must not hide the shutdown
+	registry.SetShuttingDown()
+	if code := probe(ReadinessPath); code != http.StatusServiceUnavailable {
+		t.Fatalf("expected not ready, got %d", code)
+	}
+	if code := probe(LivenessPath); code != http.StatusOK {
+		t.Fatalf("the liveness should pass during shutdown, got %d", code)
+	}
+}
@@ -1,8 +1,11 @@
 package log
 
 import (
+	"context"
+	"fmt"
 	"github.com/jeven2016/mylibs/config"
 	"github.com/natefinch/lumberjack"
+	"go.opentelemetry.io/otel/trace"
 	"go.uber.org/zap"
 	"go.uber.org/zap/zapcore"
 	"os"
@@ -20,13 +23,16 @@ var logLevelMap = map[string]zapcore.Level{
 
 var Log *zap.Logger
 
+// the level of Log, it can be changed at runtime with SetLevel
+var atomicLevel = zap.NewAtomicLevel()
+
 func SetupLog(service string, cfg *config.LogConfig) *zap.Logger {
 	level, ok := logLevelMap[cfg.LogLevel]
 	if !ok {
 		panic("the log_level is invalid, it only supports: DEBUG,INFO,WARN, ERROR,DPANIC,PANIC,FATAL.  ")
 	}
 
-	atomicLevel := zap.NewAtomicLevelAt(level)
+	atomicLevel.SetLevel(level)
 
 	encoderConfig := zapcore.EncoderConfig{
 		TimeKey:        "time",
@@ -89,3 +95,62 @@ func SetupLog(service string, cfg *config.LogConfig) *zap.Logger {
 	zap.ReplaceGlobals(Log)
 	return Log
 }
+
+// SetLevel changes the level of the logger at runtime
+func SetLevel(logLevel string) error {
+	level, ok := logLevelMap[logLevel]
+	if !ok {
+		return fmt.Errorf("the log level %s is invalid, it only supports: DEBUG,INFO,WARN,ERROR,DPANIC,PANIC,FATAL", logLevel)
+	}
+	atomicLevel.SetLevel(level)
+	return nil
+}
+
+// GetLevel returns the current level of the logger
+func GetLevel() string {
+	for name, level := range logLevelMap {
+		if level == atomicLevel.Level() {
+			return name
+		}
+	}
+	return atomicLevel.Level().CapitalString()
+}
+
+// WatchLevel applies the log level of the reloaded ServerConfig until the returned function is called
+func WatchLevel(watcher *config.Watcher) (unwatch func()) {
+	return watcher.Subscribe(func(event *config.ChangeEvent) {
+		if !event.Changed("logConfig.logLevel") {
+			return
+		}
+		cfg, ok := event.New.(*config.ServerConfig)
+		if !ok || cfg.LogSetting == nil {
+			return
+		}
+		if err := SetLevel(cfg.LogSetting.LogLevel); err != nil {
+			zap.L().Warn("unable to change the log level", zap.Error(err))
+			return
+		}
+		zap.L().Info("log level changed", zap.String("level", cfg.LogSetting.LogLevel))
+	})
+}
+
+// TraceFields returns the trace id and span id of the context, nothing is returned if there's no span
+func TraceFields(ctx context.Context) []zap.Field {
+	spanCtx := trace.SpanContextFromContext(ctx)
+	if !spanCtx.IsValid() {
+		return nil
+	}
+	return []zap.Field{
+		zap.String("traceId", spanCtx.TraceID().String()),
+		zap.String("spanId", spanCtx.SpanID().String()),
+	}
+}
+
+// WithContext returns the global logger carrying the trace id of the context, so that the log entries
+// can be correlated with the traces
+func WithContext(ctx context.Context) *zap.Logger {
+	if fields := TraceFields(ctx); len(fields) > 0 {
+		return zap.L().With(fields...)
+	}
+	return zap.L()
+}
@@ -0,0 +1,195 @@
+package metrics
+
+import (
+	"context"
+	"github.com/panjf2000/ants/v2"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+	"sync"
+	"time"
+)
+
+const streamStatsTimeout = 3 * time.Second
+
+var (
+	streamPublished = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "redis_stream_published_total",
+		Help: "The number of messages published to the redis stream.",
+	}, []string{"stream"})
+	streamConsumed = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "redis_stream_consumed_total",
+		Help: "The number of messages read by the consumer group.",
+	}, []string{"stream", "group"})
+	streamAcked = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "redis_stream_acked_total",
+		Help: "The number of messages acknowledged by the consumer group.",
+	}, []string{"stream", "group"})
+
+	mongoCommandDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "mongo_command_duration_seconds",
+		Help:    "The latency of the mongodb commands.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"command", "status"})
+
+	clientRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "http_client_requests_total",
+		Help: "The number of outbound requests sent by colly or resty.",
+	}, []string{"client", "domain", "status"})
+
+	chromeCheckouts = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "chrome_pool_checkouts_total",
+		Help: "The number of chrome instances taken from the pool.",
+	})
+	chromeInUse = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "chrome_pool_in_use",
+		Help: "The number of chrome instances taken but not yet returned.",
+	})
+
+	ginRequestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "http_server_request_duration_seconds",
+		Help:    "The latency of the requests served by gin.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"method", "route", "status"})
+
+	streamStats = &streamStatsCollector{}
+	taskPools   = &taskPoolCollector{pools: map[string]*ants.Pool{}}
+)
+
+var componentCollectors = map[Component][]prometheus.Collector{
+	Redis:      {streamPublished, streamConsumed, streamAcked, streamStats},
+	TaskPool:   {taskPools},
+	Mongo:      {mongoCommandDuration},
+	HttpClient: {clientRequests},
+	Chrome:     {chromeCheckouts, chromeInUse},
+	Gin:        {ginRequestDuration},
+}
+
+// StreamPublished counts a message published to the stream
+func StreamPublished(stream string) {
+	if Enabled(Redis) {
+		streamPublished.WithLabelValues(stream).Inc()
+	}
+}
+
+// StreamConsumed counts a message read by the group
+func StreamConsumed(stream string, group string) {
+	if Enabled(Redis) {
+		streamConsumed.WithLabelValues(stream, group).Inc()
+	}
+}
+
+// StreamAcked counts a message acknowledged by the group
+func StreamAcked(stream string, group string) {
+	if Enabled(Redis) {
+		streamAcked.WithLabelValues(stream, group).Inc()
+	}
+}
+
+// ChromeCheckedOut counts an instance taken from the chrome pool
+func ChromeCheckedOut() {
+	if Enabled(Chrome) {
+		chromeCheckouts.Inc()
+		chromeInUse.Inc()
+	}
+}
+
+// ChromeReturned marks an instance returned to the chrome pool
+func ChromeReturned() {
+	if Enabled(Chrome) {
+		chromeInUse.Dec()
+	}
+}
+
+// StreamGroupStat is the backlog of a consumer group
+type StreamGroupStat struct {
+	Stream  string
+	Group   string
+	Pending int64
+	Lag     int64
+}
+
+// SetStreamStats sets the function reporting the pending and lag of the consumer groups, it's called
+// on every scrape
+func SetStreamStats(fn func(ctx context.Context) ([]StreamGroupStat, error)) {
+	streamStats.lock.Lock()
+	defer streamStats.lock.Unlock()
+	streamStats.fn = fn
+}
+
+var (
+	streamPendingDesc = prometheus.NewDesc("redis_stream_pending",
+		"The number of messages delivered to the group but not yet acknowledged.", []string{"stream", "group"}, nil)
+	streamLagDesc = prometheus.NewDesc("redis_stream_lag",
+		"The number of messages in the stream not yet delivered to the group.", []string{"stream", "group"}, nil)
+)
+
+type streamStatsCollector struct {
+	lock sync.RWMutex
+	fn   func(ctx context.Context) ([]StreamGroupStat, error)
+}
+
+func (s *streamStatsCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- streamPendingDesc
+	ch <- streamLagDesc
+}
+
+func (s *streamStatsCollector) Collect(ch chan<- prometheus.Metric) {
+	s.lock.RLock()
+	fn := s.fn
+	s.lock.RUnlock()
+	if fn == nil {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), streamStatsTimeout)
+	defer cancel()
+	stats, err := fn(ctx)
+	if err != nil {
+		zap.L().Warn("failed to collect the stats of redis streams", zap.Error(err))
+		return
+	}
+	for _, stat := range stats {
+		ch <- prometheus.MustNewConstMetric(streamPendingDesc, prometheus.GaugeValue, float64(stat.Pending), stat.Stream, stat.Group)
+		ch <- prometheus.MustNewConstMetric(streamLagDesc, prometheus.GaugeValue, float64(stat.Lag), stat.Stream, stat.Group)
+	}
+}
+
+// RegisterTaskPool reports the running and free workers of the pool under the name
+func RegisterTaskPool(name string, pool *ants.Pool) {
+	taskPools.lock.Lock()
+	defer taskPools.lock.Unlock()
+	taskPools.pools[name] = pool
+}
+
+// UnregisterTaskPool stops reporting the pool
+func UnregisterTaskPool(name string) {
+	taskPools.lock.Lock()
+	defer taskPools.lock.Unlock()
+	delete(taskPools.pools, name)
+}
+
+var (
+	poolRunningDesc = prometheus.NewDesc("task_pool_running_workers",
+		"The number of running workers of the task pool.", []string{"pool"}, nil)
+	poolFreeDesc = prometheus.NewDesc("task_pool_free_workers",
+		"The number of available workers of the task pool, -1 if the capacity is unlimited.", []string{"pool"}, nil)
+)
+
+type taskPoolCollector struct {
+	lock  sync.RWMutex
+	pools map[string]*ants.Pool
+}
+
+func (t *taskPoolCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- poolRunningDesc
+	ch <- poolFreeDesc
+}
+
+func (t *taskPoolCollector) Collect(ch chan<- prometheus.Metric) {
+	t.lock.RLock()
+	defer t.lock.RUnlock()
+	for name, pool := range t.pools {
+		ch <- prometheus.MustNewConstMetric(poolRunningDesc, prometheus.GaugeValue, float64(pool.Running()), name)
+		ch <- prometheus.MustNewConstMetric(poolFreeDesc, prometheus.GaugeValue, float64(pool.Free()), name)
+	}
+}
@@ -0,0 +1,88 @@
+package metrics
+
+import (
+	"context"
+	"github.com/gin-gonic/gin"
+	"github.com/go-resty/resty/v2"
+	"github.com/gocolly/colly/v2"
+	"go.mongodb.org/mongo-driver/event"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// GinMiddleware observes the latency of the requests by the route pattern rather than the raw path so
+// that the cardinality is bounded
+func GinMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !Enabled(Gin) {
+			c.Next()
+			return
+		}
+		start := time.Now()
+		c.Next()
+
+		route := c.FullPath()
+		if route == "" {
+			route = "unmatched"
+		}
+		ginRequestDuration.WithLabelValues(c.Request.Method, route, strconv.Itoa(c.Writer.Status())).
+			Observe(time.Since(start).Seconds())
+	}
+}
+
+// MongoMonitor observes the latency of the commands, it should be set on the client options
+func MongoMonitor() *event.CommandMonitor {
+	return &event.CommandMonitor{
+		Succeeded: func(ctx context.Context, evt *event.CommandSucceededEvent) {
+			if Enabled(Mongo) {
+				mongoCommandDuration.WithLabelValues(evt.CommandName, "succeeded").Observe(evt.Duration.Seconds())
+			}
+		},
+		Failed: func(ctx context.Context, evt *event.CommandFailedEvent) {
+			if Enabled(Mongo) {
+				mongoCommandDuration.WithLabelValues(evt.CommandName, "failed").Observe(evt.Duration.Seconds())
+			}
+		},
+	}
+}
+
+// InstrumentResty counts the requests of the client by domain and status, a transport error is counted
+// with the status "error"
+func InstrumentResty(c *resty.Client) {
+	c.OnAfterResponse(func(c *resty.Client, resp *resty.Response) error {
+		countRequest("resty", resp.Request.URL, strconv.Itoa(resp.StatusCode()))
+		return nil
+	})
+	c.OnError(func(r *resty.Request, err error) {
+		countRequest("resty", r.URL, "error")
+	})
+}
+
+// InstrumentColly counts the requests of the collector by domain and status
+func InstrumentColly(c *colly.Collector) {
+	c.OnResponse(func(r *colly.Response) {
+		countHost("colly", r.Request.URL.Hostname(), strconv.Itoa(r.StatusCode))
+	})
+	c.OnError(func(r *colly.Response, err error) {
+		status := "error"
+		if r.StatusCode > 0 {
+			status = strconv.Itoa(r.StatusCode)
+		}
+		countHost("colly", r.Request.URL.Hostname(), status)
+	})
+}
+
+func countRequest(client string, rawUrl string, status string) {
+	var host string
+	if u, err := url.Parse(rawUrl); err == nil {
+		host = u.Hostname()
+	}
+	countHost(client, host, status)
+}
+
+func countHost(client string, host string, status string) {
+	if Enabled(HttpClient) {
+		clientRequests.WithLabelValues(client, host, status).Inc()
+	}
+}
@@ -0,0 +1,94 @@
+package metrics
+
+import (
+	"github.com/jeven2016/mylibs/config"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"net/http"
+	"sync"
+	"sync/atomic"
+)
+
+// Component is a group of collectors which can be enabled separately in MetricsSetting
+type Component string
+
+const (
+	Redis      Component = "redis"
+	TaskPool   Component = "taskPool"
+	Mongo      Component = "mongo"
+	HttpClient Component = "httpClient"
+	Chrome     Component = "chrome"
+	Gin        Component = "gin"
+)
+
+var (
+	lock     sync.RWMutex
+	registry = prometheus.NewRegistry()
+	enabled  = map[Component]*atomic.Bool{
+		Redis:      {},
+		TaskPool:   {},
+		Mongo:      {},
+		HttpClient: {},
+		Chrome:     {},
+		Gin:        {},
+	}
+)
+
+// Enable registers the collectors of the components enabled in the setting together with the go runtime
+// and process collectors, the former registry is dropped so it can be called again on restarts
+func Enable(setting *config.MetricsSetting) error {
+	lock.Lock()
+	defer lock.Unlock()
+
+	for _, flag := range enabled {
+		flag.Store(false)
+	}
+	registry = prometheus.NewRegistry()
+	if setting == nil || !setting.Enabled {
+		return nil
+	}
+
+	cs := []prometheus.Collector{
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	}
+	components := map[Component]bool{
+		Redis:      setting.Redis,
+		TaskPool:   setting.TaskPool,
+		Mongo:      setting.Mongo,
+		HttpClient: setting.HttpClient,
+		Chrome:     setting.Chrome,
+		Gin:        setting.Gin,
+	}
+	for component, on := range components {
+		if on {
+			cs = append(cs, componentCollectors[component]...)
+		}
+	}
+	for _, c := range cs {
+		if err := registry.Register(c); err != nil {
+			return err
+		}
+	}
+	for component, on := range components {
+		enabled[component].Store(on)
+	}
+	return nil
+}
+
+// Enabled reports whether the collectors of the component are registered
+func Enabled(component Component) bool {
+	flag, ok := enabled[component]
+	return ok && flag.Load()
+}
+
+// Handler serves the metrics of the registry in the prometheus text format
+func Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lock.RLock()
+		reg := registry
+		lock.RUnlock()
+		promhttp.HandlerFor(reg, promhttp.HandlerOpts{}).ServeHTTP(w, r)
+	})
+}
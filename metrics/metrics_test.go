@@ -0,0 +1,84 @@
+package metrics
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/go-resty/resty/v2"
+	"github.com/jeven2016/mylibs/config"
+	"github.com/panjf2000/ants/v2"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func scrape(t *testing.T) string {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	body, err := io.ReadAll(rec.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(body)
+}
+
+func TestEnabledComponents(t *testing.T) {
+	if err := Enable(&config.MetricsSetting{Enabled: true, Gin: true, TaskPool: true, HttpClient: true}); err != nil {
+		t.Fatal(err)
+	}
+	defer Enable(nil)
+
+	pool, err := ants.NewPool(5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pool.Release()
+	RegisterTaskPool("crawler", pool)
+	defer UnregisterTaskPool("crawler")
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(GinMiddleware())
+	router.GET("/novels/:id", func(c *gin.Context) {
+		c.String(http.StatusOK, "ok")
+	})
+	server := httptest.NewServer(router)
+	defer server.Close()
+
+	client := resty.New()
+	InstrumentResty(client)
+	if _, err = client.R().Get(server.URL + "/novels/1"); err != nil {
+		t.Fatal(err)
+	}
+
+	// the stream counters are ignored since redis isn't enabled
+	StreamPublished("catalog")
+
+	body := scrape(t)
+	for _, expected := range []string{
+		`http_server_request_duration_seconds_count{method="GET",route="/novels/:id",status="200"} 1`,
+		`http_client_requests_total{client="resty",domain="127.0.0.1",status="200"} 1`,
+		`task_pool_free_workers{pool="crawler"} 5`,
+		`go_goroutines`,
+	} {
+		if !strings.Contains(body, expected) {
+			t.Errorf("%s is missing in:\n%s", expected, body)
+		}
+	}
+	if strings.Contains(body, "redis_stream_published_total") {
+		t.Error("the redis metrics should not be exposed")
+	}
+}
+
+func TestDisabled(t *testing.T) {
+	if err := Enable(&config.MetricsSetting{Enabled: false, Gin: true}); err != nil {
+		t.Fatal(err)
+	}
+	if Enabled(Gin) {
+		t.Fatal("no component is enabled unless the metrics are enabled")
+	}
+	if body := scrape(t); strings.TrimSpace(body) != "" {
+		t.Fatalf("nothing should be exposed: %s", body)
+	}
+}
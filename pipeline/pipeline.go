@@ -0,0 +1,355 @@
+package pipeline
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
+	"github.com/jeven2016/mylibs/cache"
+	"github.com/jeven2016/mylibs/config"
+	"github.com/jeven2016/mylibs/log"
+	"github.com/jeven2016/mylibs/system"
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/zap"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	DefaultStreamPrefix = "pipeline"
+	DefaultGroup        = "pipeline"
+	DefaultMaxAttempts  = 3
+	DefaultClaimIdle    = 5 * time.Minute
+)
+
+// ErrUnknownSite is returned if a task belongs to a site which isn't in the webSites of the config
+var ErrUnknownSite = errors.New("unknown site")
+
+// Broker moves the tasks between the stages, it's implemented by the *cache.Redis of the system
+type Broker interface {
+	EnsureConsumeGroupCreated(ctx context.Context, streamName string, group string) error
+	PublishMessage(ctx context.Context, data interface{}, streamName string) error
+	ConsumeMessages(ctx context.Context, streamName string, consumerGroup string,
+		msgChan chan<- *cache.StreamMessage, opts ...cache.ConsumeOption) error
+}
+
+// Handler crawls the url of a task and returns the tasks found in it, e.g. by task.Child(url), they're
+// published into the streams of their stages. The task is retried if an error is returned.
+type Handler func(ctx context.Context, task *Task) ([]*Task, error)
+
+type Option func(p *Pipeline)
+
+// WithStreamPrefix changes the prefix of the stream names, the streams are <prefix>:<stage>, or
+// <prefix>:<site>:<stage> for the sites using separate space
+func WithStreamPrefix(prefix string) Option {
+	return func(p *Pipeline) {
+		p.prefix = prefix
+	}
+}
+
+// WithGroup changes the consumer group, the services crawling the same stages should share a group
+func WithGroup(group string) Option {
+	return func(p *Pipeline) {
+		p.group = group
+	}
+}
+
+// WithMaxAttempts changes how many times a task is run before it's dropped
+func WithMaxAttempts(n int) Option {
+	return func(p *Pipeline) {
+		if n > 0 {
+			p.maxAttempts = n
+		}
+	}
+}
+
+// WithClaimIdle changes how long a task is left pending before it's claimed by another worker, e.g. if
+// the service crashed while handling it. It should be longer than a handler takes.
+func WithClaimIdle(d time.Duration) Option {
+	return func(p *Pipeline) {
+		if d > 0 {
+			p.claimIdle = d
+		}
+	}
+}
+
+// Pipeline wires the stages of the crawl flow over the streams of a Broker. The stages with a handler
+// are consumed by the service, so the stages can be crawled by different services sharing the streams.
+// A task is acked once it's handled and its children are published, so it's delivered at least once: a
+// task left pending by a crashed service is handled again after the claim idle time.
+type Pipeline struct {
+	broker      Broker
+	handlers    map[Stage]Handler
+	parallelism map[Stage]int
+	// the sites by name, true if the site uses separate streams
+	sites       map[string]bool
+	prefix      string
+	group       string
+	maxAttempts int
+	claimIdle   time.Duration
+}
+
+// New creates a pipeline for the webSites of the config. Every stream of a stage is consumed by the
+// parallelism of the stage in CrawlerSettings, i.e. catalogPageTaskParallelism, novelTaskParallelism and
+// chapterTaskParallelism, a stage isn't consumed if it's 0. The catalog stage is consumed one by one.
+func New(cfg *config.ServerConfig, broker Broker, handlers map[Stage]Handler, opts ...Option) (*Pipeline, error) {
+	if broker == nil {
+		return nil, errors.New("the broker of the pipeline is required")
+	}
+	p := &Pipeline{
+		broker:   broker,
+		handlers: map[Stage]Handler{},
+		parallelism: map[Stage]int{
+			StageCatalog:     1,
+			StageCatalogPage: 1,
+			StageNovel:       1,
+			StageChapter:     1,
+		},
+		sites:       map[string]bool{},
+		prefix:      DefaultStreamPrefix,
+		group:       DefaultGroup,
+		maxAttempts: DefaultMaxAttempts,
+		claimIdle:   DefaultClaimIdle,
+	}
+	for stage, handler := range handlers {
+		if !stage.Valid() {
+			return nil, fmt.Errorf("unknown stage %q", stage)
+		}
+		if handler != nil {
+			p.handlers[stage] = handler
+		}
+	}
+	if settings := cfg.CrawlerSettings; settings != nil {
+		p.parallelism[StageCatalogPage] = settings.CatalogPageTaskParallelism
+		p.parallelism[StageNovel] = settings.NovelTaskParallelism
+		p.parallelism[StageChapter] = settings.ChapterTaskParallelism
+	}
+	for _, site := range cfg.WebSites {
+		p.sites[site.Name] = site.UseSeparateSpace
+	}
+	for _, opt := range opts {
+		opt(p)
+	}
+	return p, nil
+}
+
+// StreamName returns the stream of the tasks of a site in a stage
+func (p *Pipeline) StreamName(site string, stage Stage) string {
+	if p.sites[site] {
+		return fmt.Sprintf("%s:%s:%s", p.prefix, site, stage)
+	}
+	return fmt.Sprintf("%s:%s", p.prefix, stage)
+}
+
+// Submit publishes the tasks into the streams of their stages, e.g. the catalogs seeding a crawl
+func (p *Pipeline) Submit(ctx context.Context, tasks ...*Task) error {
+	for _, task := range tasks {
+		if _, ok := p.sites[task.Site]; !ok {
+			return fmt.Errorf("%w: %s", ErrUnknownSite, task.Site)
+		}
+		if !task.Stage.Valid() {
+			return fmt.Errorf("unknown stage %q of task %s", task.Stage, task.URL)
+		}
+		if err := p.broker.PublishMessage(ctx, task, p.StreamName(task.Site, task.Stage)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Components returns a consumer per stream of the stages consumed by the service, they're named by the
+// streams and can be paused by the admin api. Register them once the broker is connected, e.g.
+// p.Register(ctx, sys.Components) after Startup.
+func (p *Pipeline) Components() []system.Component {
+	var components []system.Component
+	for _, stage := range Stages {
+		if p.handlers[stage] == nil || p.parallelism[stage] <= 0 {
+			continue
+		}
+		for _, stream := range p.streams(stage) {
+			components = append(components,
+				system.NewConsumer(stream, p.consume(stage, stream), system.ComponentRedis))
+		}
+	}
+	return components
+}
+
+// Register registers and starts the consumers of the pipeline in a running registry
+func (p *Pipeline) Register(ctx context.Context, registry *system.ComponentRegistry) error {
+	for _, component := range p.Components() {
+		if err := registry.Register(ctx, component); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// streams returns the shared stream of a stage if any site uses it and the streams of the other sites
+func (p *Pipeline) streams(stage Stage) []string {
+	var streams []string
+	shared := false
+	for _, site := range sortedKeys(p.sites) {
+		if p.sites[site] {
+			streams = append(streams, p.StreamName(site, stage))
+		} else {
+			shared = true
+		}
+	}
+	if shared {
+		streams = append([]string{fmt.Sprintf("%s:%s", p.prefix, stage)}, streams...)
+	}
+	return streams
+}
+
+// consume runs the workers of a stream until the context is canceled, the tasks being handled are
+// completed before it returns. If a worker fails to read the stream, the others are stopped as well and
+// the error is returned.
+func (p *Pipeline) consume(stage Stage, stream string) func(ctx context.Context) error {
+	return func(ctx context.Context) error {
+		if err := p.broker.EnsureConsumeGroupCreated(ctx, stream, p.group); err != nil {
+			return err
+		}
+
+		consumeCtx, cancel := context.WithCancel(ctx)
+		defer cancel()
+		var once sync.Once
+		var consumeErr error
+
+		var wg sync.WaitGroup
+		for i := 0; i < p.parallelism[stage]; i++ {
+			msgChan := make(chan *cache.StreamMessage)
+			wg.Add(2)
+			go func() {
+				defer wg.Done()
+				for msg := range msgChan {
+					p.handle(stage, stream, msg)
+				}
+			}()
+			go func() {
+				defer wg.Done()
+				err := p.broker.ConsumeMessages(consumeCtx, stream, p.group, msgChan,
+					cache.WithManualAck(), cache.WithClaimIdle(p.claimIdle))
+				// the errors caused by the cancellation are ignored
+				if err != nil && consumeCtx.Err() == nil {
+					once.Do(func() {
+						consumeErr = err
+						cancel()
+					})
+				}
+			}()
+		}
+		wg.Wait()
+
+		if consumeErr != nil {
+			return consumeErr
+		}
+		return ctx.Err()
+	}
+}
+
+// handle completes a task and acks it, the task is left pending if it can't be completed or retried
+func (p *Pipeline) handle(stage Stage, stream string, msg *cache.StreamMessage) {
+	// the task is completed even if the consumer is stopped meanwhile, only the span is kept
+	ctx := trace.ContextWithSpan(context.Background(), trace.SpanFromContext(msg.Context))
+	logger := log.WithContext(ctx).With(zap.String("stream", stream), zap.String("messageId", msg.ID))
+
+	if !p.complete(ctx, logger, stage, stream, msg) {
+		return
+	}
+	if err := msg.Ack(ctx); err != nil {
+		logger.Warn("failed to ack a task, it may be handled again", zap.Error(err))
+	}
+}
+
+// complete runs the handler of a task and publishes the tasks found, the task is published again if it
+// fails until it reaches the max attempts. If only some of the children are published, the task is
+// published again with the rest of them which are published by the next attempt without running the
+// handler. false is returned if the task can't be published again.
+func (p *Pipeline) complete(ctx context.Context, logger *zap.Logger, stage Stage, stream string,
+	msg *cache.StreamMessage) bool {
+	task := &Task{}
+	if err := json.Unmarshal([]byte(msg.Data), task); err != nil {
+		logger.Error("dropped an invalid task", zap.String("data", msg.Data), zap.Error(err))
+		return true
+	}
+	logger = logger.With(zap.String("taskId", task.ID), zap.String("site", task.Site),
+		zap.String("url", task.URL), zap.Int("attempt", task.Attempt))
+
+	var err error
+	children := task.Children
+	if len(children) == 0 {
+		children, err = p.run(ctx, stage, task)
+	}
+	if err == nil {
+		var published int
+		if published, err = p.publishChildren(ctx, task, children); err == nil {
+			return true
+		}
+		task.Children = children[published:]
+	}
+
+	task.Attempt++
+	if task.Attempt >= p.maxAttempts {
+		logger.Error("dropped a task after the max attempts", zap.Int("unpublishedChildren",
+			len(task.Children)), zap.Error(err))
+		return true
+	}
+	logger.Warn("retrying a failed task", zap.Error(err))
+	if err = p.broker.PublishMessage(ctx, task, stream); err != nil {
+		logger.Error("failed to retry a task, it's left pending", zap.Error(err))
+		return false
+	}
+	return true
+}
+
+// run calls the handler, a panic is returned as an error so that the worker keeps running
+func (p *Pipeline) run(ctx context.Context, stage Stage, task *Task) (children []*Task, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("the handler panics: %v", r)
+		}
+	}()
+	return p.handlers[stage](ctx, task)
+}
+
+// publishChildren completes the envelopes of the children and publishes them in order, it returns how
+// many of them are published
+func (p *Pipeline) publishChildren(ctx context.Context, parent *Task, children []*Task) (int, error) {
+	for i, child := range children {
+		if child == nil {
+			continue
+		}
+		if child.Stage == "" {
+			next, ok := parent.Stage.Next()
+			if !ok {
+				return i, fmt.Errorf("no stage follows %s for task %s", parent.Stage, child.URL)
+			}
+			child.Stage = next
+		}
+		if child.ID == "" {
+			child.ID = uuid.NewString()
+			child.CreatedAt = time.Now()
+		}
+		if child.Site == "" {
+			child.Site = parent.Site
+		}
+		if child.ParentID == "" {
+			child.ParentID = parent.ID
+		}
+		if err := p.Submit(ctx, child); err != nil {
+			return i, err
+		}
+	}
+	return len(children), nil
+}
+
+func sortedKeys(m map[string]bool) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,417 @@
+package pipeline
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/jeven2016/mylibs/cache"
+	"github.com/jeven2016/mylibs/config"
+	"github.com/redis/go-redis/v9"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// memoryBroker keeps a channel per stream, every message is delivered to one consumer
+type memoryBroker struct {
+	lock      sync.Mutex
+	streams   map[string]chan string
+	published []string
+	nextId    atomic.Int64
+
+	// failPublish and failConsume inject the errors of the broker if they're set
+	failPublish func(data interface{}) error
+	failConsume func() error
+}
+
+func newMemoryBroker() *memoryBroker {
+	return &memoryBroker{streams: map[string]chan string{}}
+}
+
+func (b *memoryBroker) stream(name string) chan string {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	if b.streams[name] == nil {
+		b.streams[name] = make(chan string, 100)
+	}
+	return b.streams[name]
+}
+
+func (b *memoryBroker) EnsureConsumeGroupCreated(ctx context.Context, streamName string, group string) error {
+	b.stream(streamName)
+	return nil
+}
+
+func (b *memoryBroker) PublishMessage(ctx context.Context, data interface{}, streamName string) error {
+	if b.failPublish != nil {
+		if err := b.failPublish(data); err != nil {
+			return err
+		}
+	}
+	body, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	b.lock.Lock()
+	b.published = append(b.published, streamName)
+	b.lock.Unlock()
+	b.stream(streamName) <- string(body)
+	return nil
+}
+
+func (b *memoryBroker) ConsumeMessages(ctx context.Context, streamName string, consumerGroup string,
+	msgChan chan<- *cache.StreamMessage, opts ...cache.ConsumeOption) error {
+	defer close(msgChan)
+	if b.failConsume != nil {
+		if err := b.failConsume(); err != nil {
+			return err
+		}
+	}
+	stream := b.stream(streamName)
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case data := <-stream:
+			id := strconv.FormatInt(b.nextId.Add(1), 10)
+			msgChan <- &cache.StreamMessage{ID: id, Data: data, Context: ctx}
+		}
+	}
+}
+
+func testConfig() *config.ServerConfig {
+	return &config.ServerConfig{
+		CrawlerSettings: &config.CrawlerSettings{
+			CatalogPageTaskParallelism: 2,
+			NovelTaskParallelism:       2,
+			ChapterTaskParallelism:     4,
+		},
+		WebSites: []config.SiteConfig{{Name: "shared"}, {Name: "isolated", UseSeparateSpace: true}},
+	}
+}
+
+func TestStageOrder(t *testing.T) {
+	if next, ok := StageCatalog.Next(); !ok || next != StageCatalogPage {
+		t.Fatalf("unexpected stage after catalog: %s", next)
+	}
+	if _, ok := StageChapter.Next(); ok {
+		t.Fatal("the chapter stage is the last one")
+	}
+
+	parent := NewTask("shared", "https://example.com/book/1/", StageNovel)
+	child := parent.Child("https://example.com/book/1/1.html")
+	if child.Stage != StageChapter || child.ParentID != parent.ID || child.Site != "shared" || child.ID == parent.ID {
+		t.Fatalf("unexpected child: %+v", child)
+	}
+}
+
+func TestStreamsAndComponents(t *testing.T) {
+	p, err := New(testConfig(), newMemoryBroker(), map[Stage]Handler{
+		StageChapter: func(ctx context.Context, task *Task) ([]*Task, error) { return nil, nil },
+	}, WithStreamPrefix("novels"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if name := p.StreamName("shared", StageChapter); name != "novels:chapter" {
+		t.Errorf("unexpected shared stream: %s", name)
+	}
+	if name := p.StreamName("isolated", StageChapter); name != "novels:isolated:chapter" {
+		t.Errorf("unexpected isolated stream: %s", name)
+	}
+
+	// only the stage with a handler is consumed, from the shared stream and the isolated one
+	components := p.Components()
+	if len(components) != 2 || components[0].Name() != "novels:chapter" ||
+		components[1].Name() != "novels:isolated:chapter" {
+		t.Fatalf("unexpected components: %v", components)
+	}
+
+	if err = p.Submit(context.Background(), NewTask("unknown", "https://example.com/", StageCatalog)); !errors.Is(err, ErrUnknownSite) {
+		t.Errorf("the task of an unknown site should be rejected: %v", err)
+	}
+	if _, err = New(testConfig(), newMemoryBroker(), map[Stage]Handler{"page": nil}); err == nil {
+		t.Error("an unknown stage should be rejected")
+	}
+}
+
+func TestPipelineFlow(t *testing.T) {
+	broker := newMemoryBroker()
+	var chapters, failures, maxRunning, running atomic.Int64
+	done := make(chan struct{}, 100)
+
+	handlers := map[Stage]Handler{
+		StageCatalog: func(ctx context.Context, task *Task) ([]*Task, error) {
+			return []*Task{task.Child(task.URL + "list/1.html")}, nil
+		},
+		StageCatalogPage: func(ctx context.Context, task *Task) ([]*Task, error) {
+			return []*Task{task.Child(task.URL + "#novel1"), task.Child(task.URL + "#novel2")}, nil
+		},
+		StageNovel: func(ctx context.Context, task *Task) ([]*Task, error) {
+			// the first attempt of every novel fails
+			if task.Attempt == 0 {
+				failures.Add(1)
+				return nil, errors.New("timeout")
+			}
+			var children []*Task
+			for i := 1; i <= 4; i++ {
+				child := task.Child(task.URL + "/" + strconv.Itoa(i))
+				child.Attributes = map[string]string{"novel": task.URL}
+				children = append(children, child)
+			}
+			return children, nil
+		},
+		StageChapter: func(ctx context.Context, task *Task) ([]*Task, error) {
+			n := running.Add(1)
+			defer running.Add(-1)
+			for {
+				max := maxRunning.Load()
+				if n <= max || maxRunning.CompareAndSwap(max, n) {
+					break
+				}
+			}
+			time.Sleep(50 * time.Millisecond)
+			if task.ParentID == "" || task.Attributes["novel"] == "" {
+				t.Errorf("the envelope isn't carried: %+v", task)
+			}
+			chapters.Add(1)
+			done <- struct{}{}
+			return nil, nil
+		},
+	}
+	p, err := New(testConfig(), broker, handlers)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	components := p.Components()
+	for _, c := range components {
+		if err = c.Start(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	defer func() {
+		for _, c := range components {
+			_ = c.Stop(context.Background())
+		}
+	}()
+
+	if err = p.Submit(context.Background(), NewTask("isolated", "https://example.com/", StageCatalog)); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 8; i++ {
+		select {
+		case <-done:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("only %d chapters are crawled", chapters.Load())
+		}
+	}
+
+	if failures.Load() != 2 {
+		t.Errorf("every novel should fail once: %d", failures.Load())
+	}
+	if maxRunning.Load() < 2 || maxRunning.Load() > 4 {
+		t.Errorf("the chapters should be crawled by 4 workers at most: %d", maxRunning.Load())
+	}
+	broker.lock.Lock()
+	defer broker.lock.Unlock()
+	for _, stream := range broker.published {
+		if !strings.HasPrefix(stream, "pipeline:isolated:") {
+			t.Errorf("the tasks of the isolated site should stay in its streams: %s", stream)
+		}
+	}
+}
+
+func TestPipelineDropsAfterMaxAttempts(t *testing.T) {
+	broker := newMemoryBroker()
+	var attempts atomic.Int64
+	p, err := New(testConfig(), broker, map[Stage]Handler{
+		StageChapter: func(ctx context.Context, task *Task) ([]*Task, error) {
+			attempts.Add(1)
+			panic("unexpected page")
+		},
+	}, WithMaxAttempts(2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range p.Components() {
+		if err = c.Start(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+		defer c.Stop(context.Background())
+	}
+
+	if err = p.Submit(context.Background(), NewTask("shared", "https://example.com/1.html", StageChapter)); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(300 * time.Millisecond)
+	if attempts.Load() != 2 {
+		t.Fatalf("the task should be run twice: %d", attempts.Load())
+	}
+}
+
+func TestPipelineRetriesUnpublishedChildren(t *testing.T) {
+	broker := newMemoryBroker()
+	var failed atomic.Bool
+	broker.failPublish = func(data interface{}) error {
+		// the third chapter fails once
+		if task, ok := data.(*Task); ok && strings.HasSuffix(task.URL, "/3") && failed.CompareAndSwap(false, true) {
+			return errors.New("connection reset")
+		}
+		return nil
+	}
+
+	var novelRuns atomic.Int64
+	chapters := make(chan string, 10)
+	p, err := New(testConfig(), broker, map[Stage]Handler{
+		StageNovel: func(ctx context.Context, task *Task) ([]*Task, error) {
+			novelRuns.Add(1)
+			var children []*Task
+			for i := 1; i <= 4; i++ {
+				children = append(children, task.Child(task.URL+"/"+strconv.Itoa(i)))
+			}
+			return children, nil
+		},
+		StageChapter: func(ctx context.Context, task *Task) ([]*Task, error) {
+			chapters <- task.URL
+			return nil, nil
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range p.Components() {
+		if err = c.Start(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+		defer c.Stop(context.Background())
+	}
+
+	if err = p.Submit(context.Background(), NewTask("shared", "https://example.com/book/1", StageNovel)); err != nil {
+		t.Fatal(err)
+	}
+	crawled := map[string]int{}
+	for i := 0; i < 4; i++ {
+		select {
+		case url := <-chapters:
+			crawled[url]++
+		case <-time.After(5 * time.Second):
+			t.Fatalf("only %v are crawled", crawled)
+		}
+	}
+	select {
+	case url := <-chapters:
+		t.Fatalf("%s is published twice", url)
+	case <-time.After(200 * time.Millisecond):
+	}
+	if len(crawled) != 4 || novelRuns.Load() != 1 {
+		t.Fatalf("every chapter should be published once without running the novel again: %v, %d runs",
+			crawled, novelRuns.Load())
+	}
+}
+
+func TestPipelineStopsWorkersOnError(t *testing.T) {
+	broker := newMemoryBroker()
+	var calls atomic.Int64
+	broker.failConsume = func() error {
+		// the second worker fails
+		if calls.Add(1) == 2 {
+			return errors.New("connection refused")
+		}
+		return nil
+	}
+	p, err := New(testConfig(), broker, map[Stage]Handler{
+		StageChapter: func(ctx context.Context, task *Task) ([]*Task, error) { return nil, nil },
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	result := make(chan error, 1)
+	go func() {
+		result <- p.consume(StageChapter, "pipeline:chapter")(context.Background())
+	}()
+	select {
+	case err = <-result:
+		if err == nil || err.Error() != "connection refused" {
+			t.Fatalf("the error of the worker should be returned: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the other workers should be stopped")
+	}
+}
+
+func TestPipelineAcksHandledTasks(t *testing.T) {
+	server := miniredis.RunT(t)
+	rd, err := cache.NewRedis(context.Background(), &config.RedisConfig{Address: server.Addr(), PoolSize: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rd.Client.Close()
+	ctx := context.Background()
+
+	// a task left pending by a crashed service
+	stream := "pipeline:chapter"
+	if err = rd.EnsureConsumeGroupCreated(ctx, stream, DefaultGroup); err != nil {
+		t.Fatal(err)
+	}
+	if err = rd.PublishMessage(ctx, NewTask("shared", "https://example.com/1.html", StageChapter), stream); err != nil {
+		t.Fatal(err)
+	}
+	if err = rd.Client.XReadGroup(ctx, &redis.XReadGroupArgs{
+		Group: DefaultGroup, Consumer: "crashed", Streams: []string{stream, ">"}, Count: 1, Block: -1,
+	}).Err(); err != nil {
+		t.Fatal(err)
+	}
+
+	release := make(chan struct{})
+	handled := make(chan string, 10)
+	p, err := New(testConfig(), rd, map[Stage]Handler{
+		StageChapter: func(ctx context.Context, task *Task) ([]*Task, error) {
+			handled <- task.URL
+			<-release
+			return nil, nil
+		},
+	}, WithClaimIdle(200*time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range p.Components() {
+		if c.Name() != stream {
+			continue
+		}
+		if err = c.Start(ctx); err != nil {
+			t.Fatal(err)
+		}
+		defer c.Stop(ctx)
+	}
+
+	select {
+	case url := <-handled:
+		if url != "https://example.com/1.html" {
+			t.Fatalf("unexpected task: %s", url)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the pending task should be claimed")
+	}
+	// the task isn't acked while it's handled
+	if pending, err := rd.Client.XPending(ctx, stream, DefaultGroup).Result(); err != nil || pending.Count != 1 {
+		t.Fatalf("the task should be pending: %v, %v", pending, err)
+	}
+
+	close(release)
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		pending, err := rd.Client.XPending(ctx, stream, DefaultGroup).Result()
+		if err == nil && pending.Count == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("the task should be acked once it's handled: %v, %v", pending, err)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
@@ -0,0 +1,75 @@
+package pipeline
+
+import (
+	"github.com/google/uuid"
+	"time"
+)
+
+// Stage is a stage of the crawl flow, a task of a stage produces the tasks of the next one
+type Stage string
+
+const (
+	StageCatalog     Stage = "catalog"
+	StageCatalogPage Stage = "catalogPage"
+	StageNovel       Stage = "novel"
+	StageChapter     Stage = "chapter"
+)
+
+// Stages are the stages in order: catalog -> catalogPage -> novel -> chapter
+var Stages = []Stage{StageCatalog, StageCatalogPage, StageNovel, StageChapter}
+
+// Next returns the stage after s, false is returned for the chapter stage
+func (s Stage) Next() (Stage, bool) {
+	for i, stage := range Stages {
+		if stage == s && i+1 < len(Stages) {
+			return Stages[i+1], true
+		}
+	}
+	return "", false
+}
+
+func (s Stage) Valid() bool {
+	for _, stage := range Stages {
+		if stage == s {
+			return true
+		}
+	}
+	return false
+}
+
+// Task is the envelope of a url crawled by a stage, it's published into the stream of the stage as json
+type Task struct {
+	ID       string `json:"id"`
+	ParentID string `json:"parentId,omitempty"`
+	Site     string `json:"site"`
+	URL      string `json:"url"`
+	Stage    Stage  `json:"stage"`
+	// Attempt is the number of the failed runs of the task, it's 0 for the first run
+	Attempt   int       `json:"attempt"`
+	CreatedAt time.Time `json:"createdAt"`
+	// Attributes carry the values extracted by the parent for the child, e.g. the novel name of a chapter
+	Attributes map[string]string `json:"attributes,omitempty"`
+	// Children are the tasks found by the handler which are left to publish by a retry, the handler isn't
+	// run again if it's set
+	Children []*Task `json:"children,omitempty"`
+}
+
+// NewTask creates the task of a url, e.g. a catalog url seeding the crawl of a site
+func NewTask(site string, url string, stage Stage) *Task {
+	return &Task{
+		ID:        uuid.NewString(),
+		Site:      site,
+		URL:       url,
+		Stage:     stage,
+		CreatedAt: time.Now(),
+	}
+}
+
+// Child creates a task of the next stage for a url found by t, the stage can be changed before it's
+// returned by a Handler, e.g. the next catalog page stays in the catalogPage stage
+func (t *Task) Child(url string) *Task {
+	stage, _ := t.Stage.Next()
+	child := NewTask(t.Site, url, stage)
+	child.ParentID = t.ID
+	return child
+}
@@ -0,0 +1,166 @@
+package system
+
+import (
+	"context"
+	"crypto/subtle"
+	"github.com/gin-gonic/gin"
+	"github.com/jeven2016/mylibs/cache"
+	"github.com/jeven2016/mylibs/log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// consumerActionTimeout bounds how long a pause or resume waits for the loop of a consumer to return
+const consumerActionTimeout = 15 * time.Second
+
+// ConsumerState is the state of a pausable component
+type ConsumerState struct {
+	Name   string `json:"name"`
+	Paused bool   `json:"paused"`
+}
+
+type logLevelRequest struct {
+	Level string `json:"level" binding:"required"`
+}
+
+// mountAdmin serves the admin api on Admin.Path if it's enabled:
+//
+//	GET  /config                  the effective config of the loader with the secrets masked
+//	GET  /streams                 the length, pending and lag of the consumed streams
+//	GET  /pools                   the usage of the task pools
+//	GET  /inspect/:name           the state returned by an inspector of the params, e.g. the chrome pool
+//	GET  /components              the state of the components
+//	GET  /jobs                    the state and history of the scheduled jobs
+//	GET  /log/level               the log level
+//	PUT  /log/level               changes the log level, e.g. {"level": "DEBUG"}
+//	GET  /consumers               the consumers and whether they're paused
+//	POST /consumers/:name/pause   pauses a consumer
+//	POST /consumers/:name/resume  resumes a consumer
+func (s *System) mountAdmin(router *gin.Engine, params *StartupParams) {
+	setting := params.Config.Admin
+	if setting == nil || !setting.Enabled {
+		return
+	}
+
+	admin := router.Group(setting.Path, adminAuth(setting.Token))
+	admin.GET("/config", func(c *gin.Context) {
+		if params.ConfigWatcher != nil {
+			if effective := params.ConfigWatcher.EffectiveConfig(); effective != nil {
+				c.JSON(http.StatusOK, effective)
+				return
+			}
+		}
+		if params.ConfigLoader != nil {
+			c.JSON(http.StatusOK, params.ConfigLoader.EffectiveConfig())
+			return
+		}
+		// the values resolved from the secret references can't be told apart without the loader
+		c.JSON(http.StatusNotFound, gin.H{"error": "the config is only served if ConfigWatcher or ConfigLoader is set"})
+	})
+	admin.GET("/streams", func(c *gin.Context) {
+		if s.RedisClient == nil {
+			c.JSON(http.StatusOK, []cache.StreamStat{})
+			return
+		}
+		stats, err := s.RedisClient.StreamStats(c.Request.Context())
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, stats)
+	})
+	admin.GET("/pools", func(c *gin.Context) {
+		c.JSON(http.StatusOK, s.Pools.Stats())
+	})
+	admin.GET("/inspect/:name", func(c *gin.Context) {
+		inspect, ok := params.Inspectors[c.Param("name")]
+		if !ok {
+			c.JSON(http.StatusNotFound, gin.H{"error": "the inspector " + c.Param("name") + " isn't provided"})
+			return
+		}
+		c.JSON(http.StatusOK, inspect())
+	})
+	admin.GET("/components", func(c *gin.Context) {
+		c.JSON(http.StatusOK, s.Components.States())
+	})
+	admin.GET("/jobs", func(c *gin.Context) {
+		if s.Scheduler == nil {
+			c.JSON(http.StatusOK, []JobState{})
+			return
+		}
+		c.JSON(http.StatusOK, s.Scheduler.Jobs())
+	})
+
+	admin.GET("/log/level", func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{"level": log.GetLevel()})
+	})
+	admin.PUT("/log/level", func(c *gin.Context) {
+		var req logLevelRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if err := log.SetLevel(strings.ToUpper(req.Level)); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"level": log.GetLevel()})
+	})
+
+	admin.GET("/consumers", func(c *gin.Context) {
+		c.JSON(http.StatusOK, s.consumerStates())
+	})
+	admin.POST("/consumers/:name/pause", s.consumerHandler(func(ctx context.Context, p Pausable) error {
+		return p.Pause(ctx)
+	}))
+	admin.POST("/consumers/:name/resume", s.consumerHandler(func(ctx context.Context, p Pausable) error {
+		return p.Resume(ctx)
+	}))
+}
+
+// adminAuth rejects the requests without the bearer token
+func adminAuth(token string) gin.HandlerFunc {
+	expected := []byte("Bearer " + token)
+	return func(c *gin.Context) {
+		actual := []byte(c.GetHeader("Authorization"))
+		if subtle.ConstantTimeCompare(actual, expected) != 1 {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "invalid admin token"})
+			return
+		}
+		c.Next()
+	}
+}
+
+func (s *System) consumerStates() []ConsumerState {
+	states := []ConsumerState{}
+	for _, state := range s.Components.States() {
+		if p, ok := s.Components.Get(state.Name).(Pausable); ok {
+			states = append(states, ConsumerState{Name: state.Name, Paused: p.Paused()})
+		}
+	}
+	return states
+}
+
+func (s *System) consumerHandler(action func(ctx context.Context, p Pausable) error) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		name := c.Param("name")
+		component := s.Components.Get(name)
+		if component == nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "the component " + name + " isn't registered"})
+			return
+		}
+		p, ok := component.(Pausable)
+		if !ok {
+			c.JSON(http.StatusBadRequest, gin.H{"error": ErrNotPausable.Error()})
+			return
+		}
+		ctx, cancel := context.WithTimeout(c.Request.Context(), consumerActionTimeout)
+		defer cancel()
+		if err := action(ctx, p); err != nil {
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, ConsumerState{Name: name, Paused: p.Paused()})
+	}
+}
@@ -0,0 +1,124 @@
+package system
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/jeven2016/mylibs/config"
+	"github.com/jeven2016/mylibs/log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestAdminApi(t *testing.T) {
+	// the address is resolved from a secret reference, it's masked by the loader
+	t.Setenv("ADMIN_TEST_REDIS_ADDRESS", "redis-host:6379")
+	cfg := &config.ServerConfig{}
+	var loader *config.Loader
+	if err := config.LoadConfig([]byte(`
+applicationName: test-app
+redis:
+  address: ${env:ADMIN_TEST_REDIS_ADDRESS}
+admin:
+  enabled: true
+  token: secret-token
+`), cfg, nil, nil, config.WithLoader(&loader)); err != nil {
+		t.Fatal(err)
+	}
+	cfg.Http = &config.HttpSetting{Address: "127.0.0.1", Port: freePort(t)}
+	cfg.LogSetting = &config.LogConfig{LogPath: t.TempDir(), LogLevel: "ERROR"}
+
+	consumed := make(chan struct{}, 10)
+	sys, err := Startup(context.Background(), &StartupParams{
+		Config:       cfg,
+		ConfigLoader: loader,
+		// the admin api is served without any route of the service
+		Components: []Component{NewConsumer("chapterConsumer", func(ctx context.Context) error {
+			consumed <- struct{}{}
+			<-ctx.Done()
+			return ctx.Err()
+		}, ComponentTaskPool)},
+		Inspectors:    map[string]func() any{"chrome": func() any { return map[string]int{"inUse": 1} }},
+		DisableGlobal: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sys.Stop(context.Background())
+	<-consumed
+
+	call := func(method string, path string, body string, token string) (int, string) {
+		req := httptest.NewRequest(method, "/admin"+path, strings.NewReader(body))
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		recorder := httptest.NewRecorder()
+		sys.Router.ServeHTTP(recorder, req)
+		return recorder.Code, recorder.Body.String()
+	}
+
+	if code, _ := call(http.MethodGet, "/components", "", "wrong"); code != http.StatusUnauthorized {
+		t.Fatalf("the wrong token should be rejected, got %d", code)
+	}
+
+	code, body := call(http.MethodGet, "/config", "", "secret-token")
+	if code != http.StatusOK || strings.Contains(body, "secret-token") || strings.Contains(body, "redis-host") ||
+		!strings.Contains(body, "test-app") {
+		t.Fatalf("unexpected config %d: %s", code, body)
+	}
+	if code, body = call(http.MethodGet, "/inspect/chrome", "", "secret-token"); body != `{"inUse":1}` {
+		t.Fatalf("unexpected chrome state %d: %s", code, body)
+	}
+	if code, body = call(http.MethodGet, "/pools", "", "secret-token"); !strings.Contains(body, `"name":"default"`) {
+		t.Fatalf("unexpected pools %d: %s", code, body)
+	}
+
+	defer log.SetLevel("ERROR")
+	if code, body = call(http.MethodPut, "/log/level", `{"level":"debug"}`, "secret-token"); log.GetLevel() != "DEBUG" {
+		t.Fatalf("the log level isn't changed %d: %s", code, body)
+	}
+	if code, _ = call(http.MethodPut, "/log/level", `{"level":"verbose"}`, "secret-token"); code != http.StatusBadRequest {
+		t.Fatalf("the invalid level should be rejected, got %d", code)
+	}
+
+	// the consumer is called again on resume
+	code, body = call(http.MethodPost, "/consumers/chapterConsumer/pause", "", "secret-token")
+	var state ConsumerState
+	if err = json.Unmarshal([]byte(body), &state); err != nil || code != http.StatusOK || !state.Paused {
+		t.Fatalf("unexpected pause result %d: %s", code, body)
+	}
+	if code, body = call(http.MethodPost, "/consumers/chapterConsumer/resume", "", "secret-token"); code != http.StatusOK {
+		t.Fatalf("unexpected resume result %d: %s", code, body)
+	}
+	<-consumed
+
+	if code, _ = call(http.MethodPost, "/consumers/taskPool/pause", "", "secret-token"); code != http.StatusBadRequest {
+		t.Fatalf("the task pool can't be paused, got %d", code)
+	}
+}
+
+func TestAdminConfigWithoutLoader(t *testing.T) {
+	sys, err := Startup(context.Background(), &StartupParams{
+		Config: &config.ServerConfig{
+			ApplicationName: "test-app",
+			Http:            &config.HttpSetting{Address: "127.0.0.1", Port: freePort(t)},
+			LogSetting:      &config.LogConfig{LogPath: t.TempDir(), LogLevel: "ERROR"},
+			Admin:           &config.AdminSetting{Enabled: true, Token: "secret-token"},
+		},
+		DisableGlobal: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sys.Stop(context.Background())
+
+	// the values resolved from secret references can't be masked without the loader
+	req := httptest.NewRequest(http.MethodGet, "/admin/config", nil)
+	req.Header.Set("Authorization", "Bearer secret-token")
+	recorder := httptest.NewRecorder()
+	sys.Router.ServeHTTP(recorder, req)
+	if recorder.Code != http.StatusNotFound || strings.Contains(recorder.Body.String(), "test-app") {
+		t.Fatalf("the config shouldn't be served without the loader %d: %s", recorder.Code, recorder.Body.String())
+	}
+}
@@ -0,0 +1,415 @@
+package system
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"go.uber.org/zap"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultStartTimeout = 30 * time.Second
+	defaultStopTimeout  = 15 * time.Second
+)
+
+// the states of a component
+const (
+	StateRegistered = "registered"
+	StateStarted    = "started"
+	StateStopped    = "stopped"
+	StateFailed     = "failed"
+)
+
+// Component is a part of the system with a lifecycle, e.g. a client, a server or a consumer. It's started
+// after the components it depends on and stopped before them.
+type Component interface {
+	// Name is the unique name of the component in the registry
+	Name() string
+	// Dependencies returns the names of the components which must be started before this one
+	Dependencies() []string
+	// Start initializes the component, the context is canceled once the start timeout elapses so it
+	// should not be kept by the background routines
+	Start(ctx context.Context) error
+	// Stop releases the component, the context is canceled once the stop timeout elapses
+	Stop(ctx context.Context) error
+}
+
+// IntakeStopper is implemented by the components accepting work, e.g. the http server or a stream consumer.
+// StopIntake is called on shutdown before the in-flight tasks are waited for and any component is stopped.
+type IntakeStopper interface {
+	StopIntake(ctx context.Context) error
+}
+
+// funcComponent adapts the functions to a Component
+type funcComponent struct {
+	name         string
+	dependencies []string
+	start        func(ctx context.Context) error
+	stopIntake   func(ctx context.Context) error
+	stop         func(ctx context.Context) error
+}
+
+// NewComponent creates a component from the functions, either of them can be nil, e.g. a ChromePool
+// which only needs to be closed on shutdown
+func NewComponent(name string, start func(ctx context.Context) error, stop func(ctx context.Context) error,
+	dependencies ...string) Component {
+	return &funcComponent{name: name, dependencies: dependencies, start: start, stop: stop}
+}
+
+// NewIntakeComponent creates a component which stops accepting work with stopIntake before being stopped
+func NewIntakeComponent(name string, start func(ctx context.Context) error, stopIntake func(ctx context.Context) error,
+	stop func(ctx context.Context) error, dependencies ...string) Component {
+	return &funcComponent{name: name, dependencies: dependencies, start: start, stopIntake: stopIntake, stop: stop}
+}
+
+func (f *funcComponent) Name() string           { return f.name }
+func (f *funcComponent) Dependencies() []string { return f.dependencies }
+
+func (f *funcComponent) Start(ctx context.Context) error {
+	if f.start == nil {
+		return nil
+	}
+	return f.start(ctx)
+}
+
+func (f *funcComponent) StopIntake(ctx context.Context) error {
+	if f.stopIntake == nil {
+		return nil
+	}
+	return f.stopIntake(ctx)
+}
+
+func (f *funcComponent) Stop(ctx context.Context) error {
+	if f.stop == nil {
+		return nil
+	}
+	return f.stop(ctx)
+}
+
+// ComponentOption sets the options of a registered component
+type ComponentOption func(entry *componentEntry)
+
+// WithStartTimeout limits how long the component takes to start, it's 30 seconds by default
+func WithStartTimeout(timeout time.Duration) ComponentOption {
+	return func(entry *componentEntry) {
+		entry.startTimeout = timeout
+	}
+}
+
+// WithStopTimeout limits how long the component takes to stop, it's 15 seconds by default
+func WithStopTimeout(timeout time.Duration) ComponentOption {
+	return func(entry *componentEntry) {
+		entry.stopTimeout = timeout
+	}
+}
+
+// ComponentError is the error of a component while starting or stopping it
+type ComponentError struct {
+	Component string
+	// Phase is start, stop intake or stop
+	Phase string
+	Err   error
+}
+
+func (e *ComponentError) Error() string {
+	return fmt.Sprintf("failed to %s component %s: %v", e.Phase, e.Component, e.Err)
+}
+
+func (e *ComponentError) Unwrap() error {
+	return e.Err
+}
+
+// LifecycleError aggregates the errors of the components
+type LifecycleError struct {
+	Errors []*ComponentError
+}
+
+func (e *LifecycleError) Error() string {
+	messages := make([]string, 0, len(e.Errors))
+	for _, err := range e.Errors {
+		messages = append(messages, err.Error())
+	}
+	return strings.Join(messages, "; ")
+}
+
+// Is reports whether any error of the components matches the target
+func (e *LifecycleError) Is(target error) bool {
+	for _, err := range e.Errors {
+		if errors.Is(err, target) {
+			return true
+		}
+	}
+	return false
+}
+
+// As finds the first error of the components that matches the target, e.g. a *ComponentError
+func (e *LifecycleError) As(target any) bool {
+	for _, err := range e.Errors {
+		if errors.As(err, target) {
+			return true
+		}
+	}
+	return false
+}
+
+func (e *LifecycleError) Unwrap() []error {
+	errs := make([]error, 0, len(e.Errors))
+	for _, err := range e.Errors {
+		errs = append(errs, err)
+	}
+	return errs
+}
+
+// ComponentState describes a registered component
+type ComponentState struct {
+	Name         string   `json:"name"`
+	Dependencies []string `json:"dependencies,omitempty"`
+	State        string   `json:"state"`
+	Error        string   `json:"error,omitempty"`
+}
+
+type componentEntry struct {
+	component    Component
+	startTimeout time.Duration
+	stopTimeout  time.Duration
+	state        string
+	err          error
+}
+
+// ComponentRegistry starts the components in the order of their dependencies and stops them in reverse
+type ComponentRegistry struct {
+	lock    sync.Mutex
+	entries map[string]*componentEntry
+	// the names in the order of registration, it keeps the order of independent components stable
+	names   []string
+	started []string
+	running bool
+}
+
+func NewComponentRegistry() *ComponentRegistry {
+	return &ComponentRegistry{entries: map[string]*componentEntry{}}
+}
+
+// Register adds a component, it's started at once if the registry is already running
+func (r *ComponentRegistry) Register(ctx context.Context, component Component, options ...ComponentOption) error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	name := component.Name()
+	if _, ok := r.entries[name]; ok {
+		return fmt.Errorf("the component %s is already registered", name)
+	}
+	entry := &componentEntry{
+		component:    component,
+		startTimeout: defaultStartTimeout,
+		stopTimeout:  defaultStopTimeout,
+		state:        StateRegistered,
+	}
+	for _, opt := range options {
+		opt(entry)
+	}
+
+	if r.running {
+		for _, dep := range component.Dependencies() {
+			if depEntry, ok := r.entries[dep]; !ok || depEntry.state != StateStarted {
+				return fmt.Errorf("the dependency %s of component %s isn't started", dep, name)
+			}
+		}
+		r.entries[name] = entry
+		r.names = append(r.names, name)
+		if err := r.start(ctx, entry); err != nil {
+			return &LifecycleError{Errors: []*ComponentError{err}}
+		}
+		return nil
+	}
+
+	r.entries[name] = entry
+	r.names = append(r.names, name)
+	return nil
+}
+
+// Get returns the component of the name, nil is returned if it isn't registered
+func (r *ComponentRegistry) Get(name string) Component {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if entry, ok := r.entries[name]; ok {
+		return entry.component
+	}
+	return nil
+}
+
+// States returns the states of the components in the order of registration
+func (r *ComponentRegistry) States() []ComponentState {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	states := make([]ComponentState, 0, len(r.names))
+	for _, name := range r.names {
+		entry := r.entries[name]
+		state := ComponentState{Name: name, Dependencies: entry.component.Dependencies(), State: entry.state}
+		if entry.err != nil {
+			state.Error = entry.err.Error()
+		}
+		states = append(states, state)
+	}
+	return states
+}
+
+// Start starts the components in the order of their dependencies. If a component fails, the ones already
+// started are stopped in reverse order and all the errors are returned as a *LifecycleError.
+func (r *ComponentRegistry) Start(ctx context.Context) error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	order, err := r.sort()
+	if err != nil {
+		return err
+	}
+
+	r.running = true
+	for _, name := range order {
+		if startErr := r.start(ctx, r.entries[name]); startErr != nil {
+			errs := []*ComponentError{startErr}
+			errs = append(errs, r.stop(ctx)...)
+			return &LifecycleError{Errors: errs}
+		}
+	}
+	return nil
+}
+
+// Stop stops the started components in reverse order, a failing component doesn't prevent the others
+// from being stopped and the errors are returned as a *LifecycleError
+func (r *ComponentRegistry) Stop(ctx context.Context) error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if errs := r.stop(ctx); len(errs) > 0 {
+		return &LifecycleError{Errors: errs}
+	}
+	return nil
+}
+
+// StopIntake asks the started components to stop accepting work in reverse order, the components keep
+// running so that the in-flight tasks can complete. The errors are returned as a *LifecycleError.
+func (r *ComponentRegistry) StopIntake(ctx context.Context) error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	var errs []*ComponentError
+	for i := len(r.started) - 1; i >= 0; i-- {
+		entry := r.entries[r.started[i]]
+		stopper, ok := entry.component.(IntakeStopper)
+		if !ok {
+			continue
+		}
+
+		stopCtx, cancel := context.WithTimeout(ctx, entry.stopTimeout)
+		err := stopper.StopIntake(stopCtx)
+		cancel()
+		if err != nil {
+			name := entry.component.Name()
+			errs = append(errs, &ComponentError{Component: name, Phase: "stop intake", Err: err})
+			zap.L().Warn("failed to stop the intake of component", zap.String("component", name), zap.Error(err))
+		}
+	}
+	if len(errs) > 0 {
+		return &LifecycleError{Errors: errs}
+	}
+	return nil
+}
+
+func (r *ComponentRegistry) start(ctx context.Context, entry *componentEntry) *ComponentError {
+	name := entry.component.Name()
+	startCtx, cancel := context.WithTimeout(ctx, entry.startTimeout)
+	defer cancel()
+
+	if err := entry.component.Start(startCtx); err != nil {
+		entry.state = StateFailed
+		entry.err = err
+		return &ComponentError{Component: name, Phase: "start", Err: err}
+	}
+	entry.state = StateStarted
+	entry.err = nil
+	r.started = append(r.started, name)
+	zap.L().Info("component started", zap.String("component", name))
+	return nil
+}
+
+func (r *ComponentRegistry) stop(ctx context.Context) []*ComponentError {
+	// the context of the caller may be canceled already, the components still get their stop timeout
+	parent := ctx
+	if parent.Err() != nil {
+		parent = context.Background()
+	}
+
+	var errs []*ComponentError
+	for i := len(r.started) - 1; i >= 0; i-- {
+		entry := r.entries[r.started[i]]
+		name := entry.component.Name()
+
+		stopCtx, cancel := context.WithTimeout(parent, entry.stopTimeout)
+		err := entry.component.Stop(stopCtx)
+		cancel()
+
+		if err != nil {
+			entry.state = StateFailed
+			entry.err = err
+			errs = append(errs, &ComponentError{Component: name, Phase: "stop", Err: err})
+			zap.L().Warn("failed to stop component", zap.String("component", name), zap.Error(err))
+			continue
+		}
+		entry.state = StateStopped
+		zap.L().Info("component stopped", zap.String("component", name))
+	}
+	r.started = nil
+	r.running = false
+	return errs
+}
+
+// sort orders the components so that the dependencies come first, the order of registration is kept
+// for the independent ones
+func (r *ComponentRegistry) sort() ([]string, error) {
+	pending := map[string]int{}
+	dependents := map[string][]string{}
+	for _, name := range r.names {
+		deps := r.entries[name].component.Dependencies()
+		for _, dep := range deps {
+			if _, ok := r.entries[dep]; !ok {
+				return nil, fmt.Errorf("the component %s depends on %s which isn't registered", name, dep)
+			}
+			dependents[dep] = append(dependents[dep], name)
+		}
+		pending[name] = len(deps)
+	}
+
+	order := make([]string, 0, len(r.names))
+	done := map[string]bool{}
+	for len(order) < len(r.names) {
+		progressed := false
+		for _, name := range r.names {
+			if done[name] || pending[name] > 0 {
+				continue
+			}
+			done[name] = true
+			order = append(order, name)
+			for _, dependent := range dependents[name] {
+				pending[dependent]--
+			}
+			progressed = true
+			break
+		}
+		if !progressed {
+			var cyclic []string
+			for _, name := range r.names {
+				if !done[name] {
+					cyclic = append(cyclic, name)
+				}
+			}
+			return nil, fmt.Errorf("the dependencies of the components are cyclic: %s", strings.Join(cyclic, ", "))
+		}
+	}
+	return order, nil
+}
@@ -0,0 +1,219 @@
+package system
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"github.com/jeven2016/mylibs/config"
+	"net"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+type recorder struct {
+	lock   sync.Mutex
+	events []string
+}
+
+func (r *recorder) add(event string) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.events = append(r.events, event)
+}
+
+func (r *recorder) component(name string, startErr error, deps ...string) Component {
+	return NewComponent(name, func(ctx context.Context) error {
+		r.add("start " + name)
+		return startErr
+	}, func(ctx context.Context) error {
+		r.add("stop " + name)
+		return nil
+	}, deps...)
+}
+
+func TestComponentOrder(t *testing.T) {
+	rec := &recorder{}
+	registry := NewComponentRegistry()
+	ctx := context.Background()
+	for _, c := range []Component{
+		rec.component("consumer", nil, "redis", "pool"),
+		rec.component("redis", nil),
+		rec.component("pool", nil),
+		rec.component("http", nil, "consumer"),
+	} {
+		if err := registry.Register(ctx, c); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := registry.Start(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := registry.Stop(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{
+		"start redis", "start pool", "start consumer", "start http",
+		"stop http", "stop consumer", "stop pool", "stop redis",
+	}
+	if !reflect.DeepEqual(rec.events, expected) {
+		t.Fatalf("unexpected order: %v", rec.events)
+	}
+	for _, state := range registry.States() {
+		if state.State != StateStopped {
+			t.Fatalf("the component should be stopped: %+v", state)
+		}
+	}
+}
+
+func TestComponentStartFailure(t *testing.T) {
+	rec := &recorder{}
+	registry := NewComponentRegistry()
+	ctx := context.Background()
+	_ = registry.Register(ctx, rec.component("redis", nil))
+	_ = registry.Register(ctx, rec.component("mongodb", errors.New("connection refused"), "redis"))
+	_ = registry.Register(ctx, rec.component("http", nil, "mongodb"))
+
+	err := registry.Start(ctx)
+	var lifecycleErr *LifecycleError
+	if !errors.As(err, &lifecycleErr) || lifecycleErr.Errors[0].Component != "mongodb" {
+		t.Fatalf("the failing component should be reported: %v", err)
+	}
+
+	// the started ones are rolled back
+	expected := []string{"start redis", "start mongodb", "stop redis"}
+	if !reflect.DeepEqual(rec.events, expected) {
+		t.Fatalf("unexpected events: %v", rec.events)
+	}
+}
+
+func TestComponentStopErrorsAggregated(t *testing.T) {
+	registry := NewComponentRegistry()
+	ctx := context.Background()
+	stopped := map[string]bool{}
+	for _, name := range []string{"a", "b", "c"} {
+		name := name
+		_ = registry.Register(ctx, NewComponent(name, nil, func(ctx context.Context) error {
+			stopped[name] = true
+			if name == "c" {
+				return nil
+			}
+			return errors.New(name + " failed")
+		}), WithStopTimeout(time.Second))
+	}
+	if err := registry.Start(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	err := registry.Stop(ctx)
+	if err == nil || !strings.Contains(err.Error(), "a failed") || !strings.Contains(err.Error(), "b failed") {
+		t.Fatalf("the errors should be aggregated: %v", err)
+	}
+	if len(stopped) != 3 {
+		t.Fatalf("all the components should be stopped: %v", stopped)
+	}
+}
+
+func TestComponentStartTimeout(t *testing.T) {
+	registry := NewComponentRegistry()
+	ctx := context.Background()
+	_ = registry.Register(ctx, NewComponent("slow", func(ctx context.Context) error {
+		<-ctx.Done()
+		return ctx.Err()
+	}, nil), WithStartTimeout(50*time.Millisecond))
+
+	if err := registry.Start(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("the start should time out: %v", err)
+	}
+}
+
+func TestComponentDependencyErrors(t *testing.T) {
+	ctx := context.Background()
+
+	registry := NewComponentRegistry()
+	_ = registry.Register(ctx, NewComponent("a", nil, nil, "b"))
+	_ = registry.Register(ctx, NewComponent("b", nil, nil, "a"))
+	if err := registry.Start(ctx); err == nil || !strings.Contains(err.Error(), "cyclic") {
+		t.Fatalf("the cycle should be detected: %v", err)
+	}
+
+	registry = NewComponentRegistry()
+	_ = registry.Register(ctx, NewComponent("a", nil, nil, "missing"))
+	if err := registry.Start(ctx); err == nil || !strings.Contains(err.Error(), "missing") {
+		t.Fatalf("the unknown dependency should be reported: %v", err)
+	}
+
+	if err := registry.Register(ctx, NewComponent("a", nil, nil)); err == nil {
+		t.Fatal("the duplicated name should be rejected")
+	}
+}
+
+func TestComponentRegisteredAfterStart(t *testing.T) {
+	rec := &recorder{}
+	registry := NewComponentRegistry()
+	ctx := context.Background()
+	_ = registry.Register(ctx, rec.component("redis", nil))
+	if err := registry.Start(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := registry.Register(ctx, rec.component("chrome", nil, "redis")); err != nil {
+		t.Fatal(err)
+	}
+	if err := registry.Stop(ctx); err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{"start redis", "start chrome", "stop chrome", "stop redis"}
+	if !reflect.DeepEqual(rec.events, expected) {
+		t.Fatalf("unexpected events: %v", rec.events)
+	}
+}
+
+func TestServiceComponentsStartBeforeIntake(t *testing.T) {
+	cfg := shutdownConfig(t, 1)
+	cfg.Http = &config.HttpSetting{Address: "127.0.0.1", Port: freePort(t)}
+	address := fmt.Sprintf("127.0.0.1:%d", cfg.Http.Port)
+	listening := func() bool {
+		conn, err := net.DialTimeout("tcp", address, time.Second)
+		if err == nil {
+			_ = conn.Close()
+		}
+		return err == nil
+	}
+
+	rec := &recorder{}
+	sys, err := Startup(context.Background(), &StartupParams{
+		Config:      cfg,
+		SetupRouter: func(router *gin.Engine) {},
+		Components: []Component{
+			NewComponent("chrome", func(ctx context.Context) error {
+				rec.add(fmt.Sprintf("start chrome, listening %v", listening()))
+				return nil
+			}, nil),
+			// it's started once the requests are served, e.g. warming up the cache through the api
+			NewComponent("warmup", func(ctx context.Context) error {
+				rec.add(fmt.Sprintf("start warmup, listening %v", listening()))
+				return nil
+			}, nil, ComponentHttp),
+		},
+		DisableGlobal: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sys.Stop(context.Background())
+
+	expected := []string{"start chrome, listening false", "start warmup, listening true"}
+	if !reflect.DeepEqual(rec.events, expected) {
+		t.Fatalf("unexpected order: %v", rec.events)
+	}
+	deps := sys.Components.Get(ComponentScheduler).Dependencies()
+	if !reflect.DeepEqual(deps, []string{ComponentTaskPool, "chrome"}) {
+		t.Fatalf("the scheduler should start after the components of the service: %v", deps)
+	}
+}
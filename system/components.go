@@ -0,0 +1,189 @@
+package system
+
+import (
+	"context"
+	"github.com/jeven2016/mylibs/cache"
+	"github.com/jeven2016/mylibs/db"
+	"github.com/jeven2016/mylibs/health"
+	"time"
+)
+
+// the names of the built-in components, the components of the services can depend on them
+const (
+	ComponentTaskPool  = "taskPool"
+	ComponentRedis     = "redis"
+	ComponentMongo     = "mongodb"
+	ComponentHttp      = "http"
+	ComponentEtcd      = "etcd"
+	ComponentScheduler = "scheduler"
+)
+
+// taskPoolSaturation is the ratio of running workers at which the service is no longer ready
+const taskPoolSaturation = 0.95
+
+// registerBuiltinComponents registers the clients and servers enabled by the params, the http server
+// depends on all the clients used by the handlers and the service is registered in etcd once the http
+// server is listening, so the service is deregistered first on shutdown
+func (s *System) registerBuiltinComponents(ctx context.Context, params *StartupParams) error {
+	cfg := params.Config
+	var components []Component
+	var options [][]ComponentOption
+
+	add := func(c Component, opts ...ComponentOption) {
+		components = append(components, c)
+		options = append(options, opts)
+	}
+
+	add(NewComponent(ComponentTaskPool, func(ctx context.Context) error {
+		pools, err := newTaskPools(cfg.TaskPoolSetting)
+		if err != nil {
+			return err
+		}
+		s.Pools = pools
+		s.TaskPool = pools.Get(DefaultPoolName)
+		s.Health.Register("taskPool", health.Readiness, health.TaskPoolCheck(s.TaskPool, taskPoolSaturation))
+		return nil
+	}, func(ctx context.Context) error {
+		s.Pools.release()
+		return nil
+	}))
+
+	// the intake waits for the components of the service unless they depend on it
+	services := serviceComponents(params.Components)
+
+	clients := []string{ComponentTaskPool}
+	if params.EnableRedis {
+		clients = append(clients, ComponentRedis)
+		add(NewComponent(ComponentRedis, func(ctx context.Context) error {
+			var redisClient *cache.Redis
+			err := retry(ctx, params.ConnectRetry, ComponentRedis, func(ctx context.Context) (err error) {
+				redisClient, err = cache.NewRedis(ctx, cfg.Redis)
+				return err
+			})
+			if err != nil {
+				return err
+			}
+			s.RedisClient = redisClient
+			s.Health.Register("redis", health.Readiness, health.RedisCheck(redisClient.Client))
+			return nil
+		}, func(ctx context.Context) error {
+			return s.RedisClient.Client.Close()
+		}), WithStartTimeout(params.ConnectRetry.timeout()))
+	}
+
+	if params.EnableMongodb {
+		clients = append(clients, ComponentMongo)
+		add(NewComponent(ComponentMongo, func(ctx context.Context) error {
+			var mongoClient *db.Mongo
+			err := retry(ctx, params.ConnectRetry, ComponentMongo, func(ctx context.Context) (err error) {
+				mongoClient, err = db.NewMongo(ctx, cfg.Mongo, mongoOptions(params)...)
+				return err
+			})
+			if err != nil {
+				return err
+			}
+			s.MongoClient = mongoClient
+			s.Health.Register("mongodb", health.Readiness, health.MongoCheck(mongoClient.Client))
+			return nil
+		}, func(ctx context.Context) error {
+			return s.MongoClient.Client.Disconnect(ctx)
+		}), WithStartTimeout(params.ConnectRetry.timeout()))
+	}
+
+	if params.servesHttp() {
+		var opts []ComponentOption
+		if cfg.Http != nil && cfg.Http.ShutdownTimeoutSeconds > 0 {
+			opts = append(opts, WithStopTimeout(time.Duration(cfg.Http.ShutdownTimeoutSeconds)*time.Second))
+		}
+		add(NewIntakeComponent(ComponentHttp, func(ctx context.Context) error {
+			return s.startHttpServer(params)
+		}, s.stopHttpServer, nil, append(clients, services...)...), opts...)
+	}
+
+	if params.EnableEtcd {
+		deps := append([]string{ComponentTaskPool}, services...)
+		if params.servesHttp() {
+			deps = append(deps, ComponentHttp)
+		}
+		// the service is deregistered while stopping the intake so that no request is routed here
+		add(NewIntakeComponent(ComponentEtcd, func(ctx context.Context) error {
+			return s.RegisterService(cfg)
+		}, func(ctx context.Context) error {
+			return s.ServiceRegister.Cancel(ctx)
+		}, nil, deps...))
+	}
+
+	// the jobs run on the task pool and the single-instance ones are locked in redis
+	schedulerDeps := append([]string{ComponentTaskPool}, services...)
+	if params.EnableRedis {
+		schedulerDeps = append(schedulerDeps, ComponentRedis)
+	}
+	add(NewIntakeComponent(ComponentScheduler, func(ctx context.Context) error {
+		opts := []SchedulerOption{WithHistorySize(cfg.Scheduler.HistorySize)}
+		if s.RedisClient != nil {
+			opts = append(opts, WithLocker(NewRedisLocker(s.RedisClient.Client), "scheduler:"+cfg.ApplicationName+":"))
+		}
+		scheduler := NewScheduler(s.Submit, opts...)
+		if err := scheduler.ScheduleConfig(cfg.Scheduler, params.Jobs); err != nil {
+			return err
+		}
+		s.Scheduler = scheduler
+		scheduler.Start()
+		return nil
+	}, func(ctx context.Context) error {
+		return s.Scheduler.StopIntake(ctx)
+	}, func(ctx context.Context) error {
+		return s.Scheduler.Stop(ctx)
+	}, schedulerDeps...))
+
+	for i, c := range components {
+		if err := s.Components.Register(ctx, c, options[i]...); err != nil {
+			return err
+		}
+	}
+	for _, c := range params.Components {
+		if err := s.Components.Register(ctx, c); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// serviceComponents returns the names of the components that don't depend on the http server, the etcd
+// registration or the scheduler, either directly or through other components of the service
+func serviceComponents(components []Component) []string {
+	byName := map[string]Component{}
+	for _, c := range components {
+		byName[c.Name()] = c
+	}
+	intake := map[string]bool{ComponentHttp: true, ComponentEtcd: true, ComponentScheduler: true}
+	visited := map[string]bool{}
+	var dependsOnIntake func(name string) bool
+	dependsOnIntake = func(name string) bool {
+		if result, ok := intake[name]; ok || visited[name] {
+			// a cycle is reported while sorting the components
+			return result
+		}
+		visited[name] = true
+		c, ok := byName[name]
+		if !ok {
+			return false
+		}
+		for _, dep := range c.Dependencies() {
+			if dependsOnIntake(dep) {
+				intake[name] = true
+				return true
+			}
+		}
+		intake[name] = false
+		return false
+	}
+
+	var names []string
+	for _, c := range components {
+		if !dependsOnIntake(c.Name()) {
+			names = append(names, c.Name())
+		}
+	}
+	return names
+}
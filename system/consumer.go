@@ -0,0 +1,147 @@
+package system
+
+import (
+	"context"
+	"errors"
+	"go.uber.org/zap"
+	"sync"
+)
+
+// ErrNotPausable is returned if a component can't be paused, only the consumers can
+var ErrNotPausable = errors.New("the component can't be paused")
+
+// Pausable is a component whose intake can be paused and resumed at runtime, e.g. by the admin api
+type Pausable interface {
+	Pause(ctx context.Context) error
+	Resume(ctx context.Context) error
+	Paused() bool
+}
+
+// consumer runs a loop accepting work until its intake is stopped
+type consumer struct {
+	name         string
+	dependencies []string
+	consume      func(ctx context.Context) error
+
+	lock    sync.Mutex
+	cancel  context.CancelFunc
+	done    chan struct{}
+	paused  bool
+	stopped bool
+}
+
+// NewConsumer creates a component running consume in background, e.g. a loop reading a redis stream.
+// The context passed to consume is canceled when the intake is stopped on shutdown or the consumer is
+// paused, consume should return once it is canceled. It's called again on resume.
+func NewConsumer(name string, consume func(ctx context.Context) error, dependencies ...string) Component {
+	return &consumer{name: name, dependencies: dependencies, consume: consume}
+}
+
+func (c *consumer) Name() string           { return c.name }
+func (c *consumer) Dependencies() []string { return c.dependencies }
+
+func (c *consumer) Start(ctx context.Context) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if err := c.wait(ctx); err != nil {
+		return err
+	}
+	c.stopped = false
+	c.paused = false
+	c.run()
+	return nil
+}
+
+// run starts the loop, the lock must be held
+func (c *consumer) run() {
+	// the context of Start is canceled once it returns, so the loop gets its own
+	consumeCtx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	c.cancel = cancel
+	c.done = done
+
+	go func() {
+		defer close(done)
+		if err := c.consume(consumeCtx); err != nil && !errors.Is(err, context.Canceled) {
+			zap.L().Error("consumer stopped unexpectedly", zap.String("component", c.name), zap.Error(err))
+		}
+	}()
+}
+
+// halt cancels the loop and waits for it to return, the lock must be held. The loop counts as stopped
+// once it's canceled even if it doesn't return in time, the next run waits for it first
+func (c *consumer) halt(ctx context.Context) error {
+	if c.cancel != nil {
+		c.cancel()
+		c.cancel = nil
+	}
+	return c.wait(ctx)
+}
+
+// wait waits for the last loop to return, the lock must be held
+func (c *consumer) wait(ctx context.Context) error {
+	if c.done == nil {
+		return nil
+	}
+	select {
+	case <-c.done:
+		c.done = nil
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// StopIntake cancels the loop and waits for it to return
+func (c *consumer) StopIntake(ctx context.Context) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.stopped = true
+	return c.halt(ctx)
+}
+
+func (c *consumer) Stop(ctx context.Context) error {
+	return c.StopIntake(ctx)
+}
+
+// Pause cancels the loop until Resume is called, the messages are left in the stream meanwhile.
+// The consumer is paused even if the loop doesn't return before ctx is done, a later Pause waits for it again
+func (c *consumer) Pause(ctx context.Context) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.stopped {
+		return ErrStopped
+	}
+	if c.paused {
+		return c.wait(ctx)
+	}
+	c.paused = true
+	zap.L().Info("consumer paused", zap.String("component", c.name))
+	return c.halt(ctx)
+}
+
+// Resume starts the loop again after Pause
+func (c *consumer) Resume(ctx context.Context) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.stopped {
+		return ErrStopped
+	}
+	if !c.paused {
+		return nil
+	}
+	// the paused loop may not have returned yet, two loops mustn't consume at once
+	if err := c.wait(ctx); err != nil {
+		return err
+	}
+	c.paused = false
+	c.run()
+	zap.L().Info("consumer resumed", zap.String("component", c.name))
+	return nil
+}
+
+func (c *consumer) Paused() bool {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.paused
+}
@@ -0,0 +1,53 @@
+package system
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestConsumerPausedWhileLoopReturnsLate(t *testing.T) {
+	var runs atomic.Int32
+	release := make(chan struct{})
+	c := NewConsumer("consumer", func(ctx context.Context) error {
+		if runs.Add(1) == 1 {
+			// the first loop ignores the cancellation for a while
+			<-release
+		}
+		<-ctx.Done()
+		return ctx.Err()
+	}).(*consumer)
+	if err := c.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Stop(context.Background())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := c.Pause(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("the pause should time out, got %v", err)
+	}
+	if !c.Paused() {
+		t.Fatal("the consumer should be paused once its loop is canceled")
+	}
+
+	ctx, cancel = context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := c.Resume(ctx); !errors.Is(err, context.DeadlineExceeded) || !c.Paused() {
+		t.Fatalf("the resume should wait for the paused loop, got %v", err)
+	}
+
+	close(release)
+	if err := c.Resume(context.Background()); err != nil || c.Paused() {
+		t.Fatalf("the consumer should be resumed, got %v", err)
+	}
+	deadline := time.Now().Add(time.Second)
+	for runs.Load() != 2 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if runs.Load() != 2 {
+		t.Fatalf("the loop should run again once, runs %d", runs.Load())
+	}
+}
@@ -0,0 +1,79 @@
+package system
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+	"net"
+	"net/http"
+	"time"
+)
+
+// startHttpServer creates the gin engine, calls the router setup callback if any and serves in background.
+// The address is bound before returning so that an occupied port fails the startup.
+func (s *System) startHttpServer(params *StartupParams) error {
+	setting := params.Config.Http
+	if setting == nil {
+		return errors.New("the http setting is required to start the http server")
+	}
+
+	router := gin.New()
+	router.Use(gin.Recovery())
+	// the handlers get the system from the request context, see FromContext
+	router.Use(func(c *gin.Context) {
+		c.Request = c.Request.WithContext(NewContext(c.Request.Context(), s))
+		c.Next()
+	})
+	// the middleware is added before any route so that the probes are instrumented as well
+	s.mountTracing(router, params)
+	s.mountMetrics(router, params)
+	if s.Health != nil {
+		s.Health.Mount(router)
+	}
+	s.mountAdmin(router, params)
+	if params.SetupRouter != nil {
+		params.SetupRouter(router)
+	}
+
+	listener, err := net.Listen("tcp", fmt.Sprintf("%s:%d", setting.Address, setting.Port))
+	if err != nil {
+		return err
+	}
+
+	s.Router = router
+	s.httpListener = listener
+	s.HttpServer = &http.Server{
+		Handler:      router,
+		ReadTimeout:  time.Duration(setting.ReadTimeoutSeconds) * time.Second,
+		WriteTimeout: time.Duration(setting.WriteTimeoutSeconds) * time.Second,
+		IdleTimeout:  time.Duration(setting.IdleTimeoutSeconds) * time.Second,
+	}
+
+	server := s.HttpServer
+	go func() {
+		zap.L().Info("http server is listening", zap.String("address", listener.Addr().String()))
+		if err := server.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			zap.L().Error("http server stopped unexpectedly", zap.Error(err))
+		}
+	}()
+	return nil
+}
+
+// stopHttpServer stops accepting new connections and waits for the in-flight requests until the context
+// is done, the timeout of the http component is ShutdownTimeoutSeconds
+func (s *System) stopHttpServer(ctx context.Context) error {
+	if s.HttpServer == nil {
+		return nil
+	}
+	err := s.HttpServer.Shutdown(ctx)
+
+	// the listener isn't closed by Shutdown if Serve hasn't been called yet, so the port is released here
+	_ = s.httpListener.Close()
+	if err != nil {
+		return err
+	}
+	zap.S().Info("http server stopped")
+	return nil
+}
@@ -0,0 +1,50 @@
+package system
+
+import (
+	"context"
+	"github.com/gin-gonic/gin"
+	"github.com/jeven2016/mylibs/metrics"
+)
+
+// setupMetrics reports the stats of the clients created by Startup
+func (s *System) setupMetrics() {
+	if s.RedisClient != nil {
+		redisClient := s.RedisClient
+		metrics.SetStreamStats(func(ctx context.Context) ([]metrics.StreamGroupStat, error) {
+			stats, err := redisClient.StreamStats(ctx)
+			if err != nil {
+				return nil, err
+			}
+			groupStats := make([]metrics.StreamGroupStat, 0, len(stats))
+			for _, stat := range stats {
+				groupStats = append(groupStats, metrics.StreamGroupStat{
+					Stream:  stat.Stream,
+					Group:   stat.Group,
+					Pending: stat.Pending,
+					Lag:     stat.Lag,
+				})
+			}
+			return groupStats, nil
+		})
+	}
+	if s.Pools != nil {
+		s.Pools.registerMetrics()
+	}
+}
+
+// mountMetrics observes the requests and serves the metrics on the router
+func (s *System) mountMetrics(router *gin.Engine, params *StartupParams) {
+	setting := params.Config.Metrics
+	if setting == nil || !setting.Enabled {
+		return
+	}
+	router.Use(metrics.GinMiddleware())
+	router.GET(setting.Path, gin.WrapH(metrics.Handler()))
+}
+
+func (s *System) teardownMetrics() {
+	metrics.SetStreamStats(nil)
+	if s.Pools != nil {
+		s.Pools.unregisterMetrics()
+	}
+}
@@ -0,0 +1,153 @@
+package system
+
+import (
+	"errors"
+	"fmt"
+	"github.com/jeven2016/mylibs/config"
+	"github.com/jeven2016/mylibs/metrics"
+	"github.com/panjf2000/ants/v2"
+	"go.uber.org/zap"
+	"sort"
+	"time"
+)
+
+const (
+	// DefaultPoolName is the name of System.TaskPool
+	DefaultPoolName = "default"
+	// SystemPoolName is the name of the pool reserved for the system tasks
+	SystemPoolName = "system"
+)
+
+// ErrUnknownPool is returned if a task is submitted to a pool which isn't configured
+var ErrUnknownPool = errors.New("unknown task pool")
+
+// TaskPools holds the default pool, the reserved system pool and the named pools of TaskPoolSetting
+type TaskPools struct {
+	pools map[string]*ants.Pool
+}
+
+// PoolStat is the usage of a pool
+type PoolStat struct {
+	Name     string `json:"name"`
+	Capacity int    `json:"capacity"`
+	Running  int    `json:"running"`
+	Free     int    `json:"free"`
+	Waiting  int    `json:"waiting"`
+}
+
+func newTaskPools(setting *config.TaskPoolSetting) (*TaskPools, error) {
+	settings := []config.NamedPoolSetting{
+		{
+			Name:             DefaultPoolName,
+			Capacity:         setting.Capacity,
+			Nonblocking:      setting.Nonblocking,
+			ExpirySeconds:    setting.ExpirySeconds,
+			MaxBlockingTasks: setting.MaxBlockingTasks,
+		},
+		{Name: SystemPoolName, Capacity: setting.ReservedCapacity},
+	}
+	settings = append(settings, setting.Pools...)
+
+	p := &TaskPools{pools: map[string]*ants.Pool{}}
+	for _, s := range settings {
+		if _, ok := p.pools[s.Name]; ok {
+			p.release()
+			return nil, fmt.Errorf("the task pool %s is duplicated", s.Name)
+		}
+		pool, err := newPool(s)
+		if err != nil {
+			p.release()
+			return nil, fmt.Errorf("unable to create task pool %s: %w", s.Name, err)
+		}
+		p.pools[s.Name] = pool
+	}
+	return p, nil
+}
+
+func newPool(setting config.NamedPoolSetting) (*ants.Pool, error) {
+	name := setting.Name
+	opts := []ants.Option{
+		ants.WithNonblocking(setting.Nonblocking),
+		ants.WithMaxBlockingTasks(setting.MaxBlockingTasks),
+		ants.WithPanicHandler(func(p any) {
+			zap.L().Error("a task panics", zap.String("pool", name), zap.Any("panic", p), zap.StackSkip("stack", 2))
+		}),
+	}
+	if setting.ExpirySeconds > 0 {
+		opts = append(opts, ants.WithExpiryDuration(time.Duration(setting.ExpirySeconds)*time.Second))
+	}
+	return ants.NewPool(setting.Capacity, opts...)
+}
+
+// Get returns the pool of the name, nil is returned if it isn't configured
+func (p *TaskPools) Get(name string) *ants.Pool {
+	if p == nil {
+		return nil
+	}
+	return p.pools[name]
+}
+
+// Submit runs the task on the pool of the name
+func (p *TaskPools) Submit(name string, task func()) error {
+	pool := p.Get(name)
+	if pool == nil {
+		return fmt.Errorf("%w: %s", ErrUnknownPool, name)
+	}
+	return pool.Submit(task)
+}
+
+// Names returns the names of the pools in order
+func (p *TaskPools) Names() []string {
+	if p == nil {
+		return nil
+	}
+	names := make([]string, 0, len(p.pools))
+	for name := range p.pools {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Stats returns the usage of the pools in the order of their names
+func (p *TaskPools) Stats() []PoolStat {
+	var stats []PoolStat
+	for _, name := range p.Names() {
+		pool := p.pools[name]
+		stats = append(stats, PoolStat{
+			Name:     name,
+			Capacity: pool.Cap(),
+			Running:  pool.Running(),
+			Free:     pool.Free(),
+			Waiting:  pool.Waiting(),
+		})
+	}
+	return stats
+}
+
+// busy returns the number of the running and waiting tasks of all pools
+func (p *TaskPools) busy() int {
+	count := 0
+	for _, stat := range p.Stats() {
+		count += stat.Running + stat.Waiting
+	}
+	return count
+}
+
+func (p *TaskPools) registerMetrics() {
+	for name, pool := range p.pools {
+		metrics.RegisterTaskPool(name, pool)
+	}
+}
+
+func (p *TaskPools) unregisterMetrics() {
+	for name := range p.pools {
+		metrics.UnregisterTaskPool(name)
+	}
+}
+
+func (p *TaskPools) release() {
+	for _, pool := range p.pools {
+		pool.Release()
+	}
+}
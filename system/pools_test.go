@@ -0,0 +1,86 @@
+package system
+
+import (
+	"errors"
+	"github.com/jeven2016/mylibs/config"
+	"github.com/panjf2000/ants/v2"
+	"sync"
+	"testing"
+)
+
+func TestTaskPools(t *testing.T) {
+	pools, err := newTaskPools(&config.TaskPoolSetting{
+		Capacity:         10,
+		ReservedCapacity: 2,
+		Pools: []config.NamedPoolSetting{
+			{Name: "chapter", Capacity: 1, Nonblocking: true},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pools.release()
+
+	if names := pools.Names(); len(names) != 3 || pools.Get(SystemPoolName).Cap() != 2 {
+		t.Fatalf("unexpected pools: %v", names)
+	}
+
+	// the nonblocking pool rejects the task once it's full
+	release := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	if err = pools.Submit("chapter", func() {
+		defer wg.Done()
+		<-release
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err = pools.Submit("chapter", func() {}); !errors.Is(err, ants.ErrPoolOverload) {
+		t.Fatalf("the full pool should reject the task: %v", err)
+	}
+	close(release)
+	wg.Wait()
+
+	if err = pools.Submit("missing", func() {}); !errors.Is(err, ErrUnknownPool) {
+		t.Fatalf("expected ErrUnknownPool, got %v", err)
+	}
+}
+
+func TestTaskPoolPanicIsCaptured(t *testing.T) {
+	pools, err := newTaskPools(&config.TaskPoolSetting{Capacity: 1, ReservedCapacity: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pools.release()
+
+	if err = pools.Submit(DefaultPoolName, func() { panic("broken page") }); err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan struct{})
+	if err = pools.Submit(DefaultPoolName, func() { close(done) }); err != nil {
+		t.Fatal(err)
+	}
+	<-done
+}
+
+func TestNamedPoolValidation(t *testing.T) {
+	duplicated := &config.TaskPoolSetting{
+		Capacity:         1,
+		ReservedCapacity: 1,
+		Pools:            []config.NamedPoolSetting{{Name: "chapter", Capacity: 1}, {Name: "chapter", Capacity: 2}},
+	}
+	if err := config.ValidateStruct(duplicated); err == nil {
+		t.Fatal("the duplicated names should be rejected")
+	}
+
+	reserved := &config.TaskPoolSetting{
+		Capacity:         1,
+		ReservedCapacity: 1,
+		Pools:            []config.NamedPoolSetting{{Name: SystemPoolName, Capacity: 1}},
+	}
+	var validationErr *config.ValidationError
+	if err := config.ValidateStruct(reserved); !errors.As(err, &validationErr) ||
+		validationErr.Fields[0].Path != "pools[0].name" {
+		t.Fatalf("the reserved name should be rejected: %v", err)
+	}
+}
@@ -0,0 +1,276 @@
+package system
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	clientv3 "go.etcd.io/etcd/client/v3"
+	"go.uber.org/zap"
+	"sort"
+	"sync"
+	"time"
+)
+
+// ServicePrefix is the etcd key prefix of the registered services, the key of an instance is
+// /services/<service name>/<host:port> and the value is the address
+const ServicePrefix = "/services/"
+
+const (
+	defaultRefreshSeconds        = 10
+	defaultConnectTimeoutSeconds = 5
+	reRegisterInterval           = 3 * time.Second
+)
+
+type RegisterParam struct {
+	ServiceName           string
+	ServiceHost           string
+	ServicePort           uint
+	RefreshSeconds        uint
+	ConnectTimeoutSeconds uint
+}
+
+func (p *RegisterParam) address() string {
+	return fmt.Sprintf("%s:%d", p.ServiceHost, p.ServicePort)
+}
+
+func (p *RegisterParam) key() string {
+	return ServiceKey(p.ServiceName, p.address())
+}
+
+// ServiceKey returns the etcd key of a service instance
+func ServiceKey(serviceName string, address string) string {
+	return ServicePrefix + serviceName + "/" + address
+}
+
+// Register registers the service in etcd with a lease kept alive in background, and caches the addresses
+// of the other services by watching their keys
+type Register struct {
+	client *clientv3.Client
+	param  *RegisterParam
+	// the keepalive and the watches run until the register is canceled
+	routines sync.WaitGroup
+
+	leaseLock sync.Mutex
+	leaseID   clientv3.LeaseID
+	ctx       context.Context
+	cancel    context.CancelFunc
+
+	cacheLock sync.RWMutex
+	caches    map[string]*serviceCache
+}
+
+// serviceCache holds the addresses of a service, it's updated by a watch
+type serviceCache struct {
+	addresses map[string]string
+	ready     chan struct{}
+	err       error
+}
+
+// NewRegister connects to etcd, the routines keeping the lease and watching the services run in their own
+// goroutines rather than a task pool since they last until Cancel is called
+func NewRegister(endpoints []string, param *RegisterParam) (*Register, error) {
+	if len(endpoints) == 0 {
+		return nil, errors.New("the etcd endpoints are required")
+	}
+	if param == nil || param.ServiceName == "" {
+		return nil, errors.New("the service name is required")
+	}
+	if param.RefreshSeconds == 0 {
+		param.RefreshSeconds = defaultRefreshSeconds
+	}
+	if param.ConnectTimeoutSeconds == 0 {
+		param.ConnectTimeoutSeconds = defaultConnectTimeoutSeconds
+	}
+
+	client, err := clientv3.New(clientv3.Config{
+		Endpoints:   endpoints,
+		DialTimeout: time.Duration(param.ConnectTimeoutSeconds) * time.Second,
+		Logger:      zap.L().Named("etcd"),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Register{
+		client: client,
+		param:  param,
+		ctx:    ctx,
+		cancel: cancel,
+		caches: map[string]*serviceCache{},
+	}, nil
+}
+
+// Register puts the service key with a lease of RefreshSeconds, the lease is kept alive until Cancel
+// is called and the key is registered again if the lease is lost, e.g. etcd restarts
+func (r *Register) Register(ctx context.Context) error {
+	keepAliveChan, err := r.grantAndPut(ctx)
+	if err != nil {
+		return err
+	}
+	r.spawn(func() {
+		r.keepAlive(keepAliveChan)
+	})
+	return nil
+}
+
+func (r *Register) grantAndPut(ctx context.Context) (<-chan *clientv3.LeaseKeepAliveResponse, error) {
+	timeoutCtx, cancel := context.WithTimeout(ctx, time.Duration(r.param.ConnectTimeoutSeconds)*time.Second)
+	defer cancel()
+
+	lease, err := r.client.Grant(timeoutCtx, int64(r.param.RefreshSeconds))
+	if err != nil {
+		return nil, err
+	}
+	if _, err = r.client.Put(timeoutCtx, r.param.key(), r.param.address(), clientv3.WithLease(lease.ID)); err != nil {
+		return nil, err
+	}
+	keepAliveChan, err := r.client.KeepAlive(r.ctx, lease.ID)
+	if err != nil {
+		return nil, err
+	}
+	r.leaseLock.Lock()
+	r.leaseID = lease.ID
+	r.leaseLock.Unlock()
+	return keepAliveChan, nil
+}
+
+func (r *Register) keepAlive(keepAliveChan <-chan *clientv3.LeaseKeepAliveResponse) {
+	for {
+		// the channel is drained by the loop, it's closed once the lease expires or the register is canceled
+		for range keepAliveChan {
+		}
+
+		select {
+		case <-r.ctx.Done():
+			return
+		case <-time.After(reRegisterInterval):
+		}
+
+		zap.L().Warn("the lease of service is lost, registering again", zap.String("key", r.param.key()))
+		var err error
+		if keepAliveChan, err = r.grantAndPut(r.ctx); err != nil {
+			zap.L().Warn("failed to register service in etcd", zap.String("key", r.param.key()), zap.Error(err))
+			keepAliveChan = closedKeepAliveChan()
+		}
+	}
+}
+
+func closedKeepAliveChan() <-chan *clientv3.LeaseKeepAliveResponse {
+	ch := make(chan *clientv3.LeaseKeepAliveResponse)
+	close(ch)
+	return ch
+}
+
+// Cancel stops the keepalive, revokes the lease so that the service key is removed at once, and closes
+// the connection
+func (r *Register) Cancel(ctx context.Context) error {
+	r.cancel()
+
+	r.leaseLock.Lock()
+	leaseID := r.leaseID
+	r.leaseLock.Unlock()
+
+	var err error
+	if leaseID != 0 {
+		timeoutCtx, cancel := context.WithTimeout(ctx, time.Duration(r.param.ConnectTimeoutSeconds)*time.Second)
+		_, err = r.client.Revoke(timeoutCtx, leaseID)
+		cancel()
+	}
+	if closeErr := r.client.Close(); closeErr != nil && err == nil {
+		err = closeErr
+	}
+	// the keepalive and watch channels are closed along with the connection
+	r.routines.Wait()
+	return err
+}
+
+// ListServiceAddresses returns the addresses of a service, the first call loads the addresses from etcd
+// and starts a watch that keeps the local cache up to date
+func (r *Register) ListServiceAddresses(ctx context.Context, serviceName string) ([]string, error) {
+	r.cacheLock.Lock()
+	cache, ok := r.caches[serviceName]
+	if !ok {
+		cache = &serviceCache{addresses: map[string]string{}, ready: make(chan struct{})}
+		r.caches[serviceName] = cache
+		r.spawn(func() { r.watchService(serviceName, cache) })
+	}
+	r.cacheLock.Unlock()
+
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-cache.ready:
+	}
+
+	r.cacheLock.RLock()
+	defer r.cacheLock.RUnlock()
+	if cache.err != nil {
+		return nil, cache.err
+	}
+	addresses := make([]string, 0, len(cache.addresses))
+	for _, addr := range cache.addresses {
+		addresses = append(addresses, addr)
+	}
+	sort.Strings(addresses)
+	return addresses, nil
+}
+
+func (r *Register) watchService(serviceName string, cache *serviceCache) {
+	prefix := ServicePrefix + serviceName + "/"
+	resp, err := r.client.Get(r.ctx, prefix, clientv3.WithPrefix())
+	if err != nil {
+		r.cacheLock.Lock()
+		cache.err = err
+		// the failed cache is dropped so that the next call retries
+		delete(r.caches, serviceName)
+		r.cacheLock.Unlock()
+		close(cache.ready)
+		return
+	}
+
+	r.cacheLock.Lock()
+	for _, kv := range resp.Kvs {
+		cache.addresses[string(kv.Key)] = string(kv.Value)
+	}
+	r.cacheLock.Unlock()
+	close(cache.ready)
+
+	watchChan := r.client.Watch(r.ctx, prefix, clientv3.WithPrefix(), clientv3.WithRev(resp.Header.Revision+1))
+	for watchResp := range watchChan {
+		if watchResp.Err() != nil {
+			zap.L().Warn("an error occurs while watching service", zap.String("service", serviceName),
+				zap.Error(watchResp.Err()))
+			continue
+		}
+		r.cacheLock.Lock()
+		for _, event := range watchResp.Events {
+			key := string(event.Kv.Key)
+			switch event.Type {
+			case clientv3.EventTypePut:
+				cache.addresses[key] = string(event.Kv.Value)
+			case clientv3.EventTypeDelete:
+				delete(cache.addresses, key)
+			}
+		}
+		r.cacheLock.Unlock()
+	}
+
+	// the watch is closed, the cache is dropped unless the register is canceled
+	if r.ctx.Err() == nil {
+		r.cacheLock.Lock()
+		if r.caches[serviceName] == cache {
+			delete(r.caches, serviceName)
+		}
+		r.cacheLock.Unlock()
+	}
+}
+
+// spawn runs a routine that returns once the register is canceled
+func (r *Register) spawn(routine func()) {
+	r.routines.Add(1)
+	go func() {
+		defer r.routines.Done()
+		routine()
+	}()
+}
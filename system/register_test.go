@@ -0,0 +1,130 @@
+package system
+
+import (
+	"context"
+	"github.com/jeven2016/mylibs/config"
+	"go.etcd.io/etcd/server/v3/embed"
+	"net/url"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func startEtcd(t *testing.T) string {
+	t.Helper()
+	cfg := embed.NewConfig()
+	cfg.Dir = t.TempDir()
+	cfg.LogLevel = "error"
+	clientUrl, _ := url.Parse("http://127.0.0.1:0")
+	peerUrl, _ := url.Parse("http://127.0.0.1:0")
+	cfg.ListenClientUrls = []url.URL{*clientUrl}
+	cfg.ListenPeerUrls = []url.URL{*peerUrl}
+
+	etcd, err := embed.StartEtcd(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(etcd.Close)
+
+	select {
+	case <-etcd.Server.ReadyNotify():
+	case <-time.After(10 * time.Second):
+		t.Fatal("etcd is not ready")
+	}
+	return etcd.Clients[0].Addr().String()
+}
+
+func TestRegisterAndDiscoverServices(t *testing.T) {
+	endpoint := startEtcd(t)
+	ctx := context.Background()
+
+	api1, err := NewRegister([]string{endpoint}, &RegisterParam{ServiceName: "novel-api", ServiceHost: "10.0.0.1",
+		ServicePort: 8080, RefreshSeconds: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = api1.Register(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	crawler, err := NewRegister([]string{endpoint}, &RegisterParam{ServiceName: "crawler", ServiceHost: "10.0.0.9",
+		ServicePort: 9000})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer crawler.Cancel(ctx)
+
+	addresses, err := crawler.ListServiceAddresses(ctx, "novel-api")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(addresses, []string{"10.0.0.1:8080"}) {
+		t.Fatalf("unexpected addresses: %v", addresses)
+	}
+
+	// the lease is kept alive longer than its ttl
+	time.Sleep(3 * time.Second)
+
+	api2, err := NewRegister([]string{endpoint}, &RegisterParam{ServiceName: "novel-api", ServiceHost: "10.0.0.2",
+		ServicePort: 8080, RefreshSeconds: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = api2.Register(ctx); err != nil {
+		t.Fatal(err)
+	}
+	waitForAddresses(t, crawler, []string{"10.0.0.1:8080", "10.0.0.2:8080"})
+
+	// the key is removed at once after deregistration
+	if err = api1.Cancel(ctx); err != nil {
+		t.Fatal(err)
+	}
+	waitForAddresses(t, crawler, []string{"10.0.0.2:8080"})
+	if err = api2.Cancel(ctx); err != nil {
+		t.Fatal(err)
+	}
+	waitForAddresses(t, crawler, []string{})
+}
+
+func waitForAddresses(t *testing.T, register *Register, expected []string) {
+	t.Helper()
+	var addresses []string
+	for i := 0; i < 50; i++ {
+		var err error
+		if addresses, err = register.ListServiceAddresses(context.Background(), "novel-api"); err != nil {
+			t.Fatal(err)
+		}
+		if reflect.DeepEqual(addresses, expected) {
+			return
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	t.Fatalf("expected addresses %v, got %v", expected, addresses)
+}
+
+func TestRegisterDoesNotHoldPoolWorkers(t *testing.T) {
+	endpoint := startEtcd(t)
+	cfg := shutdownConfig(t, 5)
+	cfg.Http = &config.HttpSetting{Address: "127.0.0.1", Port: freePort(t)}
+	cfg.Registration = &config.Registration{Etcd: config.EtcdConfig{Endpoints: []string{endpoint},
+		RefreshSeconds: 2, ConnectTimeoutSeconds: 2}}
+	sys, err := Startup(context.Background(), &StartupParams{Config: cfg, EnableEtcd: true, DisableGlobal: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = sys.ServiceRegister.ListServiceAddresses(context.Background(), "test-app"); err != nil {
+		t.Fatal(err)
+	}
+
+	// the keepalive and the watch run until the register is canceled, they aren't counted as in-flight tasks
+	if busy := sys.Pools.busy(); busy != 0 {
+		t.Fatalf("the pools shouldn't be busy, %d tasks", busy)
+	}
+	start := time.Now()
+	if err = sys.Stop(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed > 3*time.Second {
+		t.Fatalf("the stop shouldn't wait for the grace period, elapsed %v", elapsed)
+	}
+}
@@ -0,0 +1,75 @@
+package system
+
+import (
+	"context"
+	"go.uber.org/zap"
+	"time"
+)
+
+const (
+	defaultRetryInitialBackoff = time.Second
+	defaultRetryMaxBackoff     = 30 * time.Second
+	defaultRetryTimeout        = 2 * time.Minute
+)
+
+// RetryPolicy retries connecting to redis and mongodb while starting, the backoff doubles after each
+// failed attempt until MaxBackoff
+type RetryPolicy struct {
+	// MaxAttempts is the number of attempts including the first one, 0 means retrying until Timeout
+	MaxAttempts    int
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+	// Timeout is the overall time of the attempts, it's the start timeout of the component
+	Timeout time.Duration
+}
+
+func (p *RetryPolicy) timeout() time.Duration {
+	if p == nil {
+		return defaultStartTimeout
+	}
+	if p.Timeout <= 0 {
+		return defaultRetryTimeout
+	}
+	return p.Timeout
+}
+
+// retry calls fn until it succeeds, the attempts run out or the context is done, the last error of fn is
+// returned. fn is called once if the policy is nil.
+func retry(ctx context.Context, policy *RetryPolicy, component string, fn func(ctx context.Context) error) error {
+	if policy == nil {
+		return fn(ctx)
+	}
+
+	backoff := policy.InitialBackoff
+	if backoff <= 0 {
+		backoff = defaultRetryInitialBackoff
+	}
+	maxBackoff := policy.MaxBackoff
+	if maxBackoff <= 0 {
+		maxBackoff = defaultRetryMaxBackoff
+	}
+
+	for attempt := 1; ; attempt++ {
+		err := fn(ctx)
+		if err == nil {
+			return nil
+		}
+		if policy.MaxAttempts > 0 && attempt >= policy.MaxAttempts {
+			return err
+		}
+
+		zap.L().Warn("failed to connect, retrying", zap.String("component", component),
+			zap.Int("attempt", attempt), zap.Duration("backoff", backoff), zap.Error(err))
+		timer := time.NewTimer(backoff)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return err
+		case <-timer.C:
+		}
+
+		if backoff *= 2; backoff > maxBackoff {
+			backoff = maxBackoff
+		}
+	}
+}
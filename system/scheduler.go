@@ -0,0 +1,427 @@
+package system
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
+	"github.com/jeven2016/mylibs/config"
+	"github.com/redis/go-redis/v9"
+	"github.com/robfig/cron/v3"
+	"go.uber.org/zap"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	defaultHistorySize = 20
+	// defaultLockTTL is the ttl of the lock of a single-instance job without timeout
+	defaultLockTTL = 10 * time.Minute
+	// lockMargin is how long before the next tick the lock of a run is released, so that the instance
+	// holding it fires the next tick on time
+	lockMargin = time.Second
+)
+
+// ErrUnknownJob is returned if a job isn't scheduled
+var ErrUnknownJob = errors.New("unknown job")
+
+// the cron expressions have 5 fields with an optional leading seconds field, the descriptors such as
+// @hourly and @every 1m are accepted as well
+var cronParser = cron.NewParser(cron.SecondOptional | cron.Minute | cron.Hour | cron.Dom | cron.Month |
+	cron.Dow | cron.Descriptor)
+
+// JobFunc is the function of a job, ctx is canceled once the timeout of the job elapses or the scheduler stops
+type JobFunc func(ctx context.Context) error
+
+type JobStatus string
+
+const (
+	JobSucceeded JobStatus = "succeeded"
+	JobFailed    JobStatus = "failed"
+	// JobSkipped means the previous run was still running or another replica held the lock
+	JobSkipped JobStatus = "skipped"
+)
+
+// JobRun is a run of a job
+type JobRun struct {
+	StartedAt time.Time     `json:"startedAt"`
+	Duration  time.Duration `json:"duration"`
+	Status    JobStatus     `json:"status"`
+	Error     string        `json:"error,omitempty"`
+}
+
+// JobState is the state of a job, History holds the latest runs with the newest first
+type JobState struct {
+	Name           string    `json:"name"`
+	Schedule       string    `json:"schedule"`
+	SingleInstance bool      `json:"singleInstance"`
+	Running        bool      `json:"running"`
+	NextRun        time.Time `json:"nextRun"`
+	LastError      string    `json:"lastError,omitempty"`
+	LastErrorAt    time.Time `json:"lastErrorAt,omitempty"`
+	History        []JobRun  `json:"history"`
+}
+
+// Locker acquires the locks of the single-instance jobs. Unlock is called once the run completes, the lock
+// should be kept for hold then so that the replicas firing the same tick later don't run the job again.
+type Locker interface {
+	TryLock(ctx context.Context, key string, ttl time.Duration) (unlock func(hold time.Duration), ok bool, err error)
+}
+
+// releaseScript sets the ttl of the lock to the hold if it's still held by the token
+var releaseScript = redis.NewScript(`
+if redis.call("get", KEYS[1]) == ARGV[1] then
+	return redis.call("pexpire", KEYS[1], ARGV[2])
+end
+return 0`)
+
+type redisLocker struct {
+	client *redis.Client
+}
+
+// NewRedisLocker creates a Locker holding the locks in redis by SET NX, a lock is only released by the
+// instance holding it
+func NewRedisLocker(client *redis.Client) Locker {
+	return &redisLocker{client: client}
+}
+
+func (l *redisLocker) TryLock(ctx context.Context, key string, ttl time.Duration) (func(time.Duration), bool, error) {
+	token := uuid.NewString()
+	ok, err := l.client.SetNX(ctx, key, token, ttl).Result()
+	if err != nil || !ok {
+		return nil, false, err
+	}
+	return func(hold time.Duration) {
+		if hold < time.Millisecond {
+			hold = time.Millisecond
+		}
+		// the context of the run may be canceled already
+		err := releaseScript.Run(context.Background(), l.client, []string{key}, token, hold.Milliseconds()).Err()
+		if err != nil {
+			zap.L().Warn("failed to release the lock of job", zap.String("key", key), zap.Error(err))
+		}
+	}, true, nil
+}
+
+type job struct {
+	name           string
+	spec           string
+	schedule       cron.Schedule
+	fn             JobFunc
+	singleInstance bool
+	timeout        time.Duration
+	entryID        cron.EntryID
+
+	running atomic.Bool
+
+	// guarded by Scheduler.lock
+	history     []JobRun
+	lastError   string
+	lastErrorAt time.Time
+}
+
+type JobOption func(j *job)
+
+// SingleInstance runs the job on one replica at a time by the Locker of the scheduler
+func SingleInstance() JobOption {
+	return func(j *job) {
+		j.singleInstance = true
+	}
+}
+
+// WithJobTimeout cancels the context of a run after the timeout, it's the ttl of the lock as well
+func WithJobTimeout(timeout time.Duration) JobOption {
+	return func(j *job) {
+		j.timeout = timeout
+	}
+}
+
+type SchedulerOption func(s *Scheduler)
+
+// WithLocker sets the Locker of the single-instance jobs, the keys are prefixed by keyPrefix
+func WithLocker(locker Locker, keyPrefix string) SchedulerOption {
+	return func(s *Scheduler) {
+		s.locker = locker
+		s.keyPrefix = keyPrefix
+	}
+}
+
+// WithHistorySize sets the number of runs kept for each job
+func WithHistorySize(size int) SchedulerOption {
+	return func(s *Scheduler) {
+		if size > 0 {
+			s.historySize = size
+		}
+	}
+}
+
+// Scheduler runs the jobs by cron expressions or fixed intervals. A run is skipped if the previous one is
+// still running, so a job never overlaps itself.
+type Scheduler struct {
+	cron        *cron.Cron
+	submit      func(name string, task func()) error
+	locker      Locker
+	keyPrefix   string
+	historySize int
+
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	lock sync.RWMutex
+	jobs map[string]*job
+	// the names in scheduling order
+	names []string
+}
+
+// NewScheduler creates a scheduler submitting the runs by submit, e.g. System.Submit. The runs are started
+// in their own goroutines if submit is nil.
+func NewScheduler(submit func(name string, task func()) error, opts ...SchedulerOption) *Scheduler {
+	ctx, cancel := context.WithCancel(context.Background())
+	s := &Scheduler{
+		cron:        cron.New(cron.WithParser(cronParser)),
+		submit:      submit,
+		historySize: defaultHistorySize,
+		ctx:         ctx,
+		cancel:      cancel,
+		jobs:        map[string]*job{},
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
+}
+
+// ScheduleSpec returns the spec of a configured schedule, the interval is used if the cron is empty
+func ScheduleSpec(schedule config.JobSchedule) string {
+	if schedule.Cron != "" {
+		return schedule.Cron
+	}
+	return fmt.Sprintf("@every %ds", schedule.IntervalSeconds)
+}
+
+// Schedule adds a job, spec is a cron expression or a fixed interval like @every 30s
+func (s *Scheduler) Schedule(name string, spec string, fn JobFunc, opts ...JobOption) error {
+	if name == "" || fn == nil {
+		return errors.New("the name and the function of a job are required")
+	}
+	schedule, err := cronParser.Parse(spec)
+	if err != nil {
+		return fmt.Errorf("invalid schedule %q of job %s: %w", spec, name, err)
+	}
+
+	j := &job{name: name, spec: spec, schedule: schedule, fn: fn}
+	for _, opt := range opts {
+		opt(j)
+	}
+	if j.singleInstance && s.locker == nil {
+		return fmt.Errorf("job %s is single-instance but the scheduler has no locker", name)
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if _, ok := s.jobs[name]; ok {
+		return fmt.Errorf("job %s is already scheduled", name)
+	}
+	j.entryID = s.cron.Schedule(schedule, cron.FuncJob(func() { s.trigger(j) }))
+	s.jobs[name] = j
+	s.names = append(s.names, name)
+	return nil
+}
+
+// ScheduleConfig adds the enabled schedules of the setting, the functions are looked up by job name
+func (s *Scheduler) ScheduleConfig(setting *config.SchedulerSetting, jobs map[string]JobFunc) error {
+	if setting == nil {
+		return nil
+	}
+	for _, schedule := range setting.Jobs {
+		if schedule.Disabled {
+			continue
+		}
+		fn, ok := jobs[schedule.Name]
+		if !ok {
+			return fmt.Errorf("%w: %s isn't provided", ErrUnknownJob, schedule.Name)
+		}
+		var opts []JobOption
+		if schedule.SingleInstance {
+			opts = append(opts, SingleInstance())
+		}
+		if schedule.TimeoutSeconds > 0 {
+			opts = append(opts, WithJobTimeout(time.Duration(schedule.TimeoutSeconds)*time.Second))
+		}
+		if err := s.Schedule(schedule.Name, ScheduleSpec(schedule), fn, opts...); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Trigger runs a job at once regardless of its schedule, the run is skipped if the job is running
+func (s *Scheduler) Trigger(name string) error {
+	s.lock.RLock()
+	j, ok := s.jobs[name]
+	s.lock.RUnlock()
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrUnknownJob, name)
+	}
+	s.trigger(j)
+	return nil
+}
+
+// Jobs returns the states of the jobs in scheduling order
+func (s *Scheduler) Jobs() []JobState {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	states := make([]JobState, 0, len(s.names))
+	for _, name := range s.names {
+		states = append(states, s.state(s.jobs[name]))
+	}
+	return states
+}
+
+// Job returns the state of a job
+func (s *Scheduler) Job(name string) (JobState, bool) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	j, ok := s.jobs[name]
+	if !ok {
+		return JobState{}, false
+	}
+	return s.state(j), true
+}
+
+func (s *Scheduler) state(j *job) JobState {
+	history := make([]JobRun, len(j.history))
+	// the history is appended in order, the newest run is returned first
+	for i, run := range j.history {
+		history[len(j.history)-1-i] = run
+	}
+	// the next run is only known by cron once it's started
+	nextRun := s.cron.Entry(j.entryID).Next
+	if nextRun.IsZero() {
+		nextRun = j.schedule.Next(time.Now())
+	}
+	return JobState{
+		Name:           j.name,
+		Schedule:       j.spec,
+		SingleInstance: j.singleInstance,
+		Running:        j.running.Load(),
+		NextRun:        nextRun,
+		LastError:      j.lastError,
+		LastErrorAt:    j.lastErrorAt,
+		History:        history,
+	}
+}
+
+// Start starts firing the schedules
+func (s *Scheduler) Start() {
+	s.cron.Start()
+}
+
+// StopIntake stops firing the schedules, the runs already submitted go on
+func (s *Scheduler) StopIntake(ctx context.Context) error {
+	select {
+	case <-s.cron.Stop().Done():
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Stop stops the schedules and cancels the context of the running jobs
+func (s *Scheduler) Stop(ctx context.Context) error {
+	err := s.StopIntake(ctx)
+	s.cancel()
+	return err
+}
+
+func (s *Scheduler) trigger(j *job) {
+	if !j.running.CompareAndSwap(false, true) {
+		s.record(j, JobRun{StartedAt: time.Now(), Status: JobSkipped, Error: "the previous run is still running"})
+		return
+	}
+
+	task := func() {
+		defer j.running.Store(false)
+		s.run(j)
+	}
+	var err error
+	if s.submit == nil {
+		go task()
+	} else {
+		err = s.submit("job "+j.name, task)
+	}
+	if err != nil {
+		j.running.Store(false)
+		s.record(j, JobRun{StartedAt: time.Now(), Status: JobFailed, Error: fmt.Sprintf("failed to submit: %v", err)})
+	}
+}
+
+func (s *Scheduler) run(j *job) {
+	ctx := s.ctx
+	if j.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, j.timeout)
+		defer cancel()
+	}
+
+	startedAt := time.Now()
+	if j.singleInstance {
+		ttl := j.timeout
+		if ttl <= 0 {
+			ttl = defaultLockTTL
+		}
+		unlock, ok, err := s.locker.TryLock(ctx, s.keyPrefix+j.name, ttl)
+		if err != nil {
+			s.record(j, JobRun{StartedAt: startedAt, Status: JobFailed, Error: fmt.Sprintf("failed to lock: %v", err)})
+			return
+		}
+		if !ok {
+			s.record(j, JobRun{StartedAt: startedAt, Status: JobSkipped, Error: "the lock is held by another instance"})
+			return
+		}
+		defer func() {
+			unlock(lockHold(j, startedAt))
+		}()
+	}
+
+	err := s.call(ctx, j)
+	run := JobRun{StartedAt: startedAt, Duration: time.Since(startedAt), Status: JobSucceeded}
+	if err != nil {
+		run.Status = JobFailed
+		run.Error = err.Error()
+		zap.L().Warn("job failed", zap.String("job", j.name), zap.Error(err))
+	}
+	s.record(j, run)
+}
+
+// lockHold returns how long the lock of a run is kept, it's until the next tick. The replicas fire an
+// interval job at different times since the interval starts with each of them, so a shorter hold lets
+// another replica run the job again within the same interval.
+func lockHold(j *job, startedAt time.Time) time.Duration {
+	return time.Until(j.schedule.Next(startedAt)) - lockMargin
+}
+
+// call runs the job and turns a panic into an error so that it's recorded
+func (s *Scheduler) call(ctx context.Context, j *job) (err error) {
+	defer func() {
+		if p := recover(); p != nil {
+			zap.L().Error("job panics", zap.String("job", j.name), zap.Any("panic", p), zap.StackSkip("stack", 2))
+			err = fmt.Errorf("panic: %v", p)
+		}
+	}()
+	return j.fn(ctx)
+}
+
+func (s *Scheduler) record(j *job, run JobRun) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if len(j.history) >= s.historySize {
+		j.history = append(j.history[:0], j.history[len(j.history)-s.historySize+1:]...)
+	}
+	j.history = append(j.history, run)
+	if run.Status == JobFailed {
+		j.lastError = run.Error
+		j.lastErrorAt = run.StartedAt
+	}
+}
@@ -0,0 +1,227 @@
+package system
+
+import (
+	"context"
+	"errors"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/jeven2016/mylibs/config"
+	"github.com/redis/go-redis/v9"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// waitJob waits until the job has the number of runs in its history
+func waitJob(t *testing.T, s *Scheduler, name string, runs int) JobState {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		state, ok := s.Job(name)
+		if !ok {
+			t.Fatalf("job %s isn't scheduled", name)
+		}
+		if len(state.History) >= runs && !state.Running {
+			return state
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("job %s has %d runs, expected %d", name, len(state.History), runs)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestSchedulerPreventsOverlap(t *testing.T) {
+	s := NewScheduler(nil)
+	started := make(chan struct{})
+	release := make(chan struct{})
+	if err := s.Schedule("sync", "@hourly", func(ctx context.Context) error {
+		close(started)
+		<-release
+		return errors.New("site unavailable")
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.Trigger("sync"); err != nil {
+		t.Fatal(err)
+	}
+	<-started
+	// the second run is skipped while the first one is running
+	if err := s.Trigger("sync"); err != nil {
+		t.Fatal(err)
+	}
+	close(release)
+
+	state := waitJob(t, s, "sync", 2)
+	if state.History[0].Status != JobFailed || state.History[1].Status != JobSkipped {
+		t.Fatalf("unexpected history: %+v", state.History)
+	}
+	if state.LastError != "site unavailable" || state.NextRun.IsZero() {
+		t.Fatalf("unexpected state: %+v", state)
+	}
+
+	if err := s.Trigger("missing"); !errors.Is(err, ErrUnknownJob) {
+		t.Fatalf("expected ErrUnknownJob, got %v", err)
+	}
+}
+
+func TestSchedulerHistoryAndPanic(t *testing.T) {
+	s := NewScheduler(nil, WithHistorySize(2))
+	calls := 0
+	if err := s.Schedule("cleanup", "0 3 * * *", func(ctx context.Context) error {
+		calls++
+		if calls == 3 {
+			panic("broken")
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 1; i <= 2; i++ {
+		if err := s.Trigger("cleanup"); err != nil {
+			t.Fatal(err)
+		}
+		waitJob(t, s, "cleanup", i)
+	}
+	// the third run panics, only the latest 2 runs are kept
+	if err := s.Trigger("cleanup"); err != nil {
+		t.Fatal(err)
+	}
+	var state JobState
+	for deadline := time.Now().Add(5 * time.Second); state.LastError == "" && time.Now().Before(deadline); {
+		time.Sleep(10 * time.Millisecond)
+		state, _ = s.Job("cleanup")
+	}
+	if len(state.History) != 2 || state.History[0].Status != JobFailed || state.LastError != "panic: broken" {
+		t.Fatalf("unexpected state: %+v", state)
+	}
+}
+
+type memoryLocker struct {
+	lock sync.Mutex
+	held map[string]bool
+}
+
+func (l *memoryLocker) TryLock(ctx context.Context, key string, ttl time.Duration) (func(time.Duration), bool, error) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	if l.held[key] {
+		return nil, false, nil
+	}
+	l.held[key] = true
+	return func(time.Duration) {
+		l.lock.Lock()
+		defer l.lock.Unlock()
+		delete(l.held, key)
+	}, true, nil
+}
+
+func TestSchedulerSingleInstance(t *testing.T) {
+	if err := NewScheduler(nil).Schedule("report", "@daily", func(ctx context.Context) error {
+		return nil
+	}, SingleInstance()); err == nil {
+		t.Fatal("a single-instance job requires a locker")
+	}
+
+	// the lock is held by another replica
+	locker := &memoryLocker{held: map[string]bool{"app:report": true}}
+	s := NewScheduler(nil, WithLocker(locker, "app:"))
+	ran := false
+	if err := s.Schedule("report", "@daily", func(ctx context.Context) error {
+		ran = true
+		return nil
+	}, SingleInstance()); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Trigger("report"); err != nil {
+		t.Fatal(err)
+	}
+	state := waitJob(t, s, "report", 1)
+	if ran || state.History[0].Status != JobSkipped {
+		t.Fatalf("the job should be skipped: %+v", state.History)
+	}
+}
+
+func TestSchedulerConfig(t *testing.T) {
+	runs := make(chan struct{}, 10)
+	s := NewScheduler(nil)
+	err := s.ScheduleConfig(&config.SchedulerSetting{Jobs: []config.JobSchedule{
+		{Name: "refresh", IntervalSeconds: 1},
+		{Name: "disabled", Cron: "* * * * *", Disabled: true},
+	}}, map[string]JobFunc{
+		"refresh": func(ctx context.Context) error {
+			runs <- struct{}{}
+			return nil
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if jobs := s.Jobs(); len(jobs) != 1 || jobs[0].Schedule != "@every 1s" {
+		t.Fatalf("unexpected jobs: %+v", jobs)
+	}
+
+	s.Start()
+	select {
+	case <-runs:
+	case <-time.After(3 * time.Second):
+		t.Fatal("the interval job didn't run")
+	}
+	if err = s.Stop(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	err = NewScheduler(nil).ScheduleConfig(&config.SchedulerSetting{Jobs: []config.JobSchedule{
+		{Name: "missing", Cron: "@hourly"},
+	}}, nil)
+	if !errors.Is(err, ErrUnknownJob) {
+		t.Fatalf("expected ErrUnknownJob, got %v", err)
+	}
+	if err = NewScheduler(nil).Schedule("bad", "61 * * * *", func(ctx context.Context) error {
+		return nil
+	}); err == nil {
+		t.Fatal("the invalid cron expression should be rejected")
+	}
+}
+
+func TestRedisLockerHoldsUntilNextTick(t *testing.T) {
+	server := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: server.Addr()})
+	defer client.Close()
+
+	// two replicas firing an interval job at different times
+	var runs atomic.Int32
+	replicas := make([]*Scheduler, 2)
+	for i := range replicas {
+		replicas[i] = NewScheduler(nil, WithLocker(NewRedisLocker(client), "app:"))
+		if err := replicas[i].Schedule("report", "@every 10s", func(ctx context.Context) error {
+			runs.Add(1)
+			return nil
+		}, SingleInstance()); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := replicas[0].Trigger("report"); err != nil {
+		t.Fatal(err)
+	}
+	waitJob(t, replicas[0], "report", 1)
+	if ttl := server.TTL("app:report"); ttl < 8*time.Second || ttl > 9*time.Second {
+		t.Fatalf("the lock should be kept until the next tick, the ttl is %v", ttl)
+	}
+
+	if err := replicas[1].Trigger("report"); err != nil {
+		t.Fatal(err)
+	}
+	state := waitJob(t, replicas[1], "report", 1)
+	if runs.Load() != 1 || state.History[0].Status != JobSkipped {
+		t.Fatalf("the job should run once within the interval: %d runs, %+v", runs.Load(), state.History)
+	}
+
+	// the lock is released at once if the run passes the next tick
+	if hold := lockHold(replicas[0].jobs["report"], time.Now().Add(-time.Minute)); hold > 0 {
+		t.Fatalf("unexpected hold: %v", hold)
+	}
+}
@@ -3,183 +3,268 @@ package system
 import (
 	"context"
 	"errors"
-	"github.com/jeven2016/mylibs/cache"
+	"fmt"
+	"github.com/gin-gonic/gin"
 	"github.com/jeven2016/mylibs/config"
-	"github.com/jeven2016/mylibs/db"
+	"github.com/jeven2016/mylibs/health"
 	"github.com/jeven2016/mylibs/log"
-	"github.com/panjf2000/ants/v2"
+	"github.com/jeven2016/mylibs/metrics"
 	"go.uber.org/zap"
 	"os"
 	"os/signal"
-	"sync"
 	"syscall"
+	"time"
 )
 
-var closed bool
-var closeLock sync.Mutex
+// ErrInvalidParams is returned by Startup if the params or the config are invalid
+var ErrInvalidParams = errors.New("invalid startup params")
+
+// ParamsError is the error of invalid params or config, errors.Is(err, ErrInvalidParams) reports true for it
+type ParamsError struct {
+	Err error
+}
+
+func (e *ParamsError) Error() string {
+	return fmt.Sprintf("start server failed: %v", e.Err)
+}
+
+func (e *ParamsError) Unwrap() error {
+	return e.Err
+}
+
+func (e *ParamsError) Is(target error) bool {
+	return target == ErrInvalidParams
+}
+
+// ErrStopped is returned by Stop if the system is already stopped
+var ErrStopped = errors.New("the system is already stopped")
 
 type StartupParams struct {
 	EnableMongodb bool
 	EnableRedis   bool
 	EnableEtcd    bool
 	Config        *config.ServerConfig
-	PreShutdown   func() error
-	PostShutdown  func() error
+	// ConfigWatcher is the watcher passed to config.LoadConfig, the log level is applied on changes if it's set
+	ConfigWatcher *config.Watcher
+	// ConfigLoader is the loader of Config, e.g. stored by config.WithLoader, the admin api serves its effective
+	// config if ConfigWatcher isn't set
+	ConfigLoader *config.Loader
+	// ConnectRetry retries connecting to redis and mongodb while starting, they're tried once if it's nil
+	ConnectRetry *RetryPolicy
+	// SetupRouter registers the routes, the http server is started on Http.Address:Http.Port if it's set or
+	// the admin api or the metrics are enabled
+	SetupRouter func(router *gin.Engine)
+	// Components are started after the built-in ones they depend on, e.g. the stream consumers or
+	// a ChromePool, and stopped before them. The http server, the etcd registration and the scheduler
+	// start after the components that don't depend on them
+	Components []Component
+	// Inspectors return the state of the resources of the service which are served by the admin api on
+	// /inspect/<name>, e.g. {"chrome": func() any { return chromePool.Stats() }}
+	Inspectors map[string]func() any
+	// HealthChecks are the readiness checks of the resources of the service which are registered in
+	// System.Health, e.g. {"chrome": chromePool.HealthCheck}
+	HealthChecks map[string]health.Check
+	// Jobs are the functions of the jobs by name, they're run by the schedules of Config.Scheduler
+	Jobs         map[string]JobFunc
+	PreShutdown  func() error
+	PostShutdown func() error
+	// DisableGlobal prevents Startup from setting the system returned by GetSystem, e.g. when several
+	// systems run in one process. The system should be passed explicitly or by NewContext then.
+	DisableGlobal bool
 }
 
 func (s *StartupParams) Validate() error {
 	if s.Config == nil {
-		return errors.New("start server failed: params and params.Config must be set")
+		return &ParamsError{Err: errors.New("params.Config must be set")}
 	}
 	if s.Config.ApplicationName == "" {
-		return errors.New("start server failed: application name is required")
+		return &ParamsError{Err: errors.New("application name is required")}
+	}
+
+	// the config may be built in code without LoadConfig, so make sure the defaults are filled
+	if err := s.Config.Complete(); err != nil {
+		return &ParamsError{Err: err}
+	}
+	if err := s.Config.Validate(); err != nil {
+		return &ParamsError{Err: err}
+	}
+
+	if s.servesHttp() && s.Config.Http == nil {
+		return &ParamsError{Err: errors.New("the http setting is required to serve the routes, the admin api or " +
+			"the metrics")}
+	}
+	if s.EnableEtcd {
+		if err := checkRegistration(s.Config); err != nil {
+			return &ParamsError{Err: err}
+		}
+	}
+
+	for _, schedule := range s.Config.Scheduler.Jobs {
+		if schedule.Disabled {
+			continue
+		}
+		if _, ok := s.Jobs[schedule.Name]; !ok {
+			return &ParamsError{Err: fmt.Errorf("the function of job %s isn't provided in params.Jobs", schedule.Name)}
+		}
+		if schedule.SingleInstance && !s.EnableRedis {
+			return &ParamsError{Err: fmt.Errorf("redis is required by the single-instance job %s", schedule.Name)}
+		}
+		if _, err := cronParser.Parse(ScheduleSpec(schedule)); err != nil {
+			return &ParamsError{Err: fmt.Errorf("invalid schedule of job %s: %w", schedule.Name, err)}
+		}
 	}
 	return nil
 }
 
-func Startup(ctx context.Context, params *StartupParams) *System {
+// servesHttp reports whether the http server is started
+func (s *StartupParams) servesHttp() bool {
+	cfg := s.Config
+	return s.SetupRouter != nil || (cfg.Admin != nil && cfg.Admin.Enabled) || (cfg.Metrics != nil && cfg.Metrics.Enabled)
+}
+
+// Startup starts the components enabled by the params. If a component fails to start, the started ones
+// are stopped and a *LifecycleError holding a *ComponentError per failure is returned. The system is
+// stopped by Stop, SIGTERM, SIGINT or the cancellation of ctx, it can be started again afterwards.
+func Startup(ctx context.Context, params *StartupParams) (*System, error) {
 	if params == nil {
-		panic("start server failed: the params in method Startup(ctx, params) is required")
+		return nil, &ParamsError{Err: errors.New("the params in method Startup(ctx, params) is required")}
 	}
 
 	if err := params.Validate(); err != nil {
-		panic(err.Error())
+		return nil, err
 	}
 
 	// 创建一个全局的App
 	sys := &System{}
 	sys.Config = params.Config
 	sys.startupParams = params
+	sys.Health = health.NewRegistry(0, 0)
+	for name, check := range params.HealthChecks {
+		sys.Health.Register(name, health.Readiness, check)
+	}
+	sys.done = make(chan struct{})
 
 	// log初始化
 	log.SetupLog(params.Config.ApplicationName, params.Config.LogSetting)
-
-	if params.EnableRedis {
-		// 初始化redis
-		redisClient, err := cache.NewRedis(ctx, params.Config.Redis)
-		if err != nil {
-			zap.L().Error("failed to initialize for redis", zap.Error(err))
-			shutdown(ctx, sys, params)
-			return nil
-		} else {
-			zap.L().Info("Connecting to redis successfully")
-			sys.RedisClient = redisClient
-		}
+	if params.ConfigWatcher != nil {
+		sys.unwatchLevel = log.WatchLevel(params.ConfigWatcher)
 	}
 
-	if params.EnableMongodb {
-		// 初始化Mongodb
-		if mongoClient, err := db.NewMongo(ctx, params.Config.Mongo); err != nil {
-			zap.L().Error("failed to connect mongodb", zap.Error(err))
-			shutdown(ctx, sys, params)
-			return nil
-		} else {
-			zap.L().Info("Connecting to mongodb successfully")
-			sys.MongoClient = mongoClient
-		}
+	if err := metrics.Enable(params.Config.Metrics); err != nil {
+		return nil, sys.abort(ctx, fmt.Errorf("failed to enable metrics: %w", err))
+	}
+	if err := sys.setupTracing(ctx, params); err != nil {
+		return nil, sys.abort(ctx, fmt.Errorf("failed to set up tracing: %w", err))
 	}
 
-	//init a routine pool
-	pool, err := ants.NewPool(params.Config.TaskPoolSetting.Capacity)
-	if err != nil {
-		zap.L().Error("unable to init a routine pool", zap.Error(err))
-		shutdown(ctx, sys, params)
-		return nil
-	} else {
-		zap.L().Info("task pool initialized successfully")
-	}
-	sys.TaskPool = pool
-
-	if params.EnableEtcd {
-		//submit a task to register this service
-		if err = sys.RegisterService(params.Config); err != nil {
-			zap.L().Error("failed to register service in etcd", zap.String("app", params.Config.ApplicationName), zap.Error(err))
-			shutdown(ctx, sys, params)
-			return nil
-		} else {
-			zap.L().Info("service registered in etcd", zap.String("app", params.Config.ApplicationName))
-		}
+	sys.Components = NewComponentRegistry()
+	if err := sys.registerBuiltinComponents(ctx, params); err != nil {
+		return nil, sys.abort(ctx, err)
+	}
+	if err := sys.Components.Start(ctx); err != nil {
+		return nil, sys.abort(ctx, err)
 	}
+	sys.setupMetrics()
 
 	zap.L().Info("server starts successfully")
-	exitChan := make(chan os.Signal)
+	exitChan := make(chan os.Signal, 1)
 
 	// kill (no param) default send syscanll.SIGTERM
 	// kill -2 is syscall.SIGINT
 	// kill -9 is syscall. SIGKILL but can't be caught, so don't need to add it
 	signal.Notify(exitChan, syscall.SIGTERM, syscall.SIGINT)
 
-	err = sys.TaskPool.Submit(func() {
-		<-exitChan
-		shutdown(ctx, sys, params)
-	})
-	if err != nil {
-		zap.L().Info("unable to submit a shutdown hook", zap.Error(err))
-		return nil
-	}
+	// the hook exits once the system is stopped, so that nothing is left behind for the next startup
+	go func() {
+		defer signal.Stop(exitChan)
+		sys.handleSignals(ctx, exitChan)
+	}()
 
-	if err = sys.TaskPool.Submit(func() {
-		<-ctx.Done()
-		zap.S().Info("context is canceled")
-		shutdown(ctx, sys, params)
-	}); err != nil {
-		zap.L().Info("unable to submit a shutdown hook", zap.Error(err))
+	if !params.DisableGlobal {
+		SetSystem(sys)
 	}
-	SetSystem(sys)
-	return sys
+	return sys, nil
 }
 
-func Stop(ctx context.Context) {
-	shutdown(ctx, GetSystem(), GetSystem().startupParams)
+// abort cleans up after a failed startup and returns the error
+func (s *System) abort(ctx context.Context, err error) error {
+	zap.L().Error("failed to start server", zap.Error(err))
+	_ = s.Stop(ctx)
+	return err
 }
 
-func shutdown(ctx context.Context, sys *System, params *StartupParams) {
-	closeLock.Lock()
-	defer func() {
-		closed = true
-		closeLock.Unlock()
-	}()
+// Stop stops the system returned by GetSystem
+func Stop(ctx context.Context) error {
+	sys := GetSystem()
+	if sys == nil {
+		return ErrStopped
+	}
+	return sys.Stop(ctx)
+}
+
+// Stop shuts the system down gracefully within Shutdown.TimeoutSeconds:
+//  1. the readiness fails
+//  2. the intake is stopped, i.e. the service is deregistered, the http server and the consumers stop
+//  3. the in-flight tasks of the task pools are waited for Shutdown.GracePeriodSeconds, the rest are abandoned
+//  4. the PreShutdown hook is called, nothing is served anymore so it can close the resources of the service
+//  5. the components are stopped in reverse order of their dependencies and PostShutdown is called
+//
+// The errors of the components are returned as a *LifecycleError. ErrStopped is returned if it's called again.
+func (s *System) Stop(ctx context.Context) error {
+	s.stopLock.Lock()
+	defer s.stopLock.Unlock()
 
-	if closed {
-		return
+	if s.stopped {
+		return ErrStopped
+	}
+	s.stopped = true
+	if s.done != nil {
+		close(s.done)
+	}
+	params := s.startupParams
+	setting := params.Config.Shutdown
+
+	// the context of Startup may be canceled already, the shutdown still gets its own deadline
+	if ctx.Err() != nil {
+		ctx = context.Background()
+	}
+	if setting != nil && setting.TimeoutSeconds > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, time.Duration(setting.TimeoutSeconds)*time.Second)
+		defer cancel()
 	}
 
 	zap.L().Info("server is shutting down")
 
-	if params.PreShutdown != nil {
-		zap.S().Warn("call PreShutdown hook before exiting")
-		if err := params.PreShutdown(); err != nil {
-			zap.L().Warn("an error occurs while calling shutdown hook", zap.Error(err))
-		}
+	// fail the readiness at once so that no new traffic is routed here while the resources are closed
+	if s.Health != nil {
+		s.Health.SetShuttingDown()
 	}
 
-	if sys.RedisClient != nil {
-		if err := sys.RedisClient.Client.Close(); err != nil {
-			zap.L().Warn("an error occurs while closing redis's connection", zap.Error(err))
-		} else {
-			zap.S().Info("redis connections closed")
+	var errs []*ComponentError
+	collect := func(err error) {
+		if lifecycleErr, ok := err.(*LifecycleError); ok {
+			errs = append(errs, lifecycleErr.Errors...)
 		}
 	}
 
-	if sys.MongoClient != nil {
-		if err := sys.MongoClient.Client.Disconnect(ctx); err != nil {
-			zap.L().Warn("an error occurs while closing mongodb's connection", zap.Error(err))
-		} else {
-			zap.S().Info("mongodb connections closed")
+	if s.Components != nil {
+		collect(s.Components.StopIntake(ctx))
+	}
+	if setting != nil {
+		s.waitInFlight(ctx, time.Duration(setting.GracePeriodSeconds)*time.Second)
+	}
+
+	if params.PreShutdown != nil {
+		zap.S().Warn("call PreShutdown hook before exiting")
+		if err := params.PreShutdown(); err != nil {
+			zap.L().Warn("an error occurs while calling shutdown hook", zap.Error(err))
 		}
 	}
 
-	//if sys.ServiceRegister != nil {
-	//	if sys.ServiceRegister != nil {
-	//		if err := sys.ServiceRegister.Cancel(ctx); err != nil {
-	//			zap.L().Warn("an error occurs while closing etcd's connection", zap.Error(err))
-	//		}
-	//	}
-	//}
-	//
-	if sys.TaskPool != nil {
-		sys.TaskPool.Release()
-		zap.S().Info("task pool released")
+	s.teardownMetrics()
+	if s.Components != nil {
+		collect(s.Components.Stop(ctx))
 	}
 
 	if params.PostShutdown != nil {
@@ -188,5 +273,18 @@ func shutdown(ctx context.Context, sys *System, params *StartupParams) {
 			zap.L().Warn("an error occurs while calling post shutdown hook", zap.Error(err))
 		}
 	}
+	s.shutdownTracing()
+	// the watcher may outlive the system, e.g. it's passed to the next startup
+	if s.unwatchLevel != nil {
+		s.unwatchLevel()
+	}
+
+	// the stopped system is no longer the default one
+	system.CompareAndSwap(s, nil)
 	zap.L().Info("shutdown completed")
+
+	if len(errs) > 0 {
+		return &LifecycleError{Errors: errs}
+	}
+	return nil
 }
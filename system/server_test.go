@@ -0,0 +1,257 @@
+package system
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"github.com/jeven2016/mylibs/config"
+	"github.com/jeven2016/mylibs/health"
+	"github.com/jeven2016/mylibs/log"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func freePort(t *testing.T) uint {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	return uint(l.Addr().(*net.TCPAddr).Port)
+}
+
+func TestStartupServesHttp(t *testing.T) {
+	port := freePort(t)
+	cfg := &config.ServerConfig{
+		ApplicationName: "test-app",
+		Http:            &config.HttpSetting{Address: "127.0.0.1", Port: port, ShutdownTimeoutSeconds: 5},
+		LogSetting:      &config.LogConfig{LogPath: t.TempDir(), LogLevel: "ERROR"},
+	}
+
+	released := make(chan struct{})
+	sys, err := Startup(context.Background(), &StartupParams{
+		Config: cfg,
+		SetupRouter: func(router *gin.Engine) {
+			router.GET("/slow", func(c *gin.Context) {
+				time.Sleep(300 * time.Millisecond)
+				c.String(http.StatusOK, "done")
+			})
+		},
+		PostShutdown: func() error {
+			close(released)
+			return nil
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sys.HttpServer == nil {
+		t.Fatal("the http server should be started")
+	}
+
+	// a spare connection dialed by the transport would be waited by the graceful shutdown for 5 seconds
+	client := &http.Client{Transport: &http.Transport{DisableKeepAlives: true}}
+	resp, err := client.Get(fmt.Sprintf("http://127.0.0.1:%d/readyz", port))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("the service should be ready, got %d", resp.StatusCode)
+	}
+
+	result := make(chan string, 1)
+	go func() {
+		resp, err := client.Get(fmt.Sprintf("http://127.0.0.1:%d/slow", port))
+		if err != nil {
+			result <- err.Error()
+			return
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		result <- string(body)
+	}()
+
+	// the in-flight request completes during the graceful shutdown
+	time.Sleep(100 * time.Millisecond)
+	if err = Stop(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if body := <-result; body != "done" {
+		t.Fatalf("the in-flight request is interrupted: %s", body)
+	}
+	<-released
+
+	if _, err := client.Get(fmt.Sprintf("http://127.0.0.1:%d/slow", port)); err == nil {
+		t.Fatal("the http server should be stopped")
+	}
+	if err = sys.Stop(context.Background()); !errors.Is(err, ErrStopped) {
+		t.Fatalf("the second stop should be rejected: %v", err)
+	}
+}
+
+func TestStartupRestart(t *testing.T) {
+	port := freePort(t)
+	for i := 0; i < 2; i++ {
+		sys, err := Startup(context.Background(), &StartupParams{
+			Config: &config.ServerConfig{
+				ApplicationName: "test-app",
+				Http:            &config.HttpSetting{Address: "127.0.0.1", Port: port},
+				LogSetting:      &config.LogConfig{LogPath: t.TempDir(), LogLevel: "ERROR"},
+			},
+			SetupRouter: func(router *gin.Engine) {},
+		})
+		if err != nil {
+			t.Fatalf("round %d: %v", i, err)
+		}
+		if err = sys.Stop(context.Background()); err != nil {
+			t.Fatalf("round %d: %v", i, err)
+		}
+	}
+}
+
+func TestStartupErrors(t *testing.T) {
+	if _, err := Startup(context.Background(), &StartupParams{Config: &config.ServerConfig{}}); !errors.Is(err, ErrInvalidParams) {
+		t.Fatalf("the invalid config should be reported: %v", err)
+	}
+	// the admin api is served by the http server
+	if _, err := Startup(context.Background(), &StartupParams{Config: &config.ServerConfig{
+		ApplicationName: "test-app",
+		Admin:           &config.AdminSetting{Enabled: true, Token: "secret-token"},
+	}}); !errors.Is(err, ErrInvalidParams) {
+		t.Fatalf("the missing http setting should be reported: %v", err)
+	}
+	// the service listening on all the interfaces can't be registered by its address
+	for _, address := range []string{"", "0.0.0.0", "::"} {
+		_, err := Startup(context.Background(), &StartupParams{
+			EnableEtcd: true,
+			Config: &config.ServerConfig{
+				ApplicationName: "test-app",
+				Http:            &config.HttpSetting{Address: address, Port: 8080},
+				Registration:    &config.Registration{Etcd: config.EtcdConfig{Endpoints: []string{"127.0.0.1:2379"}}},
+			},
+		})
+		if !errors.Is(err, ErrInvalidParams) {
+			t.Fatalf("the address %q should be rejected: %v", address, err)
+		}
+	}
+
+	postShutdown := false
+	start := time.Now()
+	_, err := Startup(context.Background(), &StartupParams{
+		EnableRedis: true,
+		Config: &config.ServerConfig{
+			ApplicationName: "test-app",
+			Redis:           &config.RedisConfig{Address: fmt.Sprintf("127.0.0.1:%d", freePort(t))},
+			LogSetting:      &config.LogConfig{LogPath: t.TempDir(), LogLevel: "ERROR"},
+		},
+		ConnectRetry: &RetryPolicy{MaxAttempts: 3, InitialBackoff: 50 * time.Millisecond},
+		PostShutdown: func() error {
+			postShutdown = true
+			return nil
+		},
+	})
+
+	var componentErr *ComponentError
+	if !errors.As(err, &componentErr) || componentErr.Component != ComponentRedis || componentErr.Phase != "start" {
+		t.Fatalf("the failure of redis should be reported: %v", err)
+	}
+	// two backoffs of 50ms and 100ms between the three attempts
+	if elapsed := time.Since(start); elapsed < 150*time.Millisecond {
+		t.Fatalf("the connection should be retried, elapsed %v", elapsed)
+	}
+	if !postShutdown {
+		t.Fatal("the shutdown hooks should be called after a failed startup")
+	}
+}
+
+func TestStartupRegistersHealthChecks(t *testing.T) {
+	sys, err := Startup(context.Background(), &StartupParams{
+		Config: &config.ServerConfig{
+			ApplicationName: "test-app",
+			LogSetting:      &config.LogConfig{LogPath: t.TempDir(), LogLevel: "ERROR"},
+		},
+		HealthChecks: map[string]health.Check{
+			"chrome": func(ctx context.Context) error { return errors.New("the browser is gone") },
+		},
+		DisableGlobal: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sys.Stop(context.Background())
+
+	report := sys.Health.Check(context.Background(), health.Readiness)
+	if report.Healthy() || report.Checks["chrome"].Error != "the browser is gone" {
+		t.Fatalf("the chrome check should fail the readiness: %+v", report)
+	}
+}
+
+func TestStopUnwatchesLogLevel(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	content := "applicationName: test-app\nlogConfig:\n  logPath: %s\n  logLevel: %s\n"
+	logPath := t.TempDir()
+	if err := os.WriteFile(path, []byte(fmt.Sprintf(content, logPath, "ERROR")), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	watcher := config.NewWatcher()
+	defer watcher.Close()
+	var cfg config.ServerConfig
+	if err := config.LoadConfig(nil, &cfg, &path, nil, config.WithWatcher(watcher)); err != nil {
+		t.Fatal(err)
+	}
+	sys, err := Startup(context.Background(), &StartupParams{Config: &cfg, ConfigWatcher: watcher, DisableGlobal: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = sys.Stop(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	// the watcher is kept by the service, the stopped system no longer applies its log level
+	if err = os.WriteFile(path, []byte(fmt.Sprintf(content, logPath, "DEBUG")), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err = watcher.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	if level := log.GetLevel(); level != "ERROR" {
+		t.Fatalf("the log level is changed to %s after the stop", level)
+	}
+}
+
+func TestProbesInstrumented(t *testing.T) {
+	sys, err := Startup(context.Background(), &StartupParams{
+		Config: &config.ServerConfig{
+			ApplicationName: "test-app",
+			Http:            &config.HttpSetting{Address: "127.0.0.1", Port: freePort(t)},
+			LogSetting:      &config.LogConfig{LogPath: t.TempDir(), LogLevel: "ERROR"},
+			Metrics:         &config.MetricsSetting{Enabled: true, Path: "/metrics", Gin: true},
+		},
+		DisableGlobal: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sys.Stop(context.Background())
+
+	serve := func(path string) string {
+		recorder := httptest.NewRecorder()
+		sys.Router.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, path, nil))
+		return recorder.Body.String()
+	}
+	serve("/healthz")
+	if body := serve("/metrics"); !strings.Contains(body, `route="/healthz"`) {
+		t.Fatalf("the probe should be observed: %s", body)
+	}
+}
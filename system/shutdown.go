@@ -0,0 +1,128 @@
+package system
+
+import (
+	"context"
+	"go.uber.org/zap"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+const inFlightPollInterval = 100 * time.Millisecond
+
+// forceExit is called on the second signal, it's replaced in tests
+var forceExit = func() {
+	os.Exit(1)
+}
+
+// inFlight tracks the tasks submitted by System.Submit so that the abandoned ones can be reported
+type inFlight struct {
+	lock   sync.Mutex
+	nextID uint64
+	tasks  map[uint64]inFlightTask
+}
+
+type inFlightTask struct {
+	name    string
+	started time.Time
+}
+
+func (f *inFlight) add(name string) uint64 {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	if f.tasks == nil {
+		f.tasks = map[uint64]inFlightTask{}
+	}
+	f.nextID++
+	f.tasks[f.nextID] = inFlightTask{name: name, started: time.Now()}
+	return f.nextID
+}
+
+func (f *inFlight) remove(id uint64) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	delete(f.tasks, id)
+}
+
+func (f *inFlight) names() []string {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	names := make([]string, 0, len(f.tasks))
+	for _, task := range f.tasks {
+		names = append(names, task.name+" (running "+time.Since(task.started).Round(time.Millisecond).String()+")")
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Submit runs the task on the TaskPool, the task is tracked by name so that it's reported if it's still
+// running when the grace period of the shutdown elapses
+func (s *System) Submit(name string, task func()) error {
+	return s.SubmitTo(DefaultPoolName, name, task)
+}
+
+// SubmitTo runs the task on the named pool of TaskPoolSetting, the task is tracked like Submit
+func (s *System) SubmitTo(poolName string, name string, task func()) error {
+	id := s.inFlight.add(name)
+	err := s.Pools.Submit(poolName, func() {
+		defer s.inFlight.remove(id)
+		task()
+	})
+	if err != nil {
+		s.inFlight.remove(id)
+	}
+	return err
+}
+
+// waitInFlight waits until the task pools are idle or the grace period elapses, the tasks still running or
+// queued are logged as abandoned
+func (s *System) waitInFlight(ctx context.Context, gracePeriod time.Duration) {
+	if s.Pools == nil {
+		return
+	}
+
+	graceCtx, cancel := context.WithTimeout(ctx, gracePeriod)
+	defer cancel()
+	ticker := time.NewTicker(inFlightPollInterval)
+	defer ticker.Stop()
+
+	for s.Pools.busy() > 0 {
+		select {
+		case <-graceCtx.Done():
+			zap.L().Warn("the in-flight tasks are abandoned",
+				zap.Any("pools", s.Pools.Stats()),
+				zap.Strings("tasks", s.inFlight.names()))
+			return
+		case <-ticker.C:
+		}
+	}
+	zap.L().Info("all in-flight tasks completed")
+}
+
+// handleSignals stops the system on the first signal and exits at once on the second one
+func (s *System) handleSignals(ctx context.Context, signals <-chan os.Signal) {
+	select {
+	case sig := <-signals:
+		zap.L().Info("signal received, shutting down", zap.String("signal", sig.String()))
+	case <-ctx.Done():
+		zap.S().Info("context is canceled")
+	case <-s.done:
+		return
+	}
+
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		_ = s.Stop(ctx)
+	}()
+
+	select {
+	case sig := <-signals:
+		zap.L().Warn("second signal received, exiting without waiting for the shutdown",
+			zap.String("signal", sig.String()), zap.Strings("abandonedTasks", s.inFlight.names()))
+		_ = zap.L().Sync()
+		forceExit()
+	case <-stopped:
+	}
+}
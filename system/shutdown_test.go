@@ -0,0 +1,158 @@
+package system
+
+import (
+	"context"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/jeven2016/mylibs/cache"
+	"github.com/jeven2016/mylibs/config"
+	"os"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func shutdownConfig(t *testing.T, gracePeriodSeconds int) *config.ServerConfig {
+	return &config.ServerConfig{
+		ApplicationName: "test-app",
+		LogSetting:      &config.LogConfig{LogPath: t.TempDir(), LogLevel: "ERROR"},
+		Shutdown:        &config.ShutdownSetting{GracePeriodSeconds: gracePeriodSeconds},
+	}
+}
+
+func TestStopDrainsInFlightTasks(t *testing.T) {
+	server := miniredis.RunT(t)
+	rd, err := cache.NewRedis(context.Background(), &config.RedisConfig{Address: server.Addr(), PoolSize: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rd.Client.Close()
+	if err = rd.EnsureConsumeGroupCreated(context.Background(), "chapters", "crawler"); err != nil {
+		t.Fatal(err)
+	}
+
+	var consumerStopped, taskDone, closedAfterTask, chromeClosed, hookAfterDrain atomic.Bool
+	received := make(chan interface{}, 1)
+	sys, err := Startup(context.Background(), &StartupParams{
+		Config: shutdownConfig(t, 5),
+		// the hook runs once nothing is served anymore and before the components are stopped
+		PreShutdown: func() error {
+			hookAfterDrain.Store(consumerStopped.Load() && taskDone.Load() && !chromeClosed.Load())
+			return nil
+		},
+		Components: []Component{
+			// a stream consumer idle on the stream once the message is read
+			NewConsumer("consumer", func(ctx context.Context) error {
+				messages := make(chan interface{})
+				go func() {
+					for message := range messages {
+						received <- message
+					}
+				}()
+				err := rd.Consume(ctx, "chapters", "crawler", messages)
+				consumerStopped.Store(true)
+				return err
+			}, ComponentTaskPool),
+			NewComponent("chrome", nil, func(ctx context.Context) error {
+				closedAfterTask.Store(taskDone.Load())
+				chromeClosed.Store(true)
+				return nil
+			}),
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = rd.PublishMessage(context.Background(), "chapter-1", "chapters"); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-received:
+	case <-time.After(3 * time.Second):
+		t.Fatal("the message should be consumed")
+	}
+
+	if err = sys.Submit("crawl chapter", func() {
+		time.Sleep(300 * time.Millisecond)
+		taskDone.Store(true)
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = sys.Stop(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if !consumerStopped.Load() {
+		t.Fatal("the consumer should be stopped")
+	}
+	if !closedAfterTask.Load() {
+		t.Fatal("the resources should be closed after the in-flight task completes")
+	}
+	if !hookAfterDrain.Load() {
+		t.Fatal("PreShutdown should be called after the intake is stopped and the tasks are drained")
+	}
+}
+
+func TestStopAbandonsTasksAfterGracePeriod(t *testing.T) {
+	sys, err := Startup(context.Background(), &StartupParams{Config: shutdownConfig(t, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	release := make(chan struct{})
+	defer close(release)
+	if err = sys.Submit("stuck task", func() { <-release }); err != nil {
+		t.Fatal(err)
+	}
+	if names := sys.inFlight.names(); len(names) != 1 {
+		t.Fatalf("the task should be tracked: %v", names)
+	}
+
+	start := time.Now()
+	if err = sys.Stop(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed < time.Second || elapsed > 3*time.Second {
+		t.Fatalf("the stop should wait for the grace period only, elapsed %v", elapsed)
+	}
+}
+
+func TestSecondSignalForcesExit(t *testing.T) {
+	exited := make(chan struct{})
+	forceExit = func() { close(exited) }
+	defer func() { forceExit = func() { os.Exit(1) } }()
+
+	blocked := make(chan struct{})
+	release := make(chan struct{})
+	sys := &System{
+		done: make(chan struct{}),
+		startupParams: &StartupParams{
+			Config: &config.ServerConfig{},
+			PreShutdown: func() error {
+				close(blocked)
+				<-release
+				return nil
+			},
+		},
+	}
+
+	signals := make(chan os.Signal, 2)
+	handled := make(chan struct{})
+	go func() {
+		defer close(handled)
+		sys.handleSignals(context.Background(), signals)
+	}()
+
+	signals <- syscall.SIGTERM
+	<-blocked
+	signals <- syscall.SIGTERM
+
+	select {
+	case <-exited:
+	case <-time.After(time.Second):
+		t.Fatal("the second signal should force the exit")
+	}
+	close(release)
+	<-handled
+}
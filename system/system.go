@@ -1,83 +1,201 @@
 package system
 
 import (
+	"context"
+	"errors"
+	"github.com/gin-gonic/gin"
 	"github.com/jeven2016/mylibs/cache"
 	"github.com/jeven2016/mylibs/config"
 	"github.com/jeven2016/mylibs/db"
+	"github.com/jeven2016/mylibs/health"
 	"github.com/panjf2000/ants/v2"
 	"go.mongodb.org/mongo-driver/mongo"
+	"net"
+	"net/http"
 	"sync"
+	"sync/atomic"
 )
 
-var system *System
-var sysOnce = sync.Once{}
-var lock = sync.Mutex{}
+// the default system, it's optional since the system can be passed explicitly or by NewContext
+var system atomic.Pointer[System]
 
 type System struct {
 	RedisClient *cache.Redis
 
 	MongoClient *db.Mongo
 
-	//ServiceRegister Register
+	ServiceRegister *Register
 
 	Config config.Config
 
+	// TaskPool is the default pool of Pools
 	TaskPool *ants.Pool
 
-	collectionMap map[string]*mongo.Collection
+	// Pools holds the default pool, the reserved system pool and the named pools of TaskPoolSetting
+	Pools *TaskPools
+
+	// Health holds the liveness and readiness checks, they're served on /healthz and /readyz
+	Health *health.Registry
+
+	// Scheduler runs the jobs of StartupParams.Jobs by the schedules of the config, more jobs can be
+	// scheduled in code once the system is started
+	Scheduler *Scheduler
+
+	// Components starts the built-in clients and the components of the service in dependency order
+	Components *ComponentRegistry
+
+	// Router and HttpServer are set if StartupParams.SetupRouter is provided or the admin api or the
+	// metrics are enabled
+	Router     *gin.Engine
+	HttpServer *http.Server
+
+	httpListener net.Listener
+	// unsubscribes the log level from StartupParams.ConfigWatcher
+	unwatchLevel func()
+
+	collectionLock sync.Mutex
+	collectionMap  map[string]*mongo.Collection
+
+	// flushes the spans on shutdown, it's set if the tracing is enabled
+	tracingShutdown func(ctx context.Context) error
 
 	startupParams *StartupParams
+
+	inFlight inFlight
+
+	stopLock sync.Mutex
+	stopped  bool
+	// closed once the system is stopped
+	done chan struct{}
 }
 
+// RegisterService registers Http.Address:Http.Port of the service in etcd, the address must be reachable by
+// the other services
 func (s *System) RegisterService(cfg *config.ServerConfig) error {
-	//registerParam := &RegisterParam{
-	//	ServiceName:           cfg.ApplicationName,
-	//	ServiceHost:           cfg.Http.Address,
-	//	ServicePort:           cfg.Http.Port,
-	//	RefreshSeconds:        cfg.Registration.Etcd.RefreshSeconds,
-	//	ConnectTimeoutSeconds: cfg.Registration.Etcd.ConnectTimeoutSeconds,
-	//}
-	//
-	////register service to etcd
-	//if register, err := NewRegister(cfg.Registration.Etcd.Endpoints, s.Log, registerParam, s.TaskPool); err != nil {
-	//	return err
-	//} else {
-	//	s.ServiceRegister = register
-	//	err = s.ServiceRegister.Register(context.Background())
-	//
-	//	if err != nil {
-	//		return err
-	//	}
-	//}
+	if err := checkRegistration(cfg); err != nil {
+		return err
+	}
+
+	registerParam := &RegisterParam{
+		ServiceName:           cfg.ApplicationName,
+		ServiceHost:           cfg.Http.Address,
+		ServicePort:           cfg.Http.Port,
+		RefreshSeconds:        cfg.Registration.Etcd.RefreshSeconds,
+		ConnectTimeoutSeconds: cfg.Registration.Etcd.ConnectTimeoutSeconds,
+	}
+
+	//register service to etcd
+	register, err := NewRegister(cfg.Registration.Etcd.Endpoints, registerParam)
+	if err != nil {
+		return err
+	}
+	if err = register.Register(context.Background()); err != nil {
+		_ = register.Cancel(context.Background())
+		return err
+	}
+	s.ServiceRegister = register
+	return nil
+}
+
+// checkRegistration checks the settings required to register the service, an empty or unspecified address
+// like 0.0.0.0 would be registered as an address the other services can't reach
+func checkRegistration(cfg *config.ServerConfig) error {
+	if cfg.Registration == nil || len(cfg.Registration.Etcd.Endpoints) == 0 {
+		return errors.New("the etcd endpoints in registration are required to register service")
+	}
+	if cfg.Http == nil || cfg.Http.Port == 0 {
+		return errors.New("the http port is required to register service")
+	}
+	if ip := net.ParseIP(cfg.Http.Address); cfg.Http.Address == "" || (ip != nil && ip.IsUnspecified()) {
+		return errors.New("the http address reachable by the other services is required to register service")
+	}
 	return nil
 }
 
-//func (s *System) GetServiceAddresses(ctx context.Context, serviceName string) ([]string, error) {
-//	return s.ServiceRegister.ListServiceAddresses(ctx, serviceName)
-//}
+// GetServiceAddresses returns the addresses of the service registered in etcd
+func (s *System) GetServiceAddresses(ctx context.Context, serviceName string) ([]string, error) {
+	if s.ServiceRegister == nil {
+		return nil, errors.New("etcd isn't enabled")
+	}
+	return s.ServiceRegister.ListServiceAddresses(ctx, serviceName)
+}
 
 func (s *System) GetCollection(name string) *mongo.Collection {
-	sysOnce.Do(func() {
+	s.collectionLock.Lock()
+	defer s.collectionLock.Unlock()
+
+	if s.collectionMap == nil {
 		s.collectionMap = make(map[string]*mongo.Collection)
-	})
-	if collection, ok := s.collectionMap[name]; ok {
-		return collection
-	} else {
-		lock.Lock()
-		defer lock.Unlock()
-
-		if collection, ok = s.collectionMap[name]; ok {
-			return collection
-		}
-		s.collectionMap[name] = s.MongoClient.Db.Collection(name)
-	}
-	return s.collectionMap[name]
+	}
+	collection, ok := s.collectionMap[name]
+	if !ok {
+		collection = s.MongoClient.Db.Collection(name)
+		s.collectionMap[name] = collection
+	}
+	return collection
+}
+
+// Redis returns the redis client, it's nil if redis isn't enabled
+func (s *System) Redis() *cache.Redis {
+	if s == nil {
+		return nil
+	}
+	return s.RedisClient
+}
+
+// Mongo returns the mongodb client, it's nil if mongodb isn't enabled
+func (s *System) Mongo() *db.Mongo {
+	if s == nil {
+		return nil
+	}
+	return s.MongoClient
+}
+
+// Pool returns the task pool
+func (s *System) Pool() *ants.Pool {
+	if s == nil {
+		return nil
+	}
+	return s.TaskPool
+}
+
+// NamedPool returns the pool of the name, nil is returned if it isn't configured
+func (s *System) NamedPool(name string) *ants.Pool {
+	if s == nil {
+		return nil
+	}
+	return s.Pools.Get(name)
+}
+
+// ServerConfig returns the config passed to Startup
+func (s *System) ServerConfig() *config.ServerConfig {
+	if s == nil || s.startupParams == nil {
+		return nil
+	}
+	return s.startupParams.Config
+}
+
+type systemKey struct{}
+
+// NewContext returns a copy of ctx carrying the system, the helpers taking a context use it rather than
+// the global one
+func NewContext(ctx context.Context, sys *System) context.Context {
+	return context.WithValue(ctx, systemKey{}, sys)
+}
+
+// FromContext returns the system carried by ctx, the one returned by GetSystem is the default
+func FromContext(ctx context.Context) *System {
+	if sys, ok := ctx.Value(systemKey{}).(*System); ok && sys != nil {
+		return sys
+	}
+	return GetSystem()
 }
 
+// GetSystem returns the default system, it's the last one started unless StartupParams.DisableGlobal is set
 func GetSystem() *System {
-	return system
+	return system.Load()
 }
 
 func SetSystem(sys *System) {
-	system = sys
+	system.Store(sys)
 }
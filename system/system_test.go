@@ -0,0 +1,56 @@
+package system
+
+import (
+	"context"
+	"github.com/jeven2016/mylibs/config"
+	"testing"
+)
+
+func TestFromContext(t *testing.T) {
+	global := &System{}
+	SetSystem(global)
+	defer SetSystem(nil)
+
+	if FromContext(context.Background()) != global {
+		t.Fatal("the global system should be the default")
+	}
+	explicit := &System{}
+	if FromContext(NewContext(context.Background(), explicit)) != explicit {
+		t.Fatal("the system in the context should be preferred")
+	}
+
+	var nilSys *System
+	if nilSys.Redis() != nil || nilSys.Mongo() != nil || nilSys.Pool() != nil || nilSys.ServerConfig() != nil {
+		t.Fatal("the accessors of a nil system should return nil")
+	}
+}
+
+func TestTwoSystemsWithoutGlobal(t *testing.T) {
+	SetSystem(nil)
+	var systems []*System
+	for _, name := range []string{"app-1", "app-2"} {
+		sys, err := Startup(context.Background(), &StartupParams{
+			Config: &config.ServerConfig{
+				ApplicationName: name,
+				LogSetting:      &config.LogConfig{LogPath: t.TempDir(), LogLevel: "ERROR"},
+			},
+			DisableGlobal: true,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		systems = append(systems, sys)
+	}
+
+	if GetSystem() != nil {
+		t.Fatal("the global system should not be set")
+	}
+	if systems[0].Pool() == systems[1].Pool() || systems[1].ServerConfig().ApplicationName != "app-2" {
+		t.Fatal("the systems should be independent")
+	}
+	for _, sys := range systems {
+		if err := sys.Stop(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
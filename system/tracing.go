@@ -0,0 +1,65 @@
+package system
+
+import (
+	"context"
+	"github.com/gin-gonic/gin"
+	"github.com/jeven2016/mylibs/db"
+	"github.com/jeven2016/mylibs/metrics"
+	"github.com/jeven2016/mylibs/tracing"
+	"go.mongodb.org/mongo-driver/event"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.uber.org/zap"
+	"time"
+)
+
+const tracingShutdownTimeout = 5 * time.Second
+
+func tracingEnabled(params *StartupParams) bool {
+	return params.Config.Tracing != nil && params.Config.Tracing.Enabled
+}
+
+// setupTracing installs the tracer provider, the spans are flushed by shutdownTracing
+func (s *System) setupTracing(ctx context.Context, params *StartupParams) error {
+	shutdown, err := tracing.Setup(ctx, params.Config.ApplicationName, params.Config.Tracing)
+	if err != nil {
+		return err
+	}
+	s.tracingShutdown = shutdown
+	return nil
+}
+
+func (s *System) shutdownTracing() {
+	if s.tracingShutdown == nil {
+		return
+	}
+
+	// the context of Startup may be canceled already, so a separate one is used
+	ctx, cancel := context.WithTimeout(context.Background(), tracingShutdownTimeout)
+	defer cancel()
+	if err := s.tracingShutdown(ctx); err != nil {
+		zap.L().Warn("an error occurs while flushing the spans", zap.Error(err))
+	}
+	s.tracingShutdown = nil
+}
+
+// mountTracing starts a span for each request, it's added before the routes are registered
+func (s *System) mountTracing(router *gin.Engine, params *StartupParams) {
+	if tracingEnabled(params) {
+		router.Use(tracing.GinMiddleware())
+	}
+}
+
+// mongoOptions returns the client options instrumenting the mongo commands
+func mongoOptions(params *StartupParams) []*options.ClientOptions {
+	var monitors []*event.CommandMonitor
+	if metrics.Enabled(metrics.Mongo) {
+		monitors = append(monitors, metrics.MongoMonitor())
+	}
+	if tracingEnabled(params) {
+		monitors = append(monitors, tracing.MongoMonitor())
+	}
+	if len(monitors) == 0 {
+		return nil
+	}
+	return []*options.ClientOptions{options.Client().SetMonitor(db.CombineMonitors(monitors...))}
+}
@@ -0,0 +1,131 @@
+package tracing
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/go-resty/resty/v2"
+	"github.com/gocolly/colly/v2"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	semconv "go.opentelemetry.io/otel/semconv/v1.21.0"
+	"go.opentelemetry.io/otel/trace"
+	"net/http"
+	"sync/atomic"
+)
+
+// the key of the colly context holding the context.Context of the caller
+const collyContextKey = "tracing.context"
+
+// the key of the colly context holding the span of the request
+const collySpanKey = "tracing.span"
+
+type attemptKey struct{}
+
+// attempt is the span of an attempt of a resty request, the parent is kept to start the span of a retry
+type attempt struct {
+	parent context.Context
+	span   trace.Span
+	ended  atomic.Bool
+}
+
+func (a *attempt) end(status int, err error) {
+	if !a.ended.CompareAndSwap(false, true) {
+		return
+	}
+	endSpan(a.span, status, err)
+}
+
+func endSpan(span trace.Span, status int, err error) {
+	if status > 0 {
+		span.SetAttributes(semconv.HTTPStatusCode(status))
+	}
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	} else if status >= http.StatusBadRequest {
+		span.SetStatus(codes.Error, http.StatusText(status))
+	}
+	span.End()
+}
+
+// InstrumentResty starts a client span for each attempt of the requests and injects the trace context
+// into the headers, the parent span is taken from the context of the request
+func InstrumentResty(c *resty.Client) {
+	c.OnBeforeRequest(func(c *resty.Client, r *resty.Request) error {
+		parent := r.Context()
+		if a, ok := parent.Value(attemptKey{}).(*attempt); ok {
+			// a retry of the request, it's a sibling of the former attempt
+			a.end(0, errors.New("retried"))
+			parent = a.parent
+		}
+
+		ctx, span := Tracer().Start(parent, "HTTP "+r.Method,
+			trace.WithSpanKind(trace.SpanKindClient),
+			trace.WithAttributes(semconv.HTTPMethod(r.Method), attribute.String("http.url", r.URL)))
+		ctx = context.WithValue(ctx, attemptKey{}, &attempt{parent: parent, span: span})
+		r.SetContext(ctx)
+		otel.GetTextMapPropagator().Inject(ctx, propagation.HeaderCarrier(r.Header))
+		return nil
+	})
+
+	c.OnAfterResponse(func(c *resty.Client, resp *resty.Response) error {
+		if a, ok := resp.Request.Context().Value(attemptKey{}).(*attempt); ok {
+			a.end(resp.StatusCode(), nil)
+		}
+		return nil
+	})
+
+	c.OnError(func(r *resty.Request, err error) {
+		if a, ok := r.Context().Value(attemptKey{}).(*attempt); ok {
+			a.end(0, err)
+		}
+	})
+}
+
+// WithCollyContext keeps the context of the caller in the colly context, the spans of the requests
+// visited with it become the children of the span in ctx
+func WithCollyContext(ctx context.Context, collyCtx *colly.Context) *colly.Context {
+	if collyCtx == nil {
+		collyCtx = colly.NewContext()
+	}
+	collyCtx.Put(collyContextKey, ctx)
+	return collyCtx
+}
+
+// InstrumentColly starts a client span for each request of the collector and injects the trace context
+// into the headers, the parent span is the one passed by WithCollyContext
+func InstrumentColly(c *colly.Collector) {
+	c.OnRequest(func(r *colly.Request) {
+		parent, ok := r.Ctx.GetAny(collyContextKey).(context.Context)
+		if !ok {
+			parent = context.Background()
+		}
+		if span, ok := r.Ctx.GetAny(collySpanKey).(trace.Span); ok {
+			// a retry of the request
+			endSpan(span, 0, errors.New("retried"))
+		}
+
+		ctx, span := Tracer().Start(parent, "HTTP "+r.Method,
+			trace.WithSpanKind(trace.SpanKindClient),
+			trace.WithAttributes(semconv.HTTPMethod(r.Method), attribute.String("http.url", r.URL.String())))
+		r.Ctx.Put(collySpanKey, span)
+		otel.GetTextMapPropagator().Inject(ctx, propagation.HeaderCarrier(*r.Headers))
+	})
+
+	c.OnResponse(func(r *colly.Response) {
+		if span, ok := r.Ctx.GetAny(collySpanKey).(trace.Span); ok {
+			r.Ctx.Put(collySpanKey, nil)
+			endSpan(span, r.StatusCode, nil)
+		}
+	})
+
+	c.OnError(func(r *colly.Response, err error) {
+		if span, ok := r.Ctx.GetAny(collySpanKey).(trace.Span); ok {
+			r.Ctx.Put(collySpanKey, nil)
+			endSpan(span, r.StatusCode, fmt.Errorf("request failed: %w", err))
+		}
+	})
+}
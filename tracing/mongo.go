@@ -0,0 +1,46 @@
+package tracing
+
+import (
+	"context"
+	"go.mongodb.org/mongo-driver/event"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	semconv "go.opentelemetry.io/otel/semconv/v1.21.0"
+	"go.opentelemetry.io/otel/trace"
+	"sync"
+)
+
+type commandKey struct {
+	connectionID string
+	requestID    int64
+}
+
+// MongoMonitor starts a client span for each command, the parent span is taken from the context passed
+// to the collection methods
+func MongoMonitor() *event.CommandMonitor {
+	var spans sync.Map
+	return &event.CommandMonitor{
+		Started: func(ctx context.Context, evt *event.CommandStartedEvent) {
+			_, span := Tracer().Start(ctx, "mongodb "+evt.CommandName,
+				trace.WithSpanKind(trace.SpanKindClient),
+				trace.WithAttributes(
+					semconv.DBSystemMongoDB,
+					semconv.DBName(evt.DatabaseName),
+					semconv.DBOperation(evt.CommandName),
+					attribute.String("db.connection_id", evt.ConnectionID),
+				))
+			spans.Store(commandKey{evt.ConnectionID, evt.RequestID}, span)
+		},
+		Succeeded: func(ctx context.Context, evt *event.CommandSucceededEvent) {
+			if span, ok := spans.LoadAndDelete(commandKey{evt.ConnectionID, evt.RequestID}); ok {
+				span.(trace.Span).End()
+			}
+		},
+		Failed: func(ctx context.Context, evt *event.CommandFailedEvent) {
+			if span, ok := spans.LoadAndDelete(commandKey{evt.ConnectionID, evt.RequestID}); ok {
+				span.(trace.Span).SetStatus(codes.Error, evt.Failure)
+				span.(trace.Span).End()
+			}
+		},
+	}
+}
@@ -0,0 +1,24 @@
+package tracing
+
+import (
+	"context"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+)
+
+// InjectFields writes the trace context into the fields of a stream message, e.g. traceparent
+func InjectFields(ctx context.Context, fields map[string]string) {
+	otel.GetTextMapPropagator().Inject(ctx, propagation.MapCarrier(fields))
+}
+
+// ExtractFields reads the trace context from the fields of a stream message, the fields which aren't
+// strings are ignored
+func ExtractFields(ctx context.Context, fields map[string]any) context.Context {
+	carrier := propagation.MapCarrier{}
+	for key, val := range fields {
+		if s, ok := val.(string); ok {
+			carrier[key] = s
+		}
+	}
+	return otel.GetTextMapPropagator().Extract(ctx, carrier)
+}
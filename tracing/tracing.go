@@ -0,0 +1,107 @@
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"github.com/jeven2016/mylibs/config"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.21.0"
+	"go.opentelemetry.io/otel/trace"
+	"os"
+	"path/filepath"
+)
+
+// InstrumentationName is the name of the tracer creating the spans of this library
+const InstrumentationName = "github.com/jeven2016/mylibs"
+
+const (
+	ExporterOtlp   = "otlp"
+	ExporterStdout = "stdout"
+	ExporterFile   = "file"
+)
+
+func init() {
+	// the context is propagated even if the tracing isn't set up, e.g. from a caller to a stream consumer
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+}
+
+// Tracer returns the tracer of the global provider, the spans are dropped until Setup is called
+func Tracer() trace.Tracer {
+	return otel.Tracer(InstrumentationName)
+}
+
+// Setup installs a global tracer provider exporting the spans as the setting describes, the returned
+// function flushes the pending spans and closes the exporter. Nothing is set up if the tracing isn't
+// enabled, in that case the spans are not recorded.
+func Setup(ctx context.Context, serviceName string, setting *config.TracingSetting) (func(ctx context.Context) error, error) {
+	noop := func(ctx context.Context) error { return nil }
+	if setting == nil || !setting.Enabled {
+		return noop, nil
+	}
+
+	exporter, closeFunc, err := newExporter(ctx, setting)
+	if err != nil {
+		return noop, err
+	}
+
+	res, err := resource.Merge(resource.Default(), resource.NewWithAttributes(semconv.SchemaURL,
+		semconv.ServiceName(serviceName)))
+	if err != nil {
+		return noop, err
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(setting.SampleRatio))),
+	)
+	otel.SetTracerProvider(provider)
+
+	return func(ctx context.Context) error {
+		err := provider.Shutdown(ctx)
+		if closeErr := closeFunc(); closeErr != nil && err == nil {
+			err = closeErr
+		}
+		return err
+	}, nil
+}
+
+func newExporter(ctx context.Context, setting *config.TracingSetting) (sdktrace.SpanExporter, func() error, error) {
+	noClose := func() error { return nil }
+	switch setting.Exporter {
+	case ExporterOtlp:
+		var opts []otlptracegrpc.Option
+		if setting.Endpoint != "" {
+			opts = append(opts, otlptracegrpc.WithEndpoint(setting.Endpoint))
+		}
+		if setting.Insecure {
+			opts = append(opts, otlptracegrpc.WithInsecure())
+		}
+		exporter, err := otlptracegrpc.New(ctx, opts...)
+		return exporter, noClose, err
+	case ExporterStdout, "":
+		exporter, err := stdouttrace.New(stdouttrace.WithPrettyPrint())
+		return exporter, noClose, err
+	case ExporterFile:
+		if err := os.MkdirAll(filepath.Dir(setting.FilePath), 0o755); err != nil {
+			return nil, nil, err
+		}
+		file, err := os.OpenFile(setting.FilePath, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+		if err != nil {
+			return nil, nil, err
+		}
+		exporter, err := stdouttrace.New(stdouttrace.WithWriter(file))
+		if err != nil {
+			_ = file.Close()
+			return nil, nil, err
+		}
+		return exporter, file.Close, nil
+	default:
+		return nil, nil, fmt.Errorf("unsupported trace exporter: %s", setting.Exporter)
+	}
+}
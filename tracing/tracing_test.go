@@ -0,0 +1,92 @@
+package tracing
+
+import (
+	"context"
+	"github.com/gin-gonic/gin"
+	"github.com/go-resty/resty/v2"
+	"go.opentelemetry.io/otel"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func setupRecorder(t *testing.T) *tracetest.SpanRecorder {
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	otel.SetTracerProvider(provider)
+	t.Cleanup(func() {
+		_ = provider.Shutdown(context.Background())
+		otel.SetTracerProvider(trace.NewNoopTracerProvider())
+	})
+	return recorder
+}
+
+func spanByName(t *testing.T, recorder *tracetest.SpanRecorder, name string) sdktrace.ReadOnlySpan {
+	t.Helper()
+	for _, span := range recorder.Ended() {
+		if span.Name() == name {
+			return span
+		}
+	}
+	t.Fatalf("the span %s is not found", name)
+	return nil
+}
+
+func TestPropagationFromRestyToGin(t *testing.T) {
+	recorder := setupRecorder(t)
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(GinMiddleware())
+	router.GET("/novels/:id", func(c *gin.Context) {
+		c.String(http.StatusOK, "ok")
+	})
+	server := httptest.NewServer(router)
+	defer server.Close()
+
+	ctx, root := Tracer().Start(context.Background(), "crawl")
+	client := resty.New()
+	InstrumentResty(client)
+	if _, err := client.R().SetContext(ctx).Get(server.URL + "/novels/1"); err != nil {
+		t.Fatal(err)
+	}
+	root.End()
+
+	clientSpan := spanByName(t, recorder, "HTTP GET")
+	serverSpan := spanByName(t, recorder, "GET /novels/:id")
+	if clientSpan.Parent().SpanID() != root.SpanContext().SpanID() {
+		t.Fatal("the client span should be the child of the caller's span")
+	}
+	if serverSpan.Parent().SpanID() != clientSpan.SpanContext().SpanID() {
+		t.Fatal("the server span should be the child of the client span")
+	}
+	if serverSpan.SpanKind() != trace.SpanKindServer || clientSpan.SpanKind() != trace.SpanKindClient {
+		t.Fatal("unexpected span kinds")
+	}
+}
+
+func TestStreamFields(t *testing.T) {
+	setupRecorder(t)
+
+	ctx, span := Tracer().Start(context.Background(), "publish")
+	defer span.End()
+
+	fields := map[string]string{"data": "{}"}
+	InjectFields(ctx, fields)
+	if fields["traceparent"] == "" {
+		t.Fatalf("the trace context is not injected: %v", fields)
+	}
+
+	// the values read from a stream are interface{}
+	values := map[string]any{}
+	for k, v := range fields {
+		values[k] = v
+	}
+	extracted := trace.SpanContextFromContext(ExtractFields(context.Background(), values))
+	if extracted.TraceID() != span.SpanContext().TraceID() || !extracted.IsRemote() {
+		t.Fatalf("the trace context is not extracted: %v", extracted)
+	}
+}
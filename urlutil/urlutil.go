@@ -0,0 +1,192 @@
+package urlutil
+
+import (
+	"errors"
+	"fmt"
+	"golang.org/x/net/publicsuffix"
+	"net"
+	"net/url"
+	"path"
+	"sort"
+	"strings"
+)
+
+// TrackingParams are the query params removed by Canonicalize, the params starting with utm_ are removed as well
+var TrackingParams = []string{"gclid", "fbclid", "msclkid", "yclid", "dclid", "_ga", "_gl", "mc_cid", "mc_eid", "spm"}
+
+var defaultPorts = map[string]string{"http": "80", "https": "443"}
+
+// Resolve resolves a link found in a page against the url of the page, e.g. ../chapter2.html, /book/1
+// or //cdn.example.com/cover.jpg. The fragment of the link is kept.
+func Resolve(pageUrl string, link string) (string, error) {
+	base, err := parseAbsolute(pageUrl)
+	if err != nil {
+		return "", err
+	}
+	ref, err := url.Parse(strings.TrimSpace(link))
+	if err != nil {
+		return "", err
+	}
+	return base.ResolveReference(ref).String(), nil
+}
+
+// BaseUrl returns the scheme and host of an url with the default port removed, e.g. https://example.com.
+// The protocol-relative urls are taken as https.
+func BaseUrl(rawUrl string) (string, error) {
+	u, err := parseAbsolute(rawUrl)
+	if err != nil {
+		return "", err
+	}
+	return u.Scheme + "://" + canonicalHost(u), nil
+}
+
+type canonicalOptions struct {
+	whitelist map[string]bool
+	tracking  map[string]bool
+}
+
+type CanonicalOption func(o *canonicalOptions)
+
+// WithQueryWhitelist keeps only the query params listed, e.g. the page number
+func WithQueryWhitelist(params ...string) CanonicalOption {
+	return func(o *canonicalOptions) {
+		if len(params) == 0 {
+			return
+		}
+		o.whitelist = map[string]bool{}
+		for _, p := range params {
+			o.whitelist[p] = true
+		}
+	}
+}
+
+// WithTrackingParams removes more query params besides TrackingParams
+func WithTrackingParams(params ...string) CanonicalOption {
+	return func(o *canonicalOptions) {
+		for _, p := range params {
+			o.tracking[strings.ToLower(p)] = true
+		}
+	}
+}
+
+// Canonicalize returns the canonical form of an absolute url so that the urls of the same page are equal:
+// the scheme and host are lowercased, the default port, the dot segments of the path, the fragment and the
+// tracking params are removed, and the query params are sorted by name.
+func Canonicalize(rawUrl string, opts ...CanonicalOption) (string, error) {
+	options := &canonicalOptions{tracking: map[string]bool{}}
+	for _, p := range TrackingParams {
+		options.tracking[p] = true
+	}
+	for _, opt := range opts {
+		opt(options)
+	}
+
+	u, err := parseAbsolute(rawUrl)
+	if err != nil {
+		return "", err
+	}
+	u.Host = canonicalHost(u)
+	u.Fragment = ""
+	u.RawFragment = ""
+	u.Path = cleanPath(u.Path)
+	u.RawPath = ""
+
+	query := u.Query()
+	for name := range query {
+		lower := strings.ToLower(name)
+		if options.tracking[lower] || strings.HasPrefix(lower, "utm_") ||
+			(options.whitelist != nil && !options.whitelist[name]) {
+			query.Del(name)
+		}
+	}
+	u.RawQuery = encodeQuery(query)
+	u.ForceQuery = false
+	return u.String(), nil
+}
+
+// RegistrableDomain returns the domain under the public suffix of an url or a host, e.g. example.co.uk
+// for https://www.example.co.uk/book. The host is returned as is if it's an ip or has no public suffix.
+func RegistrableDomain(rawUrlOrHost string) (string, error) {
+	host := rawUrlOrHost
+	if strings.Contains(rawUrlOrHost, "/") {
+		u, err := parseAbsolute(rawUrlOrHost)
+		if err != nil {
+			return "", err
+		}
+		host = u.Hostname()
+	} else if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	host = strings.TrimSuffix(strings.ToLower(host), ".")
+	if host == "" {
+		return "", errors.New("the host is empty")
+	}
+	if net.ParseIP(host) != nil || !strings.Contains(host, ".") {
+		return host, nil
+	}
+	return publicsuffix.EffectiveTLDPlusOne(host)
+}
+
+// parseAbsolute parses an http url, a protocol-relative url is taken as https
+func parseAbsolute(rawUrl string) (*url.URL, error) {
+	rawUrl = strings.TrimSpace(rawUrl)
+	if strings.HasPrefix(rawUrl, "//") {
+		rawUrl = "https:" + rawUrl
+	}
+	u, err := url.Parse(rawUrl)
+	if err != nil {
+		return nil, err
+	}
+	u.Scheme = strings.ToLower(u.Scheme)
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("an absolute http url is required: %s", rawUrl)
+	}
+	return u, nil
+}
+
+// canonicalHost lowercases the host and removes the default port of the scheme
+func canonicalHost(u *url.URL) string {
+	host := strings.TrimSuffix(strings.ToLower(u.Hostname()), ".")
+	if strings.Contains(host, ":") {
+		// ipv6
+		host = "[" + host + "]"
+	}
+	if port := u.Port(); port != "" && port != defaultPorts[u.Scheme] {
+		host += ":" + port
+	}
+	return host
+}
+
+// cleanPath removes the dot segments and duplicated slashes, the trailing slash is kept
+func cleanPath(p string) string {
+	if p == "" {
+		return "/"
+	}
+	cleaned := path.Clean("/" + p)
+	if strings.HasSuffix(p, "/") && cleaned != "/" {
+		cleaned += "/"
+	}
+	return cleaned
+}
+
+// encodeQuery sorts the params by name and keeps the order of the values of a param
+func encodeQuery(query url.Values) string {
+	names := make([]string, 0, len(query))
+	for name := range query {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var sb strings.Builder
+	for _, name := range names {
+		for _, value := range query[name] {
+			if sb.Len() > 0 {
+				sb.WriteByte('&')
+			}
+			sb.WriteString(url.QueryEscape(name))
+			sb.WriteByte('=')
+			sb.WriteString(url.QueryEscape(value))
+		}
+	}
+	return sb.String()
+}
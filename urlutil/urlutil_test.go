@@ -0,0 +1,79 @@
+package urlutil
+
+import "testing"
+
+func TestResolve(t *testing.T) {
+	page := "https://example.com/book/12/chapter1.html?from=list"
+	cases := map[string]string{
+		"chapter2.html":              "https://example.com/book/12/chapter2.html",
+		"../13/index.html":           "https://example.com/book/13/index.html",
+		"/search?q=go":               "https://example.com/search?q=go",
+		"//cdn.example.com/c.jpg":    "https://cdn.example.com/c.jpg",
+		"?page=2":                    "https://example.com/book/12/chapter1.html?page=2",
+		"http://other.com/a.html#p1": "http://other.com/a.html#p1",
+	}
+	for link, expected := range cases {
+		if resolved, err := Resolve(page, link); err != nil || resolved != expected {
+			t.Errorf("Resolve(%q) = %q, %v, expected %q", link, resolved, err, expected)
+		}
+	}
+	if _, err := Resolve("/relative/page.html", "a.html"); err == nil {
+		t.Error("a relative page url should be rejected")
+	}
+}
+
+func TestCanonicalize(t *testing.T) {
+	cases := map[string]string{
+		"HTTP://Example.COM:80":                               "http://example.com/",
+		"https://example.com:443/a/./b/../c.html#top":         "https://example.com/a/c.html",
+		"https://example.com:8443/list/":                      "https://example.com:8443/list/",
+		"https://example.com/list?page=2&b=1&utm_source=x":    "https://example.com/list?b=1&page=2",
+		"https://example.com/list?fbclid=abc&gclid=1":         "https://example.com/list",
+		"//example.com//a//b.html":                            "https://example.com/a/b.html",
+		"https://example.com/search?q=a+b&q=c&UTM_Medium=ads": "https://example.com/search?q=a+b&q=c",
+	}
+	for raw, expected := range cases {
+		if canonical, err := Canonicalize(raw); err != nil || canonical != expected {
+			t.Errorf("Canonicalize(%q) = %q, %v, expected %q", raw, canonical, err, expected)
+		}
+	}
+
+	canonical, err := Canonicalize("https://example.com/list?page=2&sort=new&ref=home",
+		WithQueryWhitelist("page"))
+	if err != nil || canonical != "https://example.com/list?page=2" {
+		t.Errorf("unexpected whitelisted url: %s, %v", canonical, err)
+	}
+	canonical, err = Canonicalize("https://example.com/list?page=2&ref=home", WithTrackingParams("REF"))
+	if err != nil || canonical != "https://example.com/list?page=2" {
+		t.Errorf("unexpected url without tracking params: %s, %v", canonical, err)
+	}
+	if _, err = Canonicalize("mailto:someone@example.com"); err == nil {
+		t.Error("a non-http url should be rejected")
+	}
+}
+
+func TestBaseUrlAndDomain(t *testing.T) {
+	bases := map[string]string{
+		"https://www.Example.com:443/a/b.html": "https://www.example.com",
+		"http://example.com:8080/a":            "http://example.com:8080",
+		"//example.com/a":                      "https://example.com",
+	}
+	for raw, expected := range bases {
+		if base, err := BaseUrl(raw); err != nil || base != expected {
+			t.Errorf("BaseUrl(%q) = %q, %v, expected %q", raw, base, err, expected)
+		}
+	}
+
+	domains := map[string]string{
+		"https://www.example.co.uk/book": "example.co.uk",
+		"m.novel.example.com":            "example.com",
+		"blog.example.com:8080":          "example.com",
+		"http://127.0.0.1:8080/a":        "127.0.0.1",
+		"localhost":                      "localhost",
+	}
+	for raw, expected := range domains {
+		if domain, err := RegistrableDomain(raw); err != nil || domain != expected {
+			t.Errorf("RegistrableDomain(%q) = %q, %v, expected %q", raw, domain, err, expected)
+		}
+	}
+}
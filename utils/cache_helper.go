@@ -11,9 +11,48 @@ import (
 
 type valueProvider func() (*string, error)
 
-// GetAndSet get a value from cache by key if presents otherwise set by value provider
+// ErrRedisDisabled is returned by the cache helpers if the system has no redis client
+var ErrRedisDisabled = errors.New("redis isn't enabled in the system")
+
+// CacheHelper caches the values in the redis of a system
+type CacheHelper struct {
+	sys *system.System
+}
+
+func NewCacheHelper(sys *system.System) *CacheHelper {
+	return &CacheHelper{sys: sys}
+}
+
+func (h *CacheHelper) client() (*redis.Client, error) {
+	if rd := h.sys.Redis(); rd != nil {
+		return rd.Client, nil
+	}
+	return nil, ErrRedisDisabled
+}
+
+// GetAndSet get a value from cache by key if presents otherwise set by value provider, the system is taken
+// from ctx, see system.FromContext
 func GetAndSet(ctx context.Context, key string, callback valueProvider) (val *string, err error) {
-	value, err := system.GetSystem().RedisClient.Client.Get(ctx, key).Result()
+	return NewCacheHelper(system.FromContext(ctx)).GetAndSet(ctx, key, callback)
+}
+
+// GetKey returns nil if the key doesn't exist, the system is taken from ctx, see system.FromContext
+func GetKey(ctx context.Context, key string) (*string, error) {
+	return NewCacheHelper(system.FromContext(ctx)).GetKey(ctx, key)
+}
+
+// Exists checks the key in redis and then in mongo, the system is taken from ctx, see system.FromContext
+func Exists(ctx context.Context, key string, searchMongoFunc func() (any, error)) (bool, error) {
+	return NewCacheHelper(system.FromContext(ctx)).Exists(ctx, key, searchMongoFunc)
+}
+
+// GetAndSet get a value from cache by key if presents otherwise set by value provider
+func (h *CacheHelper) GetAndSet(ctx context.Context, key string, callback valueProvider) (val *string, err error) {
+	rd, err := h.client()
+	if err != nil {
+		return nil, err
+	}
+	value, err := rd.Get(ctx, key).Result()
 	if err != nil {
 		if errors.Is(err, redis.Nil) {
 			val, err = callback()
@@ -23,7 +62,7 @@ func GetAndSet(ctx context.Context, key string, callback valueProvider) (val *st
 			if val == nil {
 				return
 			}
-			if _, err = system.GetSystem().RedisClient.Client.Set(ctx, key, *val, GenExpireTime()).Result(); err != nil {
+			if _, err = rd.Set(ctx, key, *val, GenExpireTime()).Result(); err != nil {
 				return nil, err
 			}
 			return
@@ -34,8 +73,12 @@ func GetAndSet(ctx context.Context, key string, callback valueProvider) (val *st
 	return &value, err
 }
 
-func GetKey(ctx context.Context, key string) (*string, error) {
-	value, err := system.GetSystem().RedisClient.Client.Get(ctx, key).Result()
+func (h *CacheHelper) GetKey(ctx context.Context, key string) (*string, error) {
+	rd, err := h.client()
+	if err != nil {
+		return nil, err
+	}
+	value, err := rd.Get(ctx, key).Result()
 	if err != nil {
 		if errors.Is(err, redis.Nil) {
 			return nil, nil
@@ -46,9 +89,11 @@ func GetKey(ctx context.Context, key string) (*string, error) {
 	return &value, err
 }
 
-func Exists(ctx context.Context, key string, searchMongoFunc func() (any, error)) (bool, error) {
-	sys := system.GetSystem()
-	rd := sys.RedisClient.Client
+func (h *CacheHelper) Exists(ctx context.Context, key string, searchMongoFunc func() (any, error)) (bool, error) {
+	rd, err := h.client()
+	if err != nil {
+		return false, err
+	}
 
 	//check if it exists in redis
 	if result, err := rd.Exists(ctx, key).Result(); err != nil {
@@ -0,0 +1,26 @@
+package utils
+
+import (
+	"context"
+	"errors"
+	"github.com/jeven2016/mylibs/system"
+	"testing"
+)
+
+func TestCacheHelperWithoutRedis(t *testing.T) {
+	ctx := system.NewContext(context.Background(), &system.System{})
+
+	if _, err := GetKey(ctx, "novel:1"); !errors.Is(err, ErrRedisDisabled) {
+		t.Fatalf("expected ErrRedisDisabled, got %v", err)
+	}
+	called := false
+	if _, err := GetAndSet(ctx, "novel:1", func() (*string, error) {
+		called = true
+		return nil, nil
+	}); !errors.Is(err, ErrRedisDisabled) || called {
+		t.Fatalf("expected ErrRedisDisabled without calling the provider, got %v", err)
+	}
+	if _, err := NewCacheHelper(nil).Exists(ctx, "novel:1", nil); !errors.Is(err, ErrRedisDisabled) {
+		t.Fatalf("expected ErrRedisDisabled, got %v", err)
+	}
+}
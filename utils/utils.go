@@ -2,13 +2,12 @@ package utils
 
 import (
 	"fmt"
+	"github.com/jeven2016/mylibs/urlutil"
 	"image"
 	"math/rand"
 	"net/url"
 	"os"
 	"path/filepath"
-	"regexp"
-	"strings"
 	"time"
 )
 
@@ -26,22 +25,24 @@ func GenExpireTime() time.Duration {
 	return time.Duration(rand.Intn(max-min)+min) * time.Minute
 }
 
+// ParseBaseUri returns the scheme and host of an url without the default port, e.g. https://example.com,
+// an empty string is returned if it isn't an absolute http url
 func ParseBaseUri(url string) string {
-	reg, err := regexp.Compile("(https?://[^/]*)")
+	base, err := urlutil.BaseUrl(url)
 	if err != nil {
-		// print log
 		return ""
 	}
-	subs := reg.FindStringSubmatch(url)
-
-	if len(subs) > 1 {
-		return subs[1]
-	}
-	return ""
+	return base
 }
 
-func BuildUrl(baseUri string, path string) string {
-	return strings.TrimSuffix(ParseBaseUri(baseUri), "/") + "/" + strings.TrimPrefix(path, "/")
+// BuildUrl resolves a link of a page against the page url, e.g. ../chapter2.html or /book/1?page=2,
+// the link is returned as is if it can't be resolved
+func BuildUrl(pageUrl string, link string) string {
+	resolved, err := urlutil.Resolve(pageUrl, link)
+	if err != nil {
+		return link
+	}
+	return resolved
 }
 
 func ValidJpgImage(jpgPath string) (valid bool, err error) {